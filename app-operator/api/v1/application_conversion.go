@@ -1,4 +1,197 @@
 package v1
 
-// Hub marks this type as a conversion hub.
-func (*Application) Hub() {}
+import (
+	"encoding/json"
+	"fmt"
+
+	dv2 "github.com/costa92/app-operator/api/v2"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// v2SpecAnnotation 保存 v1 中不存在的 v2 字段,转换回 v2 时恢复并删除,保证转换无损
+const v2SpecAnnotation = "apps.costalong.com/v2-spec"
+
+// v2Spec 是只在 v2 中存在的字段
+type v2Spec struct {
+	Owner       string                `json:"owner,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Links       []dv2.ApplicationLink `json:"links,omitempty"`
+}
+
+// ConvertTo converts this Application to the Hub version (v2).
+func (src *Application) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*dv2.Application)
+
+	// 注解会被修改,不能和 src 共用
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.Workflow = dv2.DeploymentTemplate(src.Spec.Deployment)
+	dst.Spec.Service = dv2.ServiceTemplate(src.Spec.Service)
+	dst.Spec.Strategy = strategyToV2(src.Spec.Strategy)
+	dst.Spec.RevisionHistoryLimit = src.Spec.RevisionHistoryLimit
+	dst.Spec.RollbackTo = (*dv2.RollbackConfig)(src.Spec.RollbackTo)
+	dst.Spec.Autoscaling = (*dv2.AutoscalingSpec)(src.Spec.Autoscaling)
+	dst.Spec.DisruptionBudget = (*dv2.DisruptionBudgetSpec)(src.Spec.DisruptionBudget)
+	dst.Spec.Ingress = (*dv2.IngressSpec)(src.Spec.Ingress)
+	if src.Spec.ConfigFiles != nil {
+		dst.Spec.ConfigFiles = make([]dv2.ConfigFile, len(src.Spec.ConfigFiles))
+		for i, file := range src.Spec.ConfigFiles {
+			dst.Spec.ConfigFiles[i] = dv2.ConfigFile(file)
+		}
+	}
+
+	if raw, ok := dst.Annotations[v2SpecAnnotation]; ok {
+		extra := v2Spec{}
+		if err := json.Unmarshal([]byte(raw), &extra); err != nil {
+			return fmt.Errorf("invalid %s annotation: %w", v2SpecAnnotation, err)
+		}
+		dst.Spec.Owner = extra.Owner
+		dst.Spec.Description = extra.Description
+		dst.Spec.Links = extra.Links
+		delete(dst.Annotations, v2SpecAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	dst.Status = statusToV2(src.Status)
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v2) to this version.
+func (dst *Application) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*dv2.Application)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.Deployment = DeploymentTemplate(src.Spec.Workflow)
+	dst.Spec.Service = ServiceTemplate(src.Spec.Service)
+	dst.Spec.Strategy = strategyFromV2(src.Spec.Strategy)
+	dst.Spec.RevisionHistoryLimit = src.Spec.RevisionHistoryLimit
+	dst.Spec.RollbackTo = (*RollbackConfig)(src.Spec.RollbackTo)
+	dst.Spec.Autoscaling = (*AutoscalingSpec)(src.Spec.Autoscaling)
+	dst.Spec.DisruptionBudget = (*DisruptionBudgetSpec)(src.Spec.DisruptionBudget)
+	dst.Spec.Ingress = (*IngressSpec)(src.Spec.Ingress)
+	if src.Spec.ConfigFiles != nil {
+		dst.Spec.ConfigFiles = make([]ConfigFile, len(src.Spec.ConfigFiles))
+		for i, file := range src.Spec.ConfigFiles {
+			dst.Spec.ConfigFiles[i] = ConfigFile(file)
+		}
+	}
+
+	extra := v2Spec{Owner: src.Spec.Owner, Description: src.Spec.Description, Links: src.Spec.Links}
+	if extra.Owner != "" || extra.Description != "" || len(extra.Links) > 0 {
+		raw, err := json.Marshal(extra)
+		if err != nil {
+			return err
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[v2SpecAnnotation] = string(raw)
+	}
+
+	dst.Status = statusFromV2(src.Status)
+
+	return nil
+}
+
+func strategyToV2(in *RolloutStrategy) *dv2.RolloutStrategy {
+	if in == nil {
+		return nil
+	}
+	out := &dv2.RolloutStrategy{BlueGreen: (*dv2.BlueGreenStrategy)(in.BlueGreen)}
+	if in.Canary != nil {
+		out.Canary = &dv2.CanaryStrategy{}
+		if in.Canary.Steps != nil {
+			out.Canary.Steps = make([]dv2.CanaryStep, len(in.Canary.Steps))
+			for i, step := range in.Canary.Steps {
+				out.Canary.Steps[i] = dv2.CanaryStep{Weight: step.Weight, Pause: (*dv2.RolloutPause)(step.Pause)}
+			}
+		}
+	}
+	return out
+}
+
+func strategyFromV2(in *dv2.RolloutStrategy) *RolloutStrategy {
+	if in == nil {
+		return nil
+	}
+	out := &RolloutStrategy{BlueGreen: (*BlueGreenStrategy)(in.BlueGreen)}
+	if in.Canary != nil {
+		out.Canary = &CanaryStrategy{}
+		if in.Canary.Steps != nil {
+			out.Canary.Steps = make([]CanaryStep, len(in.Canary.Steps))
+			for i, step := range in.Canary.Steps {
+				out.Canary.Steps[i] = CanaryStep{Weight: step.Weight, Pause: (*RolloutPause)(step.Pause)}
+			}
+		}
+	}
+	return out
+}
+
+// 两个版本的状态字段相同,只有枚举类型不同
+func statusToV2(in ApplicationStatus) dv2.ApplicationStatus {
+	out := dv2.ApplicationStatus{
+		Workflow:           in.Workflow,
+		Network:            in.Network,
+		ObservedGeneration: in.ObservedGeneration,
+		Phase:              dv2.ApplicationPhase(in.Phase),
+		Replicas:           in.Replicas,
+		ReadyReplicas:      in.ReadyReplicas,
+		Ready:              in.Ready,
+		ClusterIP:          in.ClusterIP,
+		ReadyEndpoints:     in.ReadyEndpoints,
+		CurrentRevision:    in.CurrentRevision,
+		PreviousRevision:   in.PreviousRevision,
+		Autoscaling:        in.Autoscaling,
+		DisruptionBudget:   in.DisruptionBudget,
+		Ingress:            in.Ingress,
+		Conditions:         in.Conditions,
+	}
+	if in.Rollout != nil {
+		out.Rollout = &dv2.RolloutStatus{
+			Phase:         dv2.RolloutPhase(in.Rollout.Phase),
+			StableHash:    in.Rollout.StableHash,
+			CanaryHash:    in.Rollout.CanaryHash,
+			CurrentStep:   in.Rollout.CurrentStep,
+			StepStartedAt: in.Rollout.StepStartedAt,
+			Message:       in.Rollout.Message,
+			Replicas:      in.Rollout.Replicas,
+		}
+	}
+	return out
+}
+
+func statusFromV2(in dv2.ApplicationStatus) ApplicationStatus {
+	out := ApplicationStatus{
+		Workflow:           in.Workflow,
+		Network:            in.Network,
+		ObservedGeneration: in.ObservedGeneration,
+		Phase:              ApplicationPhase(in.Phase),
+		Replicas:           in.Replicas,
+		ReadyReplicas:      in.ReadyReplicas,
+		Ready:              in.Ready,
+		ClusterIP:          in.ClusterIP,
+		ReadyEndpoints:     in.ReadyEndpoints,
+		CurrentRevision:    in.CurrentRevision,
+		PreviousRevision:   in.PreviousRevision,
+		Autoscaling:        in.Autoscaling,
+		DisruptionBudget:   in.DisruptionBudget,
+		Ingress:            in.Ingress,
+		Conditions:         in.Conditions,
+	}
+	if in.Rollout != nil {
+		out.Rollout = &RolloutStatus{
+			Phase:         RolloutPhase(in.Rollout.Phase),
+			StableHash:    in.Rollout.StableHash,
+			CanaryHash:    in.Rollout.CanaryHash,
+			CurrentStep:   in.Rollout.CurrentStep,
+			StepStartedAt: in.Rollout.StepStartedAt,
+			Message:       in.Rollout.Message,
+			Replicas:      in.Rollout.Replicas,
+		}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package v1
+
+import (
+	"math/rand"
+	"testing"
+
+	dv2 "github.com/costa92/app-operator/api/v2"
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// newFuzzer 返回生成随机 Application 的 fuzzer,Quantity 和 IntOrString 需要生成合法的值
+func newFuzzer(seed int64) *fuzz.Fuzzer {
+	scheme := runtime.NewScheme()
+	_ = AddToScheme(scheme)
+	_ = dv2.AddToScheme(scheme)
+	return fuzzer.FuzzerFor(fuzzer.MergeFuzzerFuncs(metafuzzer.Funcs, func(runtimeserializer.CodecFactory) []interface{} {
+		return []interface{}{
+			func(q *resource.Quantity, c fuzz.Continue) {
+				*q = *resource.NewQuantity(c.Int63n(1000), resource.DecimalSI)
+			},
+			func(v *intstr.IntOrString, c fuzz.Continue) {
+				if c.RandBool() {
+					*v = intstr.FromInt(c.Intn(1000))
+				} else {
+					*v = intstr.FromString(c.RandString())
+				}
+			},
+		}
+	}), rand.NewSource(seed), runtimeserializer.NewCodecFactory(scheme))
+}
+
+// FuzzApplicationSpokeRoundTrip v1 -> v2 -> v1 转换后内容不变
+func FuzzApplicationSpokeRoundTrip(f *testing.F) {
+	for seed := int64(0); seed < 100; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		src := &Application{}
+		newFuzzer(seed).Fuzz(src)
+		src.TypeMeta = metav1.TypeMeta{}
+		delete(src.Annotations, v2SpecAnnotation)
+
+		hub := &dv2.Application{}
+		if err := src.ConvertTo(hub); err != nil {
+			t.Fatalf("ConvertTo: %v", err)
+		}
+		dst := &Application{}
+		if err := dst.ConvertFrom(hub); err != nil {
+			t.Fatalf("ConvertFrom: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(src, dst) {
+			t.Errorf("v1 round trip changed the object:\n%s", diff.ObjectReflectDiff(src, dst))
+		}
+	})
+}
+
+// FuzzApplicationHubRoundTrip v2 -> v1 -> v2 转换后内容不变,v2 独有的字段通过注解保存
+func FuzzApplicationHubRoundTrip(f *testing.F) {
+	for seed := int64(0); seed < 100; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		src := &dv2.Application{}
+		newFuzzer(seed).Fuzz(src)
+		src.TypeMeta = metav1.TypeMeta{}
+		delete(src.Annotations, v2SpecAnnotation)
+
+		spoke := &Application{}
+		if err := spoke.ConvertFrom(src); err != nil {
+			t.Fatalf("ConvertFrom: %v", err)
+		}
+		dst := &dv2.Application{}
+		if err := spoke.ConvertTo(dst); err != nil {
+			t.Fatalf("ConvertTo: %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(src, dst) {
+			t.Errorf("v2 round trip changed the object:\n%s", diff.ObjectReflectDiff(src, dst))
+		}
+	})
+}
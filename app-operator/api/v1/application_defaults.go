@@ -0,0 +1,182 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/mutate-apps-costalong-com-v1-application,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps.costalong.com,resources=applications,verbs=create;update,versions=v1,name=mapplication.kb.io,admissionReviewVersions=v1
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=applicationdefaults,verbs=get;list;watch
+
+// AppliedDefaultsAnnotation 记录新建 Application 时使用了哪些默认值,值为字段名到 ApplicationDefaults 的 namespace/name 的 JSON
+const AppliedDefaultsAnnotation = "apps.costalong.com/applied-defaults"
+
+// ApplicationDefaulter 把 ApplicationDefaults 中的默认值合并到新建的 Application 中
+// +kubebuilder:object:generate=false
+type ApplicationDefaulter struct {
+	Reader client.Reader
+	// 集群默认值所在的命名空间,通常是 operator 所在的命名空间,为空时只使用 Application 所在命名空间的默认值
+	ClusterNamespace string
+}
+
+var _ webhook.CustomDefaulter = &ApplicationDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *ApplicationDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	app := obj.(*Application)
+	applicationlog.Info("default", "name", app.Name)
+
+	namespace := app.Namespace
+	req, err := admission.RequestFromContext(ctx)
+	if err == nil && namespace == "" {
+		namespace = req.Namespace
+	}
+
+	// 默认值只合并到新建的 Application 中,更新时不会改变已经存在的对象
+	if err == nil && req.Operation == admissionv1.Create {
+		defaults, err := d.lookup(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		applied := map[string]string{}
+		for i := range defaults {
+			mergeDefaults(app, &defaults[i], applied)
+		}
+		if len(applied) > 0 {
+			raw, err := json.Marshal(applied)
+			if err != nil {
+				return err
+			}
+			if app.Annotations == nil {
+				app.Annotations = map[string]string{}
+			}
+			app.Annotations[AppliedDefaultsAnnotation] = string(raw)
+		}
+	}
+	return nil
+}
+
+// lookup 返回按优先级排序的 ApplicationDefaults,命名空间中的在前,集群默认值在后,同一命名空间中按名字排序
+func (d *ApplicationDefaulter) lookup(ctx context.Context, namespace string) ([]ApplicationDefaults, error) {
+	namespaces := []string{namespace}
+	if d.ClusterNamespace != "" && d.ClusterNamespace != namespace {
+		namespaces = append(namespaces, d.ClusterNamespace)
+	}
+
+	var res []ApplicationDefaults
+	for _, ns := range namespaces {
+		list := &ApplicationDefaultsList{}
+		if err := d.Reader.List(ctx, list, client.InNamespace(ns)); err != nil {
+			applicationlog.Error(err, "Failed to list ApplicationDefaults.", "namespace", ns)
+			return nil, err
+		}
+		sort.Slice(list.Items, func(i, j int) bool {
+			return list.Items[i].Name < list.Items[j].Name
+		})
+		res = append(res, list.Items...)
+	}
+	return res, nil
+}
+
+// mergeDefaults 只填充 Application 中没有设置的字段,已经被前面的默认值填充的字段不会被覆盖
+func mergeDefaults(app *Application, defaults *ApplicationDefaults, applied map[string]string) {
+	source := defaults.Namespace + "/" + defaults.Name
+	spec := &defaults.Spec
+	pod := &app.Spec.Deployment.Template.Spec
+
+	if app.Spec.Deployment.Replicas == nil && spec.Replicas != nil {
+		replicas := *spec.Replicas
+		app.Spec.Deployment.Replicas = &replicas
+		applied["replicas"] = source
+	}
+
+	for k, v := range spec.Labels {
+		if _, ok := app.Labels[k]; ok {
+			continue
+		}
+		if app.Labels == nil {
+			app.Labels = map[string]string{}
+		}
+		app.Labels[k] = v
+		applied["labels"] = source
+	}
+
+	if pod.SecurityContext == nil && spec.PodSecurityContext != nil {
+		pod.SecurityContext = spec.PodSecurityContext.DeepCopy()
+		applied["podSecurityContext"] = source
+	}
+	if len(pod.ImagePullSecrets) == 0 && len(spec.ImagePullSecrets) > 0 {
+		pod.ImagePullSecrets = append([]corev1.LocalObjectReference{}, spec.ImagePullSecrets...)
+		applied["imagePullSecrets"] = source
+	}
+
+	containers := make([]*corev1.Container, 0, len(pod.InitContainers)+len(pod.Containers))
+	for i := range pod.InitContainers {
+		containers = append(containers, &pod.InitContainers[i])
+	}
+	for i := range pod.Containers {
+		containers = append(containers, &pod.Containers[i])
+	}
+	for _, c := range containers {
+		if spec.Resources != nil && mergeResources(&c.Resources, spec.Resources) {
+			applied["resources"] = source
+		}
+		if c.SecurityContext == nil && spec.SecurityContext != nil {
+			c.SecurityContext = spec.SecurityContext.DeepCopy()
+			applied["securityContext"] = source
+		}
+	}
+
+	// 探针只添加到普通容器中
+	for i := range pod.Containers {
+		c := &pod.Containers[i]
+		if c.LivenessProbe == nil && spec.LivenessProbe != nil {
+			c.LivenessProbe = spec.LivenessProbe.DeepCopy()
+			applied["livenessProbe"] = source
+		}
+		if c.ReadinessProbe == nil && spec.ReadinessProbe != nil {
+			c.ReadinessProbe = spec.ReadinessProbe.DeepCopy()
+			applied["readinessProbe"] = source
+		}
+	}
+}
+
+// mergeResources 按资源名称补齐 requests 和 limits,默认的 request 不超过已有的 limit,默认的 limit 不小于已有的 request
+func mergeResources(res *corev1.ResourceRequirements, defaults *corev1.ResourceRequirements) bool {
+	changed := false
+	for name, q := range defaults.Requests {
+		if _, ok := res.Requests[name]; ok {
+			continue
+		}
+		if limit, ok := res.Limits[name]; ok && q.Cmp(limit) > 0 {
+			q = limit
+		}
+		if res.Requests == nil {
+			res.Requests = corev1.ResourceList{}
+		}
+		res.Requests[name] = q.DeepCopy()
+		changed = true
+	}
+	for name, q := range defaults.Limits {
+		if _, ok := res.Limits[name]; ok {
+			continue
+		}
+		if request, ok := res.Requests[name]; ok && q.Cmp(request) < 0 {
+			continue
+		}
+		if res.Limits == nil {
+			res.Limits = corev1.ResourceList{}
+		}
+		res.Limits[name] = q.DeepCopy()
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,199 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newDefaults(namespace, name string, spec ApplicationDefaultsSpec) *ApplicationDefaults {
+	return &ApplicationDefaults{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Spec: spec}
+}
+
+func resourceList(cpu, memory string) corev1.ResourceList {
+	res := corev1.ResourceList{}
+	if cpu != "" {
+		res[corev1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		res[corev1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return res
+}
+
+func TestMergeResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		res      corev1.ResourceRequirements
+		defaults corev1.ResourceRequirements
+		want     corev1.ResourceRequirements
+		changed  bool
+	}{
+		{
+			name:     "empty",
+			defaults: corev1.ResourceRequirements{Requests: resourceList("100m", "64Mi"), Limits: resourceList("", "128Mi")},
+			want:     corev1.ResourceRequirements{Requests: resourceList("100m", "64Mi"), Limits: resourceList("", "128Mi")},
+			changed:  true,
+		},
+		{
+			name:     "existing values win",
+			res:      corev1.ResourceRequirements{Requests: resourceList("200m", ""), Limits: resourceList("", "256Mi")},
+			defaults: corev1.ResourceRequirements{Requests: resourceList("100m", "64Mi"), Limits: resourceList("", "128Mi")},
+			want:     corev1.ResourceRequirements{Requests: resourceList("200m", "64Mi"), Limits: resourceList("", "256Mi")},
+			changed:  true,
+		},
+		{
+			name:     "request clamped to existing limit",
+			res:      corev1.ResourceRequirements{Limits: resourceList("", "32Mi")},
+			defaults: corev1.ResourceRequirements{Requests: resourceList("", "64Mi")},
+			want:     corev1.ResourceRequirements{Requests: resourceList("", "32Mi"), Limits: resourceList("", "32Mi")},
+			changed:  true,
+		},
+		{
+			name:     "limit below existing request skipped",
+			res:      corev1.ResourceRequirements{Requests: resourceList("", "512Mi")},
+			defaults: corev1.ResourceRequirements{Limits: resourceList("", "128Mi")},
+			want:     corev1.ResourceRequirements{Requests: resourceList("", "512Mi")},
+		},
+		{
+			name:     "all set",
+			res:      corev1.ResourceRequirements{Requests: resourceList("100m", "64Mi"), Limits: resourceList("", "128Mi")},
+			defaults: corev1.ResourceRequirements{Requests: resourceList("1", "1Gi"), Limits: resourceList("", "2Gi")},
+			want:     corev1.ResourceRequirements{Requests: resourceList("100m", "64Mi"), Limits: resourceList("", "128Mi")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := tt.res
+			if changed := mergeResources(&res, &tt.defaults); changed != tt.changed {
+				t.Errorf("mergeResources() changed = %v, want %v", changed, tt.changed)
+			}
+			if !equality.Semantic.DeepEqual(res, tt.want) {
+				t.Errorf("mergeResources() = %v, want %v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeDefaults(t *testing.T) {
+	// lookup 返回的顺序:命名空间中的默认值在前,集群默认值在后
+	namespaced := newDefaults("default", "team", ApplicationDefaultsSpec{
+		Replicas:  int32Ptr(2),
+		Labels:    map[string]string{"team": "web"},
+		Resources: &corev1.ResourceRequirements{Requests: resourceList("", "64Mi")},
+	})
+	cluster := newDefaults("app-operator-system", "cluster", ApplicationDefaultsSpec{
+		Replicas:         int32Ptr(5),
+		Labels:           map[string]string{"team": "platform", "tier": "backend"},
+		Resources:        &corev1.ResourceRequirements{Requests: resourceList("100m", "1Gi"), Limits: resourceList("", "128Mi")},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+		ReadinessProbe:   &corev1.Probe{PeriodSeconds: 5},
+	})
+
+	app := newValidApplication()
+	app.Spec.Deployment.Replicas = nil
+	app.Spec.Deployment.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "ghcr.io/costa92/init"}}
+	app.Spec.Deployment.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{}
+
+	applied := map[string]string{}
+	for _, d := range []*ApplicationDefaults{namespaced, cluster} {
+		mergeDefaults(app, d, applied)
+	}
+
+	if got := *app.Spec.Deployment.Replicas; got != 2 {
+		t.Errorf("replicas = %d, want 2", got)
+	}
+	if want := map[string]string{"app": "demo", "team": "web", "tier": "backend"}; !equality.Semantic.DeepEqual(app.Labels, want) {
+		t.Errorf("labels = %v, want %v", app.Labels, want)
+	}
+	wantResources := corev1.ResourceRequirements{Requests: resourceList("100m", "64Mi"), Limits: resourceList("", "128Mi")}
+	for _, c := range append(app.Spec.Deployment.Template.Spec.InitContainers, app.Spec.Deployment.Template.Spec.Containers...) {
+		if !equality.Semantic.DeepEqual(c.Resources, wantResources) {
+			t.Errorf("container %s resources = %v, want %v", c.Name, c.Resources, wantResources)
+		}
+	}
+	if app.Spec.Deployment.Template.Spec.InitContainers[0].ReadinessProbe != nil {
+		t.Errorf("readiness probe added to init container")
+	}
+	if app.Spec.Deployment.Template.Spec.Containers[0].ReadinessProbe == nil {
+		t.Errorf("readiness probe not added to container")
+	}
+
+	wantApplied := map[string]string{
+		"replicas":         "default/team",
+		"labels":           "app-operator-system/cluster",
+		"resources":        "app-operator-system/cluster",
+		"imagePullSecrets": "app-operator-system/cluster",
+		"readinessProbe":   "app-operator-system/cluster",
+	}
+	if !equality.Semantic.DeepEqual(applied, wantApplied) {
+		t.Errorf("applied = %v, want %v", applied, wantApplied)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = AddToScheme(scheme)
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newDefaults("app-operator-system", "a", ApplicationDefaultsSpec{Replicas: int32Ptr(5)}),
+		newDefaults("default", "b", ApplicationDefaultsSpec{Replicas: int32Ptr(3)}),
+		newDefaults("default", "a", ApplicationDefaultsSpec{Labels: map[string]string{"team": "web"}}),
+		newDefaults("other", "a", ApplicationDefaultsSpec{Replicas: int32Ptr(1)}),
+	).Build()
+	d := &ApplicationDefaulter{Reader: reader, ClusterNamespace: "app-operator-system"}
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		want      map[string]string
+	}{
+		{
+			name:      "create",
+			operation: admissionv1.Create,
+			want:      map[string]string{"labels": "default/a", "replicas": "default/b"},
+		},
+		{
+			name:      "update",
+			operation: admissionv1.Update,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newValidApplication()
+			app.Spec.Deployment.Replicas = nil
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{Operation: tt.operation, Namespace: "default"},
+			})
+			if err := d.Default(ctx, app); err != nil {
+				t.Fatalf("Default() error = %v", err)
+			}
+
+			raw, ok := app.Annotations[AppliedDefaultsAnnotation]
+			if tt.want == nil {
+				if ok || app.Spec.Deployment.Replicas != nil {
+					t.Errorf("defaults applied on %s", tt.operation)
+				}
+				return
+			}
+			applied := map[string]string{}
+			if err := json.Unmarshal([]byte(raw), &applied); err != nil {
+				t.Fatalf("unmarshal %s annotation: %v", AppliedDefaultsAnnotation, err)
+			}
+			if !equality.Semantic.DeepEqual(applied, tt.want) {
+				t.Errorf("applied = %v, want %v", applied, tt.want)
+			}
+			if got := *app.Spec.Deployment.Replicas; got != 3 {
+				t.Errorf("replicas = %d, want 3", got)
+			}
+		})
+	}
+}
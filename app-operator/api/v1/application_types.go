@@ -18,8 +18,12 @@ package v1
 
 import (
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -29,6 +33,108 @@ import (
 type ApplicationSpec struct {
 	Deployment DeploymentTemplate `json:"deployment,omitempty"`
 	Service    ServiceTemplate    `json:"service,omitempty"`
+	// 发布策略,为空时使用 Deployment 原生的滚动更新
+	Strategy *RolloutStrategy `json:"strategy,omitempty"`
+	// 保留的历史版本数量,默认为 10
+	//+kubebuilder:validation:Minimum=1
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// 回滚到指定的历史版本,回滚完成后会被清空
+	RollbackTo *RollbackConfig `json:"rollbackTo,omitempty"`
+
+	// 以下为可选的子资源,删除对应的配置时子资源也会被删除
+
+	// 水平自动扩缩容,设置后 Deployment 的副本数由 HPA 决定
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+	// Pod 中断预算,限制主动驱逐时同时不可用的副本数
+	DisruptionBudget *DisruptionBudgetSpec `json:"disruptionBudget,omitempty"`
+	// 通过 Ingress 暴露 Service
+	Ingress *IngressSpec `json:"ingress,omitempty"`
+	// 配置文件,每一项生成一个 ConfigMap 并挂载到所有容器中
+	ConfigFiles []ConfigFile `json:"configFiles,omitempty"`
+}
+
+// AutoscalingSpec 生成 HorizontalPodAutoscaler,CPU 和内存都没有设置时按 CPU 使用率 80% 扩缩容
+type AutoscalingSpec struct {
+	//+kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// CPU 和内存的目标使用率,百分比
+	TargetCPUUtilization    *int32 `json:"targetCPUUtilization,omitempty"`
+	TargetMemoryUtilization *int32 `json:"targetMemoryUtilization,omitempty"`
+}
+
+// DisruptionBudgetSpec 生成 PodDisruptionBudget,只能设置一个,都没有设置时 maxUnavailable 为 1
+type DisruptionBudgetSpec struct {
+	MinAvailable   *intstr.IntOrString `json:"minAvailable,omitempty"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// IngressSpec 生成转发到 Application 的 Service 的 Ingress
+type IngressSpec struct {
+	ClassName *string `json:"className,omitempty"`
+	Host      string  `json:"host"`
+	// 转发的路径前缀,默认为 /
+	Path string `json:"path,omitempty"`
+	// 转发到 Service 的端口,默认为 Service 的第一个端口
+	ServicePort *int32 `json:"servicePort,omitempty"`
+	// 设置后启用 TLS
+	TLSSecretName string            `json:"tlsSecretName,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ConfigFile 生成名为 {name}-{file.name} 的 ConfigMap,以只读的方式挂载到 mountPath
+type ConfigFile struct {
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	// 文件名到文件内容
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// RollbackConfig 回滚的目标版本
+type RollbackConfig struct {
+	// 历史版本号,见 status.currentRevision 和 status.previousRevision
+	//+kubebuilder:validation:Minimum=1
+	Revision int64 `json:"revision"`
+}
+
+// RollbackAnnotation 也可以通过注解回滚到指定的历史版本,值为版本号,回滚完成后会被删除
+const RollbackAnnotation = "apps.costalong.com/rollback-to"
+
+// RolloutStrategy 渐进式发布策略,canary 和 blueGreen 只能设置一个
+type RolloutStrategy struct {
+	Canary    *CanaryStrategy    `json:"canary,omitempty"`
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// CanaryStrategy 金丝雀发布,按步骤逐步增加新版本副本的比例,全部步骤完成后替换稳定版本
+type CanaryStrategy struct {
+	Steps []CanaryStep `json:"steps,omitempty"`
+}
+
+// CanaryStep 金丝雀发布的一个步骤,设置新版本的流量比例或者暂停
+type CanaryStep struct {
+	// 新版本副本占总副本数的百分比,Service 按副本数分配流量
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=100
+	Weight *int32 `json:"weight,omitempty"`
+	// 暂停发布,没有设置时长时需要手动推进
+	Pause *RolloutPause `json:"pause,omitempty"`
+}
+
+// RolloutPause 发布暂停的时长
+type RolloutPause struct {
+	// 暂停的时长,为空时一直暂停,直到 Application 上添加了 apps.costalong.com/promote 注解
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// BlueGreenStrategy 蓝绿发布,新版本全部就绪后通过预览 Service 验证,切换后替换稳定版本
+type BlueGreenStrategy struct {
+	// 指向新版本的预览 Service 的名字,默认为 {name}-preview,不能和 Application 同名
+	PreviewService string `json:"previewService,omitempty"`
+	// 新版本就绪后是否自动切换,为 false 时需要添加 apps.costalong.com/promote 注解
+	AutoPromotion bool `json:"autoPromotion,omitempty"`
 }
 
 type DeploymentTemplate struct {
@@ -39,19 +145,99 @@ type ServiceTemplate struct {
 	corev1.ServiceSpec `json:",inline"`
 }
 
+// ApplicationPhase 是 Application 的整体状态
+type ApplicationPhase string
+
+const (
+	// ApplicationPending Deployment 还没有创建出 Pod
+	ApplicationPending ApplicationPhase = "Pending"
+	// ApplicationProgressing Deployment 正在滚动更新
+	ApplicationProgressing ApplicationPhase = "Progressing"
+	// ApplicationRunning 所有副本都已经就绪
+	ApplicationRunning ApplicationPhase = "Running"
+	// ApplicationDegraded 滚动更新超时或者副本创建失败
+	ApplicationDegraded ApplicationPhase = "Degraded"
+)
+
+// Application 的状态条件
+const (
+	ConditionAvailable   = "Available"
+	ConditionProgressing = "Progressing"
+	ConditionDegraded    = "Degraded"
+)
+
 // ApplicationStatus defines the observed state of Application
 type ApplicationStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-
 	Workflow appsv1.DeploymentStatus `json:"workflow"`
 	Network  corev1.ServiceStatus    `json:"network"`
+
+	// 最近一次处理的 Application 的 generation
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Application 的整体状态
+	Phase ApplicationPhase `json:"phase,omitempty"`
+	// 期望的副本数和就绪的副本数,以及便于查看的摘要,例如 2/3
+	Replicas      int32  `json:"replicas,omitempty"`
+	ReadyReplicas int32  `json:"readyReplicas,omitempty"`
+	Ready         string `json:"ready,omitempty"`
+	// Service 分配的 ClusterIP 和就绪的 Endpoint 数量
+	ClusterIP      string `json:"clusterIP,omitempty"`
+	ReadyEndpoints int32  `json:"readyEndpoints,omitempty"`
+	// 渐进式发布的状态
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+	// 当前版本和上一个版本的版本号,记录在 ControllerRevision 中
+	CurrentRevision  int64 `json:"currentRevision,omitempty"`
+	PreviousRevision int64 `json:"previousRevision,omitempty"`
+	// 可选子资源的状态,没有启用时为空
+	Autoscaling      *autoscalingv2.HorizontalPodAutoscalerStatus `json:"autoscaling,omitempty"`
+	DisruptionBudget *policyv1.PodDisruptionBudgetStatus          `json:"disruptionBudget,omitempty"`
+	Ingress          *networkingv1.IngressStatus                  `json:"ingress,omitempty"`
+	// Available、Progressing 和 Degraded 状态条件
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// RolloutPhase 渐进式发布的阶段
+type RolloutPhase string
+
+const (
+	// RolloutHealthy 没有正在进行的发布
+	RolloutHealthy RolloutPhase = "Healthy"
+	// RolloutProgressing 新版本正在按步骤发布
+	RolloutProgressing RolloutPhase = "Progressing"
+	// RolloutPaused 等待手动推进
+	RolloutPaused RolloutPhase = "Paused"
+	// RolloutPromoting 新版本正在替换稳定版本
+	RolloutPromoting RolloutPhase = "Promoting"
+	// RolloutAborted 新版本不可用,已经自动回滚到稳定版本
+	RolloutAborted RolloutPhase = "Aborted"
+)
+
+// RolloutStatus 渐进式发布的状态
+type RolloutStatus struct {
+	Phase RolloutPhase `json:"phase,omitempty"`
+	// 稳定版本和新版本的 Pod 模板的 hash
+	StableHash string `json:"stableHash,omitempty"`
+	CanaryHash string `json:"canaryHash,omitempty"`
+	// 金丝雀发布当前的步骤和步骤开始的时间
+	CurrentStep   int32        `json:"currentStep,omitempty"`
+	StepStartedAt *metav1.Time `json:"stepStartedAt,omitempty"`
+	Message       string       `json:"message,omitempty"`
+	// 发布开始时的总副本数,启用 HPA 时发布期间按该副本数计算新版本的副本数
+	Replicas int32 `json:"replicas,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:resource:path=applications,singular=application,scope=Namespaced,shortName=app
-//+kubebuilder:storageversion
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+//+kubebuilder:printcolumn:name="Available",type=string,JSONPath=`.status.conditions[?(@.type=="Available")].status`
+//+kubebuilder:printcolumn:name="Cluster-IP",type=string,JSONPath=`.status.clusterIP`
+//+kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.status.currentRevision`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Application is the Schema for the applications API
 type Application struct {
@@ -0,0 +1,266 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+//+kubebuilder:webhook:path=/validate-apps-costalong-com-v1-application,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.costalong.com,resources=applications,verbs=create;update,versions=v1,name=vapplication.kb.io,admissionReviewVersions=v1
+
+// ApplicationValidator 校验 Application,一次返回所有不合法的字段
+type ApplicationValidator struct {
+	// 副本数的范围,同时限制 HPA 的最小和最大副本数
+	MinReplicas int32
+	MaxReplicas int32
+	// 允许使用的镜像仓库,例如 docker.io、ghcr.io,为空时不限制
+	AllowedRegistries []string
+	// 是否要求所有容器设置 CPU 和内存的 requests 以及内存的 limits
+	RequireResources bool
+}
+
+var _ webhook.CustomValidator = &ApplicationValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ApplicationValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	app := obj.(*Application)
+	applicationlog.Info("validate create", "name", app.Name)
+
+	return toInvalid(app, v.validate(app))
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+// 只报告这次修改新出现的错误,operator 的配置收紧之后,已经存在的 Application 仍然可以修改其他字段,
+// 回滚和删除 promote 注解等 operator 发起的更新也不会被拒绝
+func (v *ApplicationValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	app, old := newObj.(*Application), oldObj.(*Application)
+	applicationlog.Info("validate update", "name", app.Name)
+
+	errs := validateImmutable(app, old)
+	// spec 和标签都没有变化时不需要重新校验,例如只修改了注解
+	if !equality.Semantic.DeepEqual(app.Spec, old.Spec) || !equality.Semantic.DeepEqual(app.Labels, old.Labels) {
+		errs = append(errs, ratchet(v.validate(app), v.validate(old))...)
+	}
+	return toInvalid(app, errs)
+}
+
+// ratchet 去掉旧对象中已经存在的错误,字段的值没有变化时错误相同,只保留修改后新出现的错误
+func ratchet(errs, existing field.ErrorList) field.ErrorList {
+	var res field.ErrorList
+	for _, err := range errs {
+		found := false
+		for _, e := range existing {
+			if e.Type == err.Type && e.Field == err.Field && e.Detail == err.Detail &&
+				equality.Semantic.DeepEqual(e.BadValue, err.BadValue) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, err)
+		}
+	}
+	return res
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ApplicationValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func toInvalid(app *Application, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Application").GroupKind(), app.Name, errs)
+}
+
+func (v *ApplicationValidator) validate(app *Application) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	dpPath := specPath.Child("deployment")
+	podPath := dpPath.Child("template", "spec")
+
+	errs = append(errs, v.validateReplicas(app, specPath)...)
+
+	pod := &app.Spec.Deployment.Template.Spec
+	if len(pod.Containers) == 0 {
+		errs = append(errs, field.Required(podPath.Child("containers"), "at least one container is required"))
+	}
+	for i := range pod.InitContainers {
+		errs = append(errs, v.validateContainer(&pod.InitContainers[i], podPath.Child("initContainers").Index(i))...)
+	}
+	for i := range pod.Containers {
+		errs = append(errs, v.validateContainer(&pod.Containers[i], podPath.Child("containers").Index(i))...)
+	}
+
+	errs = append(errs, validateSelector(app, dpPath)...)
+	errs = append(errs, validateServicePorts(app, specPath.Child("service", "ports"))...)
+
+	if s := app.Spec.Strategy; s != nil && s.Canary != nil && s.BlueGreen != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("strategy"), "canary and blueGreen are mutually exclusive"))
+	}
+	// 预览 Service 和稳定版本的 Service 同名时会互相覆盖 selector
+	if s := app.Spec.Strategy; s != nil && s.BlueGreen != nil && s.BlueGreen.PreviewService == app.Name {
+		errs = append(errs, field.Invalid(specPath.Child("strategy", "blueGreen", "previewService"), s.BlueGreen.PreviewService,
+			"must differ from the name of the Application, which is used by the stable Service"))
+	}
+	if app.Spec.Ingress != nil && app.Spec.Ingress.ServicePort == nil && len(app.Spec.Service.Ports) == 0 {
+		errs = append(errs, field.Required(specPath.Child("ingress", "servicePort"), "the Service has no ports"))
+	}
+	return errs
+}
+
+// validateReplicas 副本数和 HPA 的副本数都需要在 operator 配置的范围内
+func (v *ApplicationValidator) validateReplicas(app *Application, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	inRange := func(value int32, path *field.Path) {
+		if (v.MinReplicas > 0 && value < v.MinReplicas) || (v.MaxReplicas > 0 && value > v.MaxReplicas) {
+			errs = append(errs, field.Invalid(path, value,
+				fmt.Sprintf("must be between %d and %d", v.MinReplicas, v.MaxReplicas)))
+		}
+	}
+
+	if r := app.Spec.Deployment.Replicas; r != nil {
+		inRange(*r, specPath.Child("deployment", "replicas"))
+	}
+	if as := app.Spec.Autoscaling; as != nil {
+		asPath := specPath.Child("autoscaling")
+		inRange(as.MaxReplicas, asPath.Child("maxReplicas"))
+		if as.MinReplicas != nil {
+			inRange(*as.MinReplicas, asPath.Child("minReplicas"))
+			if *as.MinReplicas > as.MaxReplicas {
+				errs = append(errs, field.Invalid(asPath.Child("minReplicas"), *as.MinReplicas,
+					"must not be greater than maxReplicas"))
+			}
+		}
+	}
+	return errs
+}
+
+func (v *ApplicationValidator) validateContainer(c *corev1.Container, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if c.Image == "" {
+		errs = append(errs, field.Required(path.Child("image"), ""))
+	} else if len(v.AllowedRegistries) > 0 {
+		registry := imageRegistry(c.Image)
+		allowed := false
+		for _, r := range v.AllowedRegistries {
+			if r == registry {
+				allowed = true
+			}
+		}
+		if !allowed {
+			err := field.Forbidden(path.Child("image"),
+				fmt.Sprintf("registry %q is not allowed, allowed registries: %s", registry, strings.Join(v.AllowedRegistries, ", ")))
+			// 错误信息中不包含镜像,记录下来用于判断更新时镜像是否发生了变化
+			err.BadValue = c.Image
+			errs = append(errs, err)
+		}
+	}
+
+	if v.RequireResources {
+		resPath := path.Child("resources")
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			if _, ok := c.Resources.Requests[name]; !ok {
+				errs = append(errs, field.Required(resPath.Child("requests").Key(string(name)), ""))
+			}
+		}
+		if _, ok := c.Resources.Limits[corev1.ResourceMemory]; !ok {
+			errs = append(errs, field.Required(resPath.Child("limits").Key(string(corev1.ResourceMemory)), ""))
+		}
+	}
+	return errs
+}
+
+// imageRegistry 返回镜像所在的仓库,没有写仓库的镜像来自 docker.io
+func imageRegistry(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return "docker.io"
+}
+
+// validateSelector Pod 模板的标签会被设置为 Application 的标签,selector 需要能够选中这些标签
+func validateSelector(app *Application, dpPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	selPath := dpPath.Child("selector")
+
+	if app.Spec.Deployment.Selector == nil {
+		return append(errs, field.Required(selPath, ""))
+	}
+	selector, err := metav1.LabelSelectorAsSelector(app.Spec.Deployment.Selector)
+	if err != nil {
+		return append(errs, field.Invalid(selPath, app.Spec.Deployment.Selector, err.Error()))
+	}
+	if selector.Empty() {
+		return append(errs, field.Invalid(selPath, app.Spec.Deployment.Selector, "empty selector is not allowed"))
+	}
+	if !selector.Matches(labels.Set(app.Labels)) {
+		errs = append(errs, field.Invalid(selPath, app.Spec.Deployment.Selector,
+			"does not match the Application labels, which are applied to the pod template"))
+	}
+	if tplLabels := app.Spec.Deployment.Template.Labels; len(tplLabels) > 0 && !selector.Matches(labels.Set(tplLabels)) {
+		errs = append(errs, field.Invalid(dpPath.Child("template", "metadata", "labels"), tplLabels,
+			"does not match the selector"))
+	}
+	return errs
+}
+
+// validateServicePorts Service 的 targetPort 需要对应容器声明的端口
+func validateServicePorts(app *Application, portsPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	numbers, names := map[int32]bool{}, map[string]bool{}
+	for _, c := range app.Spec.Deployment.Template.Spec.Containers {
+		for _, p := range c.Ports {
+			numbers[p.ContainerPort] = true
+			if p.Name != "" {
+				names[p.Name] = true
+			}
+		}
+	}
+
+	for i, p := range app.Spec.Service.Ports {
+		target := p.TargetPort
+		if target.Type == intstr.Int && target.IntVal == 0 {
+			target = intstr.FromInt(int(p.Port))
+		}
+		if target.Type == intstr.String && !names[target.StrVal] {
+			errs = append(errs, field.Invalid(portsPath.Index(i).Child("targetPort"), target.StrVal,
+				"no container declares a port with this name"))
+		}
+		if target.Type == intstr.Int && !numbers[target.IntVal] {
+			errs = append(errs, field.Invalid(portsPath.Index(i).Child("targetPort"), target.IntVal,
+				"no container declares this port"))
+		}
+	}
+	return errs
+}
+
+// validateImmutable Deployment 的 selector 和 Service 已分配的 clusterIP 不能修改
+func validateImmutable(app, old *Application) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	errs = append(errs, apivalidation.ValidateImmutableField(app.Spec.Deployment.Selector,
+		old.Spec.Deployment.Selector, specPath.Child("deployment", "selector"))...)
+	if old.Spec.Service.ClusterIP != "" {
+		errs = append(errs, apivalidation.ValidateImmutableField(app.Spec.Service.ClusterIP,
+			old.Spec.Service.ClusterIP, specPath.Child("service", "clusterIP"))...)
+	}
+	return errs
+}
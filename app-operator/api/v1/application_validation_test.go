@@ -0,0 +1,308 @@
+package v1
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func int32Ptr(n int32) *int32 { return &n }
+
+// newValidApplication 返回能够通过校验的 Application
+func newValidApplication() *Application {
+	app := &Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Labels: map[string]string{"app": "demo"}},
+	}
+	app.Spec.Deployment.Replicas = int32Ptr(2)
+	app.Spec.Deployment.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}}
+	app.Spec.Deployment.Template.Spec.Containers = []corev1.Container{{
+		Name:  "demo",
+		Image: "ghcr.io/costa92/demo:v1",
+		Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+		},
+	}}
+	app.Spec.Service.Ports = []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("http")}}
+	return app
+}
+
+func newTestValidator() *ApplicationValidator {
+	return &ApplicationValidator{
+		MinReplicas:       1,
+		MaxReplicas:       10,
+		AllowedRegistries: []string{"ghcr.io"},
+		RequireResources:  true,
+	}
+}
+
+// errorFields 返回错误的字段路径,按字母排序
+func errorFields(errs field.ErrorList) []string {
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func equalFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(app *Application)
+		want   []string
+	}{
+		{
+			name:   "valid",
+			mutate: func(app *Application) {},
+		},
+		{
+			name:   "replicas out of range",
+			mutate: func(app *Application) { app.Spec.Deployment.Replicas = int32Ptr(11) },
+			want:   []string{"spec.deployment.replicas"},
+		},
+		{
+			name: "autoscaling minReplicas greater than maxReplicas",
+			mutate: func(app *Application) {
+				app.Spec.Autoscaling = &AutoscalingSpec{MinReplicas: int32Ptr(5), MaxReplicas: 3}
+			},
+			want: []string{"spec.autoscaling.minReplicas"},
+		},
+		{
+			name: "no containers",
+			mutate: func(app *Application) {
+				app.Spec.Deployment.Template.Spec.Containers = nil
+				app.Spec.Service.Ports = nil
+			},
+			want: []string{"spec.deployment.template.spec.containers"},
+		},
+		{
+			name:   "missing image",
+			mutate: func(app *Application) { app.Spec.Deployment.Template.Spec.Containers[0].Image = "" },
+			want:   []string{"spec.deployment.template.spec.containers[0].image"},
+		},
+		{
+			name:   "registry not allowed",
+			mutate: func(app *Application) { app.Spec.Deployment.Template.Spec.Containers[0].Image = "nginx" },
+			want:   []string{"spec.deployment.template.spec.containers[0].image"},
+		},
+		{
+			name: "init container without resources",
+			mutate: func(app *Application) {
+				app.Spec.Deployment.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "ghcr.io/costa92/init"}}
+			},
+			want: []string{
+				"spec.deployment.template.spec.initContainers[0].resources.limits[memory]",
+				"spec.deployment.template.spec.initContainers[0].resources.requests[cpu]",
+				"spec.deployment.template.spec.initContainers[0].resources.requests[memory]",
+			},
+		},
+		{
+			name:   "missing selector",
+			mutate: func(app *Application) { app.Spec.Deployment.Selector = nil },
+			want:   []string{"spec.deployment.selector"},
+		},
+		{
+			name:   "selector does not match labels",
+			mutate: func(app *Application) { app.Labels = map[string]string{"app": "other"} },
+			want:   []string{"spec.deployment.selector"},
+		},
+		{
+			name: "template labels do not match selector",
+			mutate: func(app *Application) {
+				app.Spec.Deployment.Template.Labels = map[string]string{"app": "other"}
+			},
+			want: []string{"spec.deployment.template.metadata.labels"},
+		},
+		{
+			name: "canary and blueGreen",
+			mutate: func(app *Application) {
+				app.Spec.Strategy = &RolloutStrategy{Canary: &CanaryStrategy{}, BlueGreen: &BlueGreenStrategy{}}
+			},
+			want: []string{"spec.strategy"},
+		},
+		{
+			name: "preview service named after the application",
+			mutate: func(app *Application) {
+				app.Spec.Strategy = &RolloutStrategy{BlueGreen: &BlueGreenStrategy{PreviewService: "demo"}}
+			},
+			want: []string{"spec.strategy.blueGreen.previewService"},
+		},
+		{
+			name: "custom preview service",
+			mutate: func(app *Application) {
+				app.Spec.Strategy = &RolloutStrategy{BlueGreen: &BlueGreenStrategy{PreviewService: "demo-next"}}
+			},
+		},
+		{
+			name: "ingress without service ports",
+			mutate: func(app *Application) {
+				app.Spec.Service.Ports = nil
+				app.Spec.Ingress = &IngressSpec{Host: "demo.example.com"}
+			},
+			want: []string{"spec.ingress.servicePort"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newValidApplication()
+			tt.mutate(app)
+			if got := errorFields(newTestValidator().validate(app)); !equalFields(got, tt.want) {
+				t.Errorf("validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageRegistry(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "nginx", want: "docker.io"},
+		{image: "nginx:1.25", want: "docker.io"},
+		{image: "library/nginx", want: "docker.io"},
+		{image: "docker.io/library/nginx", want: "docker.io"},
+		{image: "ghcr.io/costa92/demo:v1", want: "ghcr.io"},
+		{image: "registry:5000/demo", want: "registry:5000"},
+		{image: "localhost/demo", want: "localhost"},
+	}
+	for _, tt := range tests {
+		if got := imageRegistry(tt.image); got != tt.want {
+			t.Errorf("imageRegistry(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestValidateServicePorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []corev1.ServicePort
+		want  []string
+	}{
+		{
+			name:  "named target port",
+			ports: []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("http")}},
+		},
+		{
+			name:  "numeric target port",
+			ports: []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}},
+		},
+		{
+			name:  "target port defaults to port",
+			ports: []corev1.ServicePort{{Port: 8080}},
+		},
+		{
+			name:  "default target port not declared",
+			ports: []corev1.ServicePort{{Port: 80}},
+			want:  []string{"spec.service.ports[0].targetPort"},
+		},
+		{
+			name: "unknown name and number",
+			ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("web")},
+				{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9090)},
+			},
+			want: []string{"spec.service.ports[0].targetPort", "spec.service.ports[1].targetPort"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newValidApplication()
+			app.Spec.Service.Ports = tt.ports
+			got := errorFields(validateServicePorts(app, field.NewPath("spec", "service", "ports")))
+			if !equalFields(got, tt.want) {
+				t.Errorf("validateServicePorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUpdateRatchet(t *testing.T) {
+	// 在 operator 收紧配置之前创建的 Application,副本数和镜像仓库都不再被允许
+	existing := func() *Application {
+		app := newValidApplication()
+		app.Spec.Deployment.Replicas = int32Ptr(20)
+		app.Spec.Deployment.Template.Spec.Containers[0].Image = "nginx:1.24"
+		return app
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(app *Application)
+		wantErr bool
+	}{
+		{
+			name:   "annotations only",
+			mutate: func(app *Application) { app.Annotations = map[string]string{"apps.costalong.com/promote": "true"} },
+		},
+		{
+			name: "unrelated field",
+			mutate: func(app *Application) {
+				app.Spec.Deployment.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "DEBUG", Value: "1"}}
+			},
+		},
+		{
+			name:   "fix replicas",
+			mutate: func(app *Application) { app.Spec.Deployment.Replicas = int32Ptr(3) },
+		},
+		{
+			name:    "change replicas still out of range",
+			mutate:  func(app *Application) { app.Spec.Deployment.Replicas = int32Ptr(15) },
+			wantErr: true,
+		},
+		{
+			name:    "change image in disallowed registry",
+			mutate:  func(app *Application) { app.Spec.Deployment.Template.Spec.Containers[0].Image = "nginx:1.25" },
+			wantErr: true,
+		},
+		{
+			name: "new violation",
+			mutate: func(app *Application) {
+				app.Spec.Service.Ports = append(app.Spec.Service.Ports, corev1.ServicePort{Name: "metrics", Port: 9090})
+			},
+			wantErr: true,
+		},
+		{
+			name: "immutable selector",
+			mutate: func(app *Application) {
+				app.Labels["tier"] = "web"
+				app.Spec.Deployment.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := existing()
+			app := old.DeepCopy()
+			tt.mutate(app)
+			err := newTestValidator().ValidateUpdate(context.Background(), old, app)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -17,68 +17,19 @@ limitations under the License.
 package v1
 
 import (
-	"fmt"
-	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 // log is for logging in this package.
 var applicationlog = logf.Log.WithName("application-resource")
 
-func (r *Application) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// SetupWebhookWithManager 注册 Application 的默认值和校验 webhook
+// 默认值来自 ApplicationDefaults,校验规则由 validator 中的 operator 配置决定
+func (r *Application) SetupWebhookWithManager(mgr ctrl.Manager, validator *ApplicationValidator, defaulter *ApplicationDefaulter) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithDefaulter(defaulter).
+		WithValidator(validator).
 		Complete()
 }
-
-// TODO(user): EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-
-//+kubebuilder:webhook:path=/mutate-apps-costalong-com-v1-application,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps.costalong.com,resources=applications,verbs=create;update,versions=v1,name=mapplication.kb.io,admissionReviewVersions=v1
-
-var _ webhook.Defaulter = &Application{}
-
-// Default implements webhook.Defaulter so a webhook will be registered for the type
-func (r *Application) Default() {
-	applicationlog.Info("default", "name", r.Name)
-	// 如果配置文件没有设置 Replicas 数量 默认给 3
-	if r.Spec.Deployment.Replicas == nil {
-		r.Spec.Deployment.Replicas = new(int32)
-		*r.Spec.Deployment.Replicas = 3
-	}
-}
-
-// TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
-//+kubebuilder:webhook:path=/validate-apps-costalong-com-v1-application,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.costalong.com,resources=applications,verbs=create;update,versions=v1,name=vapplication.kb.io,admissionReviewVersions=v1
-
-var _ webhook.Validator = &Application{}
-
-// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
-func (r *Application) ValidateCreate() error {
-	applicationlog.Info("validate create", "name", r.Name)
-
-	return r.validateApplication()
-}
-
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
-func (r *Application) ValidateUpdate(old runtime.Object) error {
-	applicationlog.Info("validate update", "name", r.Name)
-
-	return r.validateApplication()
-}
-
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
-func (r *Application) ValidateDelete() error {
-	applicationlog.Info("validate delete", "name", r.Name)
-
-	// TODO(user): fill in your validation logic upon object deletion.
-	return nil
-}
-
-func (r *Application) validateApplication() error {
-	if *r.Spec.Deployment.Replicas > 10 {
-		return fmt.Errorf("replicas to many error")
-	}
-	return nil
-}
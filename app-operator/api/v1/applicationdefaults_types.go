@@ -0,0 +1,71 @@
+/*
+Copyright 2023 Costalong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApplicationDefaultsSpec 新建的 Application 没有设置的字段使用这里的默认值
+// 命名空间中的 ApplicationDefaults 优先于 operator 所在命名空间中的集群默认值
+type ApplicationDefaultsSpec struct {
+	// 默认的副本数
+	Replicas *int32 `json:"replicas,omitempty"`
+	// 容器没有设置的 requests 和 limits 按资源名称逐项补齐
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// 添加到 Application 上的标签,已经存在的标签不会被覆盖
+	Labels map[string]string `json:"labels,omitempty"`
+	// 容器没有设置探针时使用的探针
+	LivenessProbe  *corev1.Probe `json:"livenessProbe,omitempty"`
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+	// 容器和 Pod 没有设置 securityContext 时使用的 securityContext
+	SecurityContext    *corev1.SecurityContext    `json:"securityContext,omitempty"`
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	// Pod 没有设置 imagePullSecrets 时使用的 imagePullSecrets
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+// ApplicationDefaultsStatus defines the observed state of ApplicationDefaults
+type ApplicationDefaultsStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=applicationdefaults,singular=applicationdefaults,scope=Namespaced,shortName=appdefaults
+//+kubebuilder:subresource:status
+
+// ApplicationDefaults is the Schema for the applicationdefaults API
+type ApplicationDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ApplicationDefaultsSpec   `json:"spec,omitempty"`
+	Status ApplicationDefaultsStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ApplicationDefaultsList contains a list of ApplicationDefaults
+type ApplicationDefaultsList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ApplicationDefaults `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ApplicationDefaults{}, &ApplicationDefaultsList{})
+}
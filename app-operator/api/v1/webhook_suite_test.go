@@ -99,7 +99,8 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&Application{}).SetupWebhookWithManager(mgr)
+	err = (&Application{}).SetupWebhookWithManager(mgr, &ApplicationValidator{MinReplicas: 1, MaxReplicas: 10},
+		&ApplicationDefaulter{Reader: mgr.GetAPIReader()})
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:webhook
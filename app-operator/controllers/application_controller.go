@@ -20,28 +20,36 @@ import (
 	"context"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"time"
 
 	dappsv1 "github.com/costa92/app-operator/api/v1"
+	"github.com/costa92/app-operator/pkg/predicates"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 )
 
 const GenericRequeueDuration = 1 * time.Minute
 
-var CounterReconcileApplication int64
+// FieldManager 是 operator 使用 server-side apply 时的字段管理者,
+// 只拥有 Application 中设置的字段,HPA 修改的副本数等其他管理者的字段不会被覆盖
+const FieldManager = "app-operator"
 
 // ApplicationReconciler reconciles a Application object
 type ApplicationReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// 引用的 ConfigMap 和 Secret 只缓存元数据,计算配置 hash 时直接从 API server 读取内容
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=apps.costalong.com,resources=applications,verbs=get;list;watch;create;update;patch;delete
@@ -50,15 +58,21 @@ type ApplicationReconciler struct {
 
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services/status,verbs=get
+//+kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 
 func (r *ApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	<-time.NewTicker(100 * time.Millisecond).C
 	log := log.FromContext(ctx)
 
-	CounterReconcileApplication += 1
-	log.Info("Starting a reconcile", "number", CounterReconcileApplication)
+	reconcileTotal.Inc()
+	log.Info("Starting a reconcile")
 
 	app := &dappsv1.Application{}
 	if err := r.Get(ctx, req.NamespacedName, app); err != nil {
@@ -70,15 +84,33 @@ func (r *ApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
 	}
 
+	// 子资源的状态先记录在内存中,最后统一计算状态条件并更新
+	original := app.Status.DeepCopy()
+
+	// 先处理回滚请求,回滚后使用历史版本的 spec 继续 reconcile
+	if result, err := r.reconcileHistory(ctx, app); err != nil {
+		log.Error(err, "Failed to reconcile revision history.")
+		return result, err
+	}
+
 	// reconcile sub-resources
 	var result ctrl.Result
 	var err error
 
+	// 配置文件需要在 Pod 创建之前准备好
+	result, err = r.reconcileConfigFiles(ctx, app)
+	if err != nil {
+		log.Error(err, "Failed to reconcile ConfigMaps.")
+		return result, err
+	}
+
 	result, err = r.reconcileDeployment(ctx, app)
 	if err != nil {
 		log.Error(err, "Failed to reconcile Deployment.")
 		return result, err
 	}
+	// 发布暂停等待的时间需要在 reconcile 结束后重新入队
+	rolloutResult := result
 
 	result, err = r.reconcileService(ctx, app)
 	if err != nil {
@@ -86,74 +118,84 @@ func (r *ApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return result, err
 	}
 
+	result, err = r.reconcileAutoscaler(ctx, app)
+	if err != nil {
+		log.Error(err, "Failed to reconcile HorizontalPodAutoscaler.")
+		return result, err
+	}
+
+	result, err = r.reconcileDisruptionBudget(ctx, app)
+	if err != nil {
+		log.Error(err, "Failed to reconcile PodDisruptionBudget.")
+		return result, err
+	}
+
+	result, err = r.reconcileIngress(ctx, app)
+	if err != nil {
+		log.Error(err, "Failed to reconcile Ingress.")
+		return result, err
+	}
+
+	result, err = r.updateStatus(ctx, app, original)
+	if err != nil {
+		log.Error(err, "Failed to update Application status.")
+		return result, err
+	}
+
 	log.Info("All resources have been reconciled.")
-	return ctrl.Result{}, nil
+	return rolloutResult, nil
+}
+
+// deleteChild 删除 Application 拥有的子资源,不存在或者不属于该 Application 时忽略
+func (r *ApplicationReconciler) deleteChild(ctx context.Context, app *dappsv1.Application, obj client.Object) error {
+	log := log.FromContext(ctx)
+
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "Failed to get child resource.", "name", obj.GetName())
+		return err
+	}
+	if !metav1.IsControlledBy(obj, app) {
+		return nil
+	}
+	if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete child resource.", "name", obj.GetName())
+		return err
+	}
+	return nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	setupLog := ctrl.Log.WithName("setup")
+	// 按引用的 ConfigMap 和 Secret 查找 Application
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &dappsv1.Application{}, configMapIndex, indexConfigMaps); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &dappsv1.Application{}, secretIndex, indexSecrets); err != nil {
+		return err
+	}
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&dappsv1.Application{}, builder.WithPredicates(predicate.Funcs{
-			CreateFunc: func(event event.CreateEvent) bool {
-				return true
-			},
-			DeleteFunc: func(event event.DeleteEvent) bool {
-				setupLog.Info("The Application has been deleted.",
-					"name", event.Object.GetName())
-				return false
-			},
-			UpdateFunc: func(event event.UpdateEvent) bool {
-				if event.ObjectNew.GetResourceVersion() == event.ObjectOld.GetResourceVersion() {
-					return false
-				}
-				if reflect.DeepEqual(event.ObjectNew.(*dappsv1.Application).Spec, event.ObjectOld.(*dappsv1.Application).Spec) {
-					return false
-				}
-				return true
-			},
-		})).
-		// 1. Deployment
-		Owns(&appsv1.Deployment{}, builder.WithPredicates(predicate.Funcs{
-			CreateFunc: func(event event.CreateEvent) bool {
-				return false
-			},
-			DeleteFunc: func(event event.DeleteEvent) bool {
-				setupLog.Info("The Deployment has been deleted.",
-					"name", event.Object.GetName())
-				return true
-			},
-			UpdateFunc: func(event event.UpdateEvent) bool {
-				if event.ObjectNew.GetResourceVersion() == event.ObjectOld.GetResourceVersion() {
-					return false
-				}
-				if reflect.DeepEqual(event.ObjectNew.(*appsv1.Deployment).Spec, event.ObjectOld.(*appsv1.Deployment).Spec) {
-					return false
-				}
-				return true
-			},
-			GenericFunc: nil,
-		})).
-		// 2. Service
-		Owns(&corev1.Service{}, builder.WithPredicates(predicate.Funcs{
-			CreateFunc: func(event event.CreateEvent) bool {
-				return false
-			},
-			DeleteFunc: func(event event.DeleteEvent) bool {
-				setupLog.Info("The Service has been deleted.",
-					"name", event.Object.GetName())
-				return true
-			},
-			UpdateFunc: func(event event.UpdateEvent) bool {
-				if event.ObjectNew.GetResourceVersion() == event.ObjectOld.GetResourceVersion() {
-					return false
-				}
-				if reflect.DeepEqual(event.ObjectNew.(*dappsv1.Application).Spec, event.ObjectOld.(*dappsv1.Application).Spec) {
-					return false
-				}
-				return true
-			},
-		})).
+		// 手动推进发布和回滚通过注解触发
+		For(&dappsv1.Application{}, builder.WithPredicates(
+			predicates.Application(PromoteAnnotation, dappsv1.RollbackAnnotation))).
+		// 1. Deployment 和 Service
+		Owns(&appsv1.Deployment{}, builder.WithPredicates(predicates.Deployment())).
+		Owns(&corev1.Service{}, builder.WithPredicates(predicates.Service())).
+		// 2. 可选的子资源,被删除或者修改后重新 apply,状态变化同步到 Application
+		Owns(&corev1.ConfigMap{}, builder.OnlyMetadata, builder.WithPredicates(predicates.ConfigMap())).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}, builder.WithPredicates(predicates.HorizontalPodAutoscaler())).
+		Owns(&policyv1.PodDisruptionBudget{}, builder.WithPredicates(predicates.PodDisruptionBudget())).
+		Owns(&networkingv1.Ingress{}, builder.WithPredicates(predicates.Ingress())).
+		// 3. Pod 模板引用的 ConfigMap 和 Secret,内容变化时重新计算 hash 注解
+		// 只缓存元数据,避免在内存中缓存集群中所有 ConfigMap 和 Secret 的内容
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.applicationsReferencing(configMapIndex)),
+			builder.OnlyMetadata, builder.WithPredicates(predicates.ConfigMap())).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.applicationsReferencing(secretIndex)),
+			builder.OnlyMetadata, builder.WithPredicates(predicates.Secret())).
 		Complete(r)
 }
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newChildReconciler 返回使用 fake client 的 reconciler,owned 中的对象由 app 控制,others 中的对象不是
+func newChildReconciler(t *testing.T, app *dappsv1.Application, owned []client.Object, others ...client.Object) *ApplicationReconciler {
+	t.Helper()
+	r := newTestReconciler(t)
+	for _, obj := range owned {
+		if err := ctrl.SetControllerReference(app, obj, r.Scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Client = fake.NewClientBuilder().WithScheme(r.Scheme).WithObjects(append(owned, others...)...).Build()
+	return r
+}
+
+func exists(t *testing.T, r *ApplicationReconciler, obj client.Object) bool {
+	t.Helper()
+	err := r.Get(context.Background(), client.ObjectKeyFromObject(obj), obj)
+	if err != nil && !errors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestConstructDisruptionBudget(t *testing.T) {
+	minAvailable := intstr.FromString("50%")
+	tests := []struct {
+		name               string
+		spec               dappsv1.DisruptionBudgetSpec
+		wantMinAvailable   *intstr.IntOrString
+		wantMaxUnavailable *intstr.IntOrString
+	}{
+		{
+			name:               "default",
+			wantMaxUnavailable: func() *intstr.IntOrString { v := intstr.FromInt(1); return &v }(),
+		},
+		{
+			name:             "min available",
+			spec:             dappsv1.DisruptionBudgetSpec{MinAvailable: &minAvailable},
+			wantMinAvailable: &minAvailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Spec.DisruptionBudget = &tt.spec
+			pdb, err := newTestReconciler(t).constructDisruptionBudget(app)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pdb.Name != app.Name || pdb.Spec.Selector != app.Spec.Deployment.Selector || !metav1.IsControlledBy(pdb, app) {
+				t.Errorf("pdb %s selector %v owners %v", pdb.Name, pdb.Spec.Selector, pdb.OwnerReferences)
+			}
+			if !equalIntOrString(pdb.Spec.MinAvailable, tt.wantMinAvailable) || !equalIntOrString(pdb.Spec.MaxUnavailable, tt.wantMaxUnavailable) {
+				t.Errorf("minAvailable %v maxUnavailable %v, want %v %v",
+					pdb.Spec.MinAvailable, pdb.Spec.MaxUnavailable, tt.wantMinAvailable, tt.wantMaxUnavailable)
+			}
+		})
+	}
+}
+
+func equalIntOrString(a, b *intstr.IntOrString) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestConstructIngress(t *testing.T) {
+	className := "nginx"
+	tests := []struct {
+		name     string
+		spec     dappsv1.IngressSpec
+		ports    []corev1.ServicePort
+		wantPath string
+		wantPort int32
+		wantTLS  bool
+		wantErr  bool
+	}{
+		{
+			name:     "defaults",
+			spec:     dappsv1.IngressSpec{Host: "demo.example.com"},
+			ports:    []corev1.ServicePort{{Name: "http", Port: 80}, {Name: "metrics", Port: 9090}},
+			wantPath: "/",
+			wantPort: 80,
+		},
+		{
+			name:     "explicit port, path and tls",
+			spec:     dappsv1.IngressSpec{Host: "demo.example.com", ClassName: &className, Path: "/api", ServicePort: int32Ptr(9090), TLSSecretName: "demo-tls"},
+			ports:    []corev1.ServicePort{{Name: "http", Port: 80}},
+			wantPath: "/api",
+			wantPort: 9090,
+			wantTLS:  true,
+		},
+		{
+			name:    "no service ports",
+			spec:    dappsv1.IngressSpec{Host: "demo.example.com"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Spec.Ingress = &tt.spec
+			app.Spec.Service.Ports = tt.ports
+			ing, err := newTestReconciler(t).constructIngress(app)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("constructIngress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(ing.Spec.Rules) != 1 || ing.Spec.Rules[0].Host != tt.spec.Host {
+				t.Fatalf("rules = %+v", ing.Spec.Rules)
+			}
+			path := ing.Spec.Rules[0].HTTP.Paths[0]
+			if path.Path != tt.wantPath || path.Backend.Service.Name != app.Name || path.Backend.Service.Port.Number != tt.wantPort {
+				t.Errorf("path %s backend %+v, want %s to %s:%d", path.Path, path.Backend.Service, tt.wantPath, app.Name, tt.wantPort)
+			}
+			if got := len(ing.Spec.TLS) == 1 && ing.Spec.TLS[0].SecretName == tt.spec.TLSSecretName; got != tt.wantTLS {
+				t.Errorf("tls = %+v, want %v", ing.Spec.TLS, tt.wantTLS)
+			}
+			if ing.Spec.IngressClassName != tt.spec.ClassName || !metav1.IsControlledBy(ing, app) {
+				t.Errorf("ingress class %v owners %v", ing.Spec.IngressClassName, ing.OwnerReferences)
+			}
+		})
+	}
+}
+
+// 从 spec 中移除 disruptionBudget 或 ingress 后删除 Application 创建的子资源,不删除其它的同名资源
+func TestRemovedSectionDeletesChild(t *testing.T) {
+	tests := []struct {
+		name      string
+		child     func() client.Object
+		reconcile func(r *ApplicationReconciler, app *dappsv1.Application) error
+	}{
+		{
+			name:  "disruption budget",
+			child: func() client.Object { return &policyv1.PodDisruptionBudget{} },
+			reconcile: func(r *ApplicationReconciler, app *dappsv1.Application) error {
+				_, err := r.reconcileDisruptionBudget(context.Background(), app)
+				return err
+			},
+		},
+		{
+			name:  "ingress",
+			child: func() client.Object { return &networkingv1.Ingress{} },
+			reconcile: func(r *ApplicationReconciler, app *dappsv1.Application) error {
+				_, err := r.reconcileIngress(context.Background(), app)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, owned := range []bool{true, false} {
+				app := newTestApplication()
+				child := tt.child()
+				child.SetName(app.Name)
+				child.SetNamespace(app.Namespace)
+
+				var r *ApplicationReconciler
+				if owned {
+					r = newChildReconciler(t, app, []client.Object{child})
+				} else {
+					r = newChildReconciler(t, app, nil, child)
+				}
+				if err := tt.reconcile(r, app); err != nil {
+					t.Fatal(err)
+				}
+				if got := exists(t, r, child); got == owned {
+					t.Errorf("owned %v: child exists = %v", owned, got)
+				}
+			}
+
+			// 子资源不存在时什么都不做
+			app := newTestApplication()
+			if err := tt.reconcile(newChildReconciler(t, app, nil), app); err != nil {
+				t.Errorf("reconcile without child: %v", err)
+			}
+		})
+	}
+}
+
+func TestReconcileConfigFiles(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.ConfigFiles = []dappsv1.ConfigFile{{Name: "nginx", MountPath: "/etc/nginx/conf.d", Data: map[string]string{"default.conf": "server {}"}}}
+
+	configMap := func(name string, labels map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: app.Namespace, Labels: labels}}
+	}
+	labels := withApplication(app)
+	current := configMap("demo-nginx", labels)
+	removed := configMap("demo-app", labels)
+	// 带有 Application 标签但是不是 Application 创建的 ConfigMap
+	foreign := configMap("demo-shared", labels)
+	r := newChildReconciler(t, app, []client.Object{current, removed}, foreign)
+
+	if _, err := r.reconcileConfigFiles(context.Background(), app); err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists(t, r, current) || current.Data["default.conf"] != "server {}" {
+		t.Errorf("configmap %s = %v, want the config file", current.Name, current.Data)
+	}
+	if exists(t, r, removed) {
+		t.Errorf("configmap %s removed from the spec was not deleted", removed.Name)
+	}
+	if !exists(t, r, foreign) {
+		t.Errorf("configmap %s not controlled by the Application was deleted", foreign.Name)
+	}
+}
+
+func TestPodTemplateConfigFiles(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Deployment.Template.Spec.Containers = append(app.Spec.Deployment.Template.Spec.Containers, corev1.Container{Name: "sidecar"})
+	app.Spec.ConfigFiles = []dappsv1.ConfigFile{{Name: "nginx", MountPath: "/etc/nginx/conf.d"}}
+
+	template := podTemplate(app)
+	if len(template.Spec.Volumes) != 1 || template.Spec.Volumes[0].ConfigMap.Name != "demo-nginx" {
+		t.Fatalf("volumes = %+v", template.Spec.Volumes)
+	}
+	for _, c := range template.Spec.Containers {
+		if len(c.VolumeMounts) != 1 || c.VolumeMounts[0].MountPath != "/etc/nginx/conf.d" || !c.VolumeMounts[0].ReadOnly {
+			t.Errorf("container %s mounts = %+v", c.Name, c.VolumeMounts)
+		}
+	}
+	if len(app.Spec.Deployment.Template.Spec.Volumes) != 0 {
+		t.Error("podTemplate modified the Application")
+	}
+}
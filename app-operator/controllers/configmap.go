@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func configMapName(app *dappsv1.Application, file dappsv1.ConfigFile) string {
+	return app.Name + "-" + file.Name
+}
+
+func configVolumeName(file dappsv1.ConfigFile) string {
+	return "config-" + file.Name
+}
+
+// podTemplate 返回 Deployment 使用的 Pod 模板,配置文件以只读卷的方式挂载到所有容器中
+func podTemplate(app *dappsv1.Application) corev1.PodTemplateSpec {
+	template := *app.Spec.Deployment.Template.DeepCopy()
+	for _, file := range app.Spec.ConfigFiles {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name: configVolumeName(file),
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: configMapName(app, file)},
+				},
+			},
+		})
+		for i := range template.Spec.Containers {
+			template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      configVolumeName(file),
+				MountPath: file.MountPath,
+				ReadOnly:  true,
+			})
+		}
+	}
+	return template
+}
+
+// reconcileConfigFiles apply 配置文件对应的 ConfigMap,并删除已经从配置中移除的 ConfigMap
+func (r *ApplicationReconciler) reconcileConfigFiles(ctx context.Context, app *dappsv1.Application) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	desired := make(map[string]bool, len(app.Spec.ConfigFiles))
+	for _, file := range app.Spec.ConfigFiles {
+		cm := &corev1.ConfigMap{}
+		cm.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+		cm.SetName(configMapName(app, file))
+		cm.SetNamespace(app.Namespace)
+		cm.SetLabels(withApplication(app))
+		cm.Data = file.Data
+		if err := ctrl.SetControllerReference(app, cm, r.Scheme); err != nil {
+			log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+
+		if err := r.Patch(ctx, cm, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+			log.Error(err, "Failed to apply ConfigMap, will requeue after a short time.", "name", cm.Name)
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		desired[cm.Name] = true
+	}
+
+	// ConfigMap 只缓存元数据
+	list := &metav1.PartialObjectMetadataList{}
+	list.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMapList"))
+	if err := r.List(ctx, list, client.InNamespace(app.Namespace),
+		client.MatchingLabels{ApplicationLabel: app.Name}); err != nil {
+		log.Error(err, "Failed to list ConfigMaps, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	for i := range list.Items {
+		cm := &list.Items[i]
+		if desired[cm.Name] || !metav1.IsControlledBy(cm, app) {
+			continue
+		}
+		cm.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+		if err := r.deleteChild(ctx, app, cm); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		log.Info("The ConfigMap has been deleted.", "name", cm.Name)
+	}
+	return ctrl.Result{}, nil
+}
+
+// withApplication 返回 Application 的标签,并加上 ApplicationLabel 用于查找子资源
+func withApplication(app *dappsv1.Application) map[string]string {
+	labels := make(map[string]string, len(app.Labels)+1)
+	for k, v := range app.Labels {
+		labels[k] = v
+	}
+	labels[ApplicationLabel] = app.Name
+	return labels
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ConfigHashAnnotation 记录 Pod 模板引用的 ConfigMap 和 Secret 内容的 hash,内容变化时触发滚动更新
+	ConfigHashAnnotation = "apps.costalong.com/config-hash"
+
+	// Application 引用的 ConfigMap 和 Secret 的索引
+	configMapIndex = ".spec.deployment.template.configMaps"
+	secretIndex    = ".spec.deployment.template.secrets"
+)
+
+// podReferences 返回 Pod 模板的 env、envFrom 和 volumes 中引用的 ConfigMap 和 Secret 的名字
+func podReferences(spec *corev1.PodSpec) (configMaps, secrets []string) {
+	cmSet, secretSet := map[string]bool{}, map[string]bool{}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				cmSet[ref.Name] = true
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				secretSet[ref.Name] = true
+			}
+		}
+		for _, from := range c.EnvFrom {
+			if from.ConfigMapRef != nil {
+				cmSet[from.ConfigMapRef.Name] = true
+			}
+			if from.SecretRef != nil {
+				secretSet[from.SecretRef.Name] = true
+			}
+		}
+	}
+
+	for _, v := range spec.Volumes {
+		if v.ConfigMap != nil {
+			cmSet[v.ConfigMap.Name] = true
+		}
+		if v.Secret != nil {
+			secretSet[v.Secret.SecretName] = true
+		}
+		if v.Projected != nil {
+			for _, source := range v.Projected.Sources {
+				if source.ConfigMap != nil {
+					cmSet[source.ConfigMap.Name] = true
+				}
+				if source.Secret != nil {
+					secretSet[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+	return sortedKeys(cmSet), sortedKeys(secretSet)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renderTemplate 返回带有配置 hash 注解的 Pod 模板,没有引用 ConfigMap 和 Secret 时不添加注解
+func (r *ApplicationReconciler) renderTemplate(ctx context.Context, app *dappsv1.Application) (corev1.PodTemplateSpec, error) {
+	template := podTemplate(app)
+
+	hash, err := r.configHash(ctx, app.Namespace, &template.Spec)
+	if err != nil {
+		return template, err
+	}
+	if hash != "" {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[ConfigHashAnnotation] = hash
+	}
+	return template, nil
+}
+
+// configHash 计算引用的 ConfigMap 和 Secret 内容的 hash,不存在的对象也计入 hash,创建后会触发滚动更新
+// 缓存中只有元数据,内容通过 APIReader 读取
+func (r *ApplicationReconciler) configHash(ctx context.Context, namespace string, spec *corev1.PodSpec) (string, error) {
+	configMaps, secrets := podReferences(spec)
+	if len(configMaps) == 0 && len(secrets) == 0 {
+		return "", nil
+	}
+
+	hasher := sha256.New()
+	write := func(parts ...string) {
+		for _, p := range parts {
+			hasher.Write([]byte(p))
+			hasher.Write([]byte{0})
+		}
+	}
+
+	for _, name := range configMaps {
+		cm := &corev1.ConfigMap{}
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, cm); err != nil {
+			if !errors.IsNotFound(err) {
+				log.FromContext(ctx).Error(err, "Failed to get ConfigMap.", "name", name)
+				return "", err
+			}
+			write("configmap", name, "missing")
+			continue
+		}
+		write("configmap", name)
+		for _, k := range sortedKeys(keySet(cm.Data)) {
+			write(k, cm.Data[k])
+		}
+		for _, k := range sortedKeys(keySet(cm.BinaryData)) {
+			write(k, string(cm.BinaryData[k]))
+		}
+	}
+
+	for _, name := range secrets {
+		secret := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+			if !errors.IsNotFound(err) {
+				log.FromContext(ctx).Error(err, "Failed to get Secret.", "name", name)
+				return "", err
+			}
+			write("secret", name, "missing")
+			continue
+		}
+		write("secret", name)
+		for _, k := range sortedKeys(keySet(secret.Data)) {
+			write(k, string(secret.Data[k]))
+		}
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))[:16], nil
+}
+
+func keySet[V any](m map[string]V) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+// indexConfigMaps 和 indexSecrets 为 Application 建立引用的 ConfigMap 和 Secret 的索引
+func indexConfigMaps(obj client.Object) []string {
+	template := podTemplate(obj.(*dappsv1.Application))
+	configMaps, _ := podReferences(&template.Spec)
+	return configMaps
+}
+
+func indexSecrets(obj client.Object) []string {
+	template := podTemplate(obj.(*dappsv1.Application))
+	_, secrets := podReferences(&template.Spec)
+	return secrets
+}
+
+// applicationsReferencing 返回引用了该 ConfigMap 或者 Secret 的 Application
+func (r *ApplicationReconciler) applicationsReferencing(index string) func(client.Object) []reconcile.Request {
+	return func(obj client.Object) []reconcile.Request {
+		list := &dappsv1.ApplicationList{}
+		if err := r.List(context.Background(), list, client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{index: obj.GetName()}); err != nil {
+			log.Log.Error(err, "Failed to list Applications referencing the object.", "name", obj.GetName())
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(list.Items))
+		for _, app := range list.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name},
+			})
+		}
+		return requests
+	}
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// 配置的内容通过 APIReader 读取,缓存中只有元数据
+func TestConfigHashReadsThroughAPIReader(t *testing.T) {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"}, Data: map[string]string{"a": "1"}}
+	spec := &corev1.PodSpec{Containers: []corev1.Container{{
+		Name:    "demo",
+		EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}}},
+	}}}
+
+	ctx := context.Background()
+	reader := fake.NewClientBuilder().WithObjects(cm).Build()
+	r := &ApplicationReconciler{APIReader: reader}
+	before, err := r.configHash(ctx, "default", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm.Data["a"] = "2"
+	if err := reader.Update(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	after, err := r.configHash(ctx, "default", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == "" || before == after {
+		t.Errorf("hash %q did not change after the ConfigMap changed, got %q", before, after)
+	}
+
+	if err := reader.Delete(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := r.configHash(ctx, "default", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing == after {
+		t.Error("hash did not change after the ConfigMap was deleted")
+	}
+}
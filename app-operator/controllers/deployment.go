@@ -4,59 +4,76 @@ import (
 	"context"
 	dappsv1 "github.com/costa92/app-operator/api/v1"
 	appsv1 "k8s.io/api/apps/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/types"
-	"reflect"
+	corev1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 func (r *ApplicationReconciler) reconcileDeployment(ctx context.Context, app *dappsv1.Application) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
-	var dp = &appsv1.Deployment{}
-	err := r.Get(ctx, types.NamespacedName{
-		Namespace: app.Namespace,
-		Name:      app.Name,
-	}, dp)
+	// 引用的 ConfigMap 和 Secret 的内容变化时,Pod 模板上的 hash 注解随之变化,触发滚动更新
+	template, err := r.renderTemplate(ctx, app)
+	if err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
 
-	if err == nil {
-		log.Info("The Deployment has already exist.")
-		if reflect.DeepEqual(dp.Status, app.Status.Workflow) {
-			return ctrl.Result{}, nil
+	// 设置了发布策略时由 reconcileRollout 管理稳定版本和新版本的 Deployment
+	if app.Spec.Strategy != nil {
+		return r.reconcileRollout(ctx, app, template)
+	}
+	if app.Status.Rollout != nil {
+		if err := r.deleteCanary(ctx, app); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
 		}
+		app.Status.Rollout = nil
+	}
 
-		app.Status.Workflow = dp.Status
-		if err := r.Status().Update(ctx, app); err != nil {
-			log.Error(err, "Failed to update Application status")
+	dp, err := r.constructDeployment(app, template)
+	if err != nil {
+		log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	if app.Spec.Autoscaling != nil {
+		if dp.Spec.Replicas, err = r.replicasUntilAutoscaled(ctx, client.ObjectKeyFromObject(dp)); err != nil {
 			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
 		}
-		log.Info("The Application status has been updated.")
-		return ctrl.Result{}, nil
 	}
 
-	if !errors.IsNotFound(err) {
-		log.Error(err, "Failed to get Deployment, will requeue after a short time.")
+	// 使用 server-side apply 创建或者更新 Deployment,只覆盖 Application 中设置的字段
+	if err := r.Patch(ctx, dp, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply Deployment, will requeue after a short time.")
 		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
 	}
+	log.Info("The Deployment has been applied.")
 
-	newDp := &appsv1.Deployment{}
-	newDp.SetName(app.Name)
-	newDp.SetNamespace(app.Namespace)
-	newDp.SetLabels(app.Labels)
-	newDp.Spec = app.Spec.Deployment.DeploymentSpec
-	newDp.Spec.Template.SetLabels(app.Labels)
-
-	if err := ctrl.SetControllerReference(app, newDp, r.Scheme); err != nil {
-		log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
-		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	// 启用 HPA 时副本数由 HPA 决定,以 Deployment 中的副本数为准
+	app.Status.Workflow = dp.Status
+	if dp.Spec.Replicas != nil {
+		app.Status.Replicas = *dp.Spec.Replicas
 	}
+	return ctrl.Result{}, nil
+}
 
-	if err := r.Create(ctx, newDp); err != nil {
-		log.Error(err, "Failed to create Deployment, will requeue after a short time.")
-		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+// constructDeployment 构造用于 apply 的 Deployment,只包含 operator 管理的字段
+func (r *ApplicationReconciler) constructDeployment(app *dappsv1.Application, template corev1.PodTemplateSpec) (*appsv1.Deployment, error) {
+	dp := &appsv1.Deployment{}
+	dp.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	dp.SetName(app.Name)
+	dp.SetNamespace(app.Namespace)
+	dp.SetLabels(app.Labels)
+	// 没有设置副本数时 apply 的配置中不包含该字段,保留 Deployment 当前的副本数
+	dp.Spec = *app.Spec.Deployment.DeploymentSpec.DeepCopy()
+	dp.Spec.Template = template
+	dp.Spec.Template.SetLabels(app.Labels)
+	// 启用 HPA 时不设置副本数,该字段不属于 operator,不会覆盖 HPA 调整后的副本数
+	if app.Spec.Autoscaling != nil {
+		dp.Spec.Replicas = nil
 	}
 
-	log.Info("The Deployment has been created.")
-	return ctrl.Result{}, nil
+	if err := ctrl.SetControllerReference(app, dp, r.Scheme); err != nil {
+		return nil, err
+	}
+	return dp, nil
 }
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"testing"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func int32Ptr(n int32) *int32 { return &n }
+
+func newTestReconciler(t *testing.T) *ApplicationReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := dappsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &ApplicationReconciler{Scheme: scheme}
+}
+
+func newTestApplication() *dappsv1.Application {
+	app := &dappsv1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "app-uid", Labels: map[string]string{"app": "demo"}},
+	}
+	app.Spec.Deployment.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}}
+	app.Spec.Deployment.Template.Spec.Containers = []corev1.Container{{Name: "demo", Image: "nginx"}}
+	return app
+}
+
+func TestConstructDeploymentReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicas    *int32
+		autoscaling *dappsv1.AutoscalingSpec
+		want        *int32
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:     "set",
+			replicas: int32Ptr(2),
+			want:     int32Ptr(2),
+		},
+		{
+			name:        "autoscaling",
+			replicas:    int32Ptr(2),
+			autoscaling: &dappsv1.AutoscalingSpec{MaxReplicas: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Spec.Deployment.Replicas = tt.replicas
+			app.Spec.Autoscaling = tt.autoscaling
+
+			dp, err := newTestReconciler(t).constructDeployment(app, app.Spec.Deployment.Template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (dp.Spec.Replicas == nil) != (tt.want == nil) || (tt.want != nil && *dp.Spec.Replicas != *tt.want) {
+				t.Errorf("replicas = %v, want %v", dp.Spec.Replicas, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultRevisionHistoryLimit = 10
+
+	// ApplicationLabel 标记 ControllerRevision、ConfigMap 等需要按标签查找的子资源所属的 Application
+	ApplicationLabel = "apps.costalong.com/application"
+)
+
+// reconcileHistory 处理回滚请求,并把当前的 spec 记录到 ControllerRevision 中
+// 与 StatefulSet 相同,spec 回到某个历史版本时复用该版本并把版本号改为最新
+func (r *ApplicationReconciler) reconcileHistory(ctx context.Context, app *dappsv1.Application) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	revisions, err := r.listRevisions(ctx, app)
+	if err != nil {
+		log.Error(err, "Failed to list ControllerRevisions, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+
+	if target, ok, err := rollbackTarget(app); ok {
+		var found *appsv1.ControllerRevision
+		if err != nil {
+			log.Error(err, "Invalid rollback annotation, the rollback request is discarded.",
+				"value", app.Annotations[dappsv1.RollbackAnnotation])
+		} else if found = findRevision(revisions, target); found == nil {
+			log.Info("The revision to roll back to was not found, the rollback request is discarded.", "revision", target)
+		}
+		if err := r.rollback(ctx, app, found); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+	}
+
+	spec := revisionSpec(app)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	name := revisionName(app, data)
+
+	var maxRevision int64
+	if len(revisions) > 0 {
+		maxRevision = revisions[len(revisions)-1].Revision
+	}
+	var current *appsv1.ControllerRevision
+	for i := range revisions {
+		if revisions[i].Name == name {
+			current = &revisions[i]
+		}
+	}
+
+	switch {
+	case current == nil:
+		current = &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: app.Namespace,
+				Labels:    map[string]string{ApplicationLabel: app.Name},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: maxRevision + 1,
+		}
+		if err := ctrl.SetControllerReference(app, current, r.Scheme); err != nil {
+			log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		if err := r.Create(ctx, current); err != nil && !errors.IsAlreadyExists(err) {
+			log.Error(err, "Failed to create ControllerRevision, will requeue after a short time.")
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		revisions = append(revisions, *current)
+		log.Info("A new revision has been recorded.", "revision", current.Revision)
+	case current.Revision != maxRevision:
+		current.Revision = maxRevision + 1
+		if err := r.Update(ctx, current); err != nil {
+			log.Error(err, "Failed to update ControllerRevision, will requeue after a short time.")
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		// 排序会移动切片中的元素,重新指向版本号最大的当前版本
+		sortRevisions(revisions)
+		current = &revisions[len(revisions)-1]
+	}
+
+	app.Status.CurrentRevision = current.Revision
+	app.Status.PreviousRevision = 0
+	for _, rev := range revisions {
+		if rev.Revision < current.Revision && rev.Revision > app.Status.PreviousRevision {
+			app.Status.PreviousRevision = rev.Revision
+		}
+	}
+
+	return ctrl.Result{}, r.pruneRevisions(ctx, app, revisions, current.Name)
+}
+
+// rollbackTarget 返回 spec.rollbackTo 或者回滚注解中的版本号,spec 中的设置优先
+// 注解的值不是版本号时返回错误,回滚请求仍然存在,需要清除
+func rollbackTarget(app *dappsv1.Application) (int64, bool, error) {
+	if app.Spec.RollbackTo != nil {
+		return app.Spec.RollbackTo.Revision, true, nil
+	}
+	value, ok := app.Annotations[dappsv1.RollbackAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, true, err
+	}
+	return revision, true, nil
+}
+
+func findRevision(revisions []appsv1.ControllerRevision, revision int64) *appsv1.ControllerRevision {
+	for i := range revisions {
+		if revisions[i].Revision == revision {
+			return &revisions[i]
+		}
+	}
+	return nil
+}
+
+// rollback 使用历史版本的 spec 替换当前的 spec,并清除回滚请求
+// target 为空时只清除回滚请求
+func (r *ApplicationReconciler) rollback(ctx context.Context, app *dappsv1.Application, target *appsv1.ControllerRevision) error {
+	log := log.FromContext(ctx)
+
+	latest := app.DeepCopy()
+	latest.Spec.RollbackTo = nil
+	delete(latest.Annotations, dappsv1.RollbackAnnotation)
+
+	if target != nil {
+		spec := dappsv1.ApplicationSpec{}
+		if err := json.Unmarshal(target.Data.Raw, &spec); err != nil {
+			log.Error(err, "Failed to decode ControllerRevision.", "revision", target.Revision)
+			return err
+		}
+		spec.RevisionHistoryLimit = latest.Spec.RevisionHistoryLimit
+		latest.Spec = spec
+	}
+
+	if err := r.Update(ctx, latest); err != nil {
+		log.Error(err, "Failed to roll back Application.")
+		return err
+	}
+	if target != nil {
+		log.Info("The Application has been rolled back.", "revision", target.Revision)
+	}
+	*app = *latest
+	return nil
+}
+
+// pruneRevisions 删除超出保留数量的最旧的版本,当前版本不会被删除
+func (r *ApplicationReconciler) pruneRevisions(ctx context.Context, app *dappsv1.Application,
+	revisions []appsv1.ControllerRevision, current string) error {
+	limit := defaultRevisionHistoryLimit
+	if app.Spec.RevisionHistoryLimit != nil {
+		limit = int(*app.Spec.RevisionHistoryLimit)
+	}
+
+	for i := 0; i < len(revisions)-limit; i++ {
+		if revisions[i].Name == current {
+			continue
+		}
+		if err := r.Delete(ctx, &revisions[i]); err != nil && !errors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "Failed to delete ControllerRevision.", "revision", revisions[i].Revision)
+			return err
+		}
+	}
+	return nil
+}
+
+// listRevisions 返回 Application 的所有版本,按版本号从小到大排序
+func (r *ApplicationReconciler) listRevisions(ctx context.Context, app *dappsv1.Application) ([]appsv1.ControllerRevision, error) {
+	list := &appsv1.ControllerRevisionList{}
+	if err := r.List(ctx, list, client.InNamespace(app.Namespace),
+		client.MatchingLabels{ApplicationLabel: app.Name}); err != nil {
+		return nil, err
+	}
+
+	revisions := make([]appsv1.ControllerRevision, 0, len(list.Items))
+	for _, rev := range list.Items {
+		if metav1.IsControlledBy(&rev, app) {
+			revisions = append(revisions, rev)
+		}
+	}
+	sortRevisions(revisions)
+	return revisions, nil
+}
+
+func sortRevisions(revisions []appsv1.ControllerRevision) {
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+}
+
+// revisionSpec 返回需要记录的 spec,回滚请求和历史版本数量不属于版本的内容
+func revisionSpec(app *dappsv1.Application) dappsv1.ApplicationSpec {
+	spec := *app.Spec.DeepCopy()
+	spec.RollbackTo = nil
+	spec.RevisionHistoryLimit = nil
+	return spec
+}
+
+func revisionName(app *dappsv1.Application, data []byte) string {
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return fmt.Sprintf("%s-%s", app.Name, rand.SafeEncodeString(fmt.Sprint(hasher.Sum32())))
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newHistoryReconciler 返回使用 fake client 的 reconciler,app 已经创建
+func newHistoryReconciler(t *testing.T, app *dappsv1.Application) *ApplicationReconciler {
+	t.Helper()
+	r := newTestReconciler(t)
+	r.Client = fake.NewClientBuilder().WithScheme(r.Scheme).WithObjects(app).Build()
+	return r
+}
+
+// recordImage 把容器镜像改为 image 并执行一次 reconcileHistory,返回更新后的 Application
+func recordImage(t *testing.T, r *ApplicationReconciler, image string) *dappsv1.Application {
+	t.Helper()
+	ctx := context.Background()
+	app := getApplication(t, r)
+	app.Spec.Deployment.Template.Spec.Containers[0].Image = image
+	if err := r.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileHistory(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func getApplication(t *testing.T, r *ApplicationReconciler) *dappsv1.Application {
+	t.Helper()
+	app := &dappsv1.Application{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: "demo", Namespace: "default"}, app); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// revisionImages 返回按版本号排序的每个版本的镜像
+func revisionImages(t *testing.T, r *ApplicationReconciler, app *dappsv1.Application) map[int64]string {
+	t.Helper()
+	revisions, err := r.listRevisions(context.Background(), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := map[int64]string{}
+	for _, rev := range revisions {
+		spec := dappsv1.ApplicationSpec{}
+		if err := json.Unmarshal(rev.Data.Raw, &spec); err != nil {
+			t.Fatal(err)
+		}
+		images[rev.Revision] = spec.Deployment.Template.Spec.Containers[0].Image
+	}
+	return images
+}
+
+func TestReconcileHistory(t *testing.T) {
+	r := newHistoryReconciler(t, newTestApplication())
+
+	steps := []struct {
+		image    string
+		current  int64
+		previous int64
+		want     map[int64]string
+	}{
+		{image: "nginx:1.24", current: 1, want: map[int64]string{1: "nginx:1.24"}},
+		{image: "nginx:1.25", current: 2, previous: 1, want: map[int64]string{1: "nginx:1.24", 2: "nginx:1.25"}},
+		// 没有变化时不记录新的版本
+		{image: "nginx:1.25", current: 2, previous: 1, want: map[int64]string{1: "nginx:1.24", 2: "nginx:1.25"}},
+		// 回到历史版本时复用该版本,版本号改为最新
+		{image: "nginx:1.24", current: 3, previous: 2, want: map[int64]string{2: "nginx:1.25", 3: "nginx:1.24"}},
+	}
+	for i, step := range steps {
+		app := recordImage(t, r, step.image)
+		if app.Status.CurrentRevision != step.current || app.Status.PreviousRevision != step.previous {
+			t.Errorf("step %d: revisions = %d/%d, want %d/%d", i, app.Status.CurrentRevision, app.Status.PreviousRevision, step.current, step.previous)
+		}
+		got := revisionImages(t, r, app)
+		if len(got) != len(step.want) {
+			t.Fatalf("step %d: revisions = %v, want %v", i, got, step.want)
+		}
+		for rev, image := range step.want {
+			if got[rev] != image {
+				t.Errorf("step %d: revisions = %v, want %v", i, got, step.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name       string
+		rollbackTo *dappsv1.RollbackConfig
+		annotation string
+		want       string
+	}{
+		{
+			name:       "annotation",
+			annotation: "1",
+			want:       "nginx:1.23",
+		},
+		{
+			name:       "spec",
+			rollbackTo: &dappsv1.RollbackConfig{Revision: 2},
+			want:       "nginx:1.24",
+		},
+		{
+			name:       "spec wins over annotation",
+			rollbackTo: &dappsv1.RollbackConfig{Revision: 1},
+			annotation: "2",
+			want:       "nginx:1.23",
+		},
+		{
+			name:       "missing revision",
+			rollbackTo: &dappsv1.RollbackConfig{Revision: 9},
+			want:       "nginx:1.25",
+		},
+		{
+			// 不能当作版本 0 处理
+			name:       "invalid annotation",
+			annotation: "previous",
+			want:       "nginx:1.25",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Spec.RevisionHistoryLimit = int32Ptr(5)
+			r := newHistoryReconciler(t, app)
+			for _, image := range []string{"nginx:1.23", "nginx:1.24", "nginx:1.25"} {
+				recordImage(t, r, image)
+			}
+
+			ctx := context.Background()
+			app = getApplication(t, r)
+			app.Spec.RollbackTo = tt.rollbackTo
+			if tt.annotation != "" {
+				app.Annotations = map[string]string{dappsv1.RollbackAnnotation: tt.annotation}
+			}
+			if err := r.Update(ctx, app); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.reconcileHistory(ctx, app); err != nil {
+				t.Fatal(err)
+			}
+
+			got := getApplication(t, r)
+			if image := got.Spec.Deployment.Template.Spec.Containers[0].Image; image != tt.want {
+				t.Errorf("image = %s, want %s", image, tt.want)
+			}
+			if got.Spec.RollbackTo != nil || got.Annotations[dappsv1.RollbackAnnotation] != "" {
+				t.Errorf("rollback request not cleared: %+v, %v", got.Spec.RollbackTo, got.Annotations)
+			}
+			if got.Spec.RevisionHistoryLimit == nil || *got.Spec.RevisionHistoryLimit != 5 {
+				t.Errorf("revisionHistoryLimit = %v, want 5", got.Spec.RevisionHistoryLimit)
+			}
+		})
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		rollbackTo *dappsv1.RollbackConfig
+		annotation *string
+		want       int64
+		wantOK     bool
+		wantErr    bool
+	}{
+		{name: "none"},
+		{name: "spec", rollbackTo: &dappsv1.RollbackConfig{Revision: 3}, want: 3, wantOK: true},
+		{name: "annotation", annotation: stringPtr("4"), want: 4, wantOK: true},
+		{name: "spec wins", rollbackTo: &dappsv1.RollbackConfig{Revision: 3}, annotation: stringPtr("4"), want: 3, wantOK: true},
+		{name: "invalid annotation", annotation: stringPtr("latest"), wantOK: true, wantErr: true},
+		{name: "empty annotation", annotation: stringPtr(""), wantOK: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Spec.RollbackTo = tt.rollbackTo
+			if tt.annotation != nil {
+				app.Annotations = map[string]string{dappsv1.RollbackAnnotation: *tt.annotation}
+			}
+			got, ok, err := rollbackTarget(app)
+			if got != tt.want || ok != tt.wantOK || (err != nil) != tt.wantErr {
+				t.Errorf("rollbackTarget() = %d, %v, %v, want %d, %v, error %v", got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPruneRevisions(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.RevisionHistoryLimit = int32Ptr(2)
+	r := newHistoryReconciler(t, app)
+	ctx := context.Background()
+
+	var revisions []appsv1.ControllerRevision
+	for i, name := range []string{"demo-a", "demo-b", "demo-c", "demo-d"} {
+		rev := appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       app.Namespace,
+				Labels:          map[string]string{ApplicationLabel: app.Name},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(app, dappsv1.GroupVersion.WithKind("Application"))},
+			},
+			Revision: int64(i + 1),
+		}
+		if err := r.Create(ctx, &rev); err != nil {
+			t.Fatal(err)
+		}
+		revisions = append(revisions, rev)
+	}
+
+	// 当前版本是最旧的版本时不删除,即使超出保留数量
+	if err := r.pruneRevisions(ctx, app, revisions, "demo-a"); err != nil {
+		t.Fatal(err)
+	}
+	remaining, err := r.listRevisions(ctx, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, rev := range remaining {
+		names = append(names, rev.Name)
+	}
+	if want := []string{"demo-a", "demo-c", "demo-d"}; !equalStrings(names, want) {
+		t.Errorf("remaining revisions = %v, want %v", names, want)
+	}
+
+	if err := r.pruneRevisions(ctx, app, remaining, "demo-d"); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, err = r.listRevisions(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 || remaining[0].Name != "demo-c" || remaining[1].Name != "demo-d" {
+		t.Errorf("remaining revisions = %+v, want demo-c and demo-d", remaining)
+	}
+}
+
+func stringPtr(s string) *string { return &s }
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
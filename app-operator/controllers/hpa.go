@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const defaultTargetCPUUtilization int32 = 80
+
+// reconcileAutoscaler 根据 spec.autoscaling apply HorizontalPodAutoscaler,没有设置时删除
+func (r *ApplicationReconciler) reconcileAutoscaler(ctx context.Context, app *dappsv1.Application) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if app.Spec.Autoscaling == nil {
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+		hpa.SetName(app.Name)
+		hpa.SetNamespace(app.Namespace)
+		if err := r.deleteChild(ctx, app, hpa); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		app.Status.Autoscaling = nil
+		return ctrl.Result{}, nil
+	}
+
+	hpa, err := r.constructAutoscaler(app)
+	if err != nil {
+		log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	if err := r.Patch(ctx, hpa, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply HorizontalPodAutoscaler, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+
+	app.Status.Autoscaling = hpa.Status.DeepCopy()
+	return ctrl.Result{}, nil
+}
+
+// constructAutoscaler 构造用于 apply 的 HorizontalPodAutoscaler,扩缩容的对象是稳定版本的 Deployment
+func (r *ApplicationReconciler) constructAutoscaler(app *dappsv1.Application) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	spec := app.Spec.Autoscaling
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	hpa.SetGroupVersionKind(autoscalingv2.SchemeGroupVersion.WithKind("HorizontalPodAutoscaler"))
+	hpa.SetName(app.Name)
+	hpa.SetNamespace(app.Namespace)
+	hpa.SetLabels(app.Labels)
+	hpa.Spec = autoscalingv2.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+			Name:       app.Name,
+		},
+		MinReplicas: spec.MinReplicas,
+		MaxReplicas: spec.MaxReplicas,
+	}
+
+	cpu := spec.TargetCPUUtilization
+	if cpu == nil && spec.TargetMemoryUtilization == nil {
+		target := defaultTargetCPUUtilization
+		cpu = &target
+	}
+	if cpu != nil {
+		hpa.Spec.Metrics = append(hpa.Spec.Metrics, utilizationMetric(corev1.ResourceCPU, *cpu))
+	}
+	if spec.TargetMemoryUtilization != nil {
+		hpa.Spec.Metrics = append(hpa.Spec.Metrics, utilizationMetric(corev1.ResourceMemory, *spec.TargetMemoryUtilization))
+	}
+
+	if err := ctrl.SetControllerReference(app, hpa, r.Scheme); err != nil {
+		return nil, err
+	}
+	return hpa, nil
+}
+
+func utilizationMetric(name corev1.ResourceName, utilization int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &utilization,
+			},
+		},
+	}
+}
+
+// autoscaledReplicas 返回启用 HPA 时的总副本数,优先使用 HPA 计算的期望副本数,
+// HPA 还没有计算时使用稳定版本当前的副本数
+func autoscaledReplicas(hpa *autoscalingv2.HorizontalPodAutoscaler, stable *appsv1.Deployment, fallback int32) int32 {
+	if hpa != nil && hpa.Status.DesiredReplicas > 0 {
+		return hpa.Status.DesiredReplicas
+	}
+	if stable != nil && stable.Spec.Replicas != nil {
+		return *stable.Spec.Replicas
+	}
+	return fallback
+}
+
+// getAutoscaler 返回 Application 的 HorizontalPodAutoscaler,不存在时返回 nil
+func (r *ApplicationReconciler) getAutoscaler(ctx context.Context, app *dappsv1.Application) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, hpa); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		log.FromContext(ctx).Error(err, "Failed to get HorizontalPodAutoscaler.")
+		return nil, err
+	}
+	return hpa, nil
+}
+
+// replicasUntilAutoscaled 启用 HPA 后,在 HPA 接管副本数之前继续 apply Deployment 当前的副本数
+// operator 仍然拥有 replicas 字段时从 apply 的配置中删除该字段,副本数会被重置为默认值 1,
+// HPA 通过 scale 子资源修改副本数后字段属于 HPA,此后 apply 的配置中不再包含副本数
+func (r *ApplicationReconciler) replicasUntilAutoscaled(ctx context.Context, key types.NamespacedName) (*int32, error) {
+	dp := &appsv1.Deployment{}
+	if err := r.Get(ctx, key, dp); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		log.FromContext(ctx).Error(err, "Failed to get Deployment.", "name", key.Name)
+		return nil, err
+	}
+	if !ownsReplicas(dp) {
+		return nil, nil
+	}
+	return dp.Spec.Replicas, nil
+}
+
+// ownsReplicas 判断 operator 是否通过 apply 拥有 Deployment 的 spec.replicas 字段
+func ownsReplicas(dp *appsv1.Deployment) bool {
+	for _, entry := range dp.ManagedFields {
+		if entry.Manager != FieldManager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, ok := fields["f:spec"]["f:replicas"]; ok {
+			return true
+		}
+	}
+	return false
+}
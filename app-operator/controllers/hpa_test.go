@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"testing"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAutoscaledReplicas(t *testing.T) {
+	stable := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32Ptr(2)}}
+	tests := []struct {
+		name   string
+		hpa    *autoscalingv2.HorizontalPodAutoscaler
+		stable *appsv1.Deployment
+		want   int32
+	}{
+		{
+			name:   "hpa desired replicas",
+			hpa:    &autoscalingv2.HorizontalPodAutoscaler{Status: autoscalingv2.HorizontalPodAutoscalerStatus{DesiredReplicas: 5}},
+			stable: stable,
+			want:   5,
+		},
+		{
+			name:   "hpa not computed yet",
+			hpa:    &autoscalingv2.HorizontalPodAutoscaler{},
+			stable: stable,
+			want:   2,
+		},
+		{
+			name:   "no hpa",
+			stable: stable,
+			want:   2,
+		},
+		{
+			name: "nothing created yet",
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := autoscaledReplicas(tt.hpa, tt.stable, 1); got != tt.want {
+				t.Errorf("autoscaledReplicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolloutReplicas(t *testing.T) {
+	autoscaled := newTestApplication()
+	autoscaled.Spec.Autoscaling = &dappsv1.AutoscalingSpec{MaxReplicas: 10}
+	fixed := newTestApplication()
+	fixed.Spec.Deployment.Replicas = int32Ptr(3)
+
+	tests := []struct {
+		name  string
+		app   *dappsv1.Application
+		st    dappsv1.RolloutStatus
+		total int32
+		want  int32
+	}{
+		{
+			name:  "autoscaled, recorded at rollout start",
+			app:   autoscaled,
+			st:    dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, Replicas: 4},
+			total: 6,
+			want:  4,
+		},
+		{
+			name:  "autoscaled, no rollout",
+			app:   autoscaled,
+			st:    dappsv1.RolloutStatus{Phase: dappsv1.RolloutHealthy},
+			total: 6,
+			want:  6,
+		},
+		{
+			name:  "fixed replicas follow the spec",
+			app:   fixed,
+			st:    dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, Replicas: 4},
+			total: 3,
+			want:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rolloutReplicas(tt.app, &tt.st, tt.total); got != tt.want {
+				t.Errorf("rolloutReplicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnsReplicas(t *testing.T) {
+	entry := func(manager string, operation metav1.ManagedFieldsOperationType, fields string) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  operation,
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(fields)},
+		}
+	}
+	withReplicas := `{"f:metadata":{"f:labels":{}},"f:spec":{"f:replicas":{},"f:template":{}}}`
+	withoutReplicas := `{"f:metadata":{"f:labels":{}},"f:spec":{"f:template":{}}}`
+
+	tests := []struct {
+		name    string
+		entries []metav1.ManagedFieldsEntry
+		want    bool
+	}{
+		{
+			name:    "applied by the operator",
+			entries: []metav1.ManagedFieldsEntry{entry(FieldManager, metav1.ManagedFieldsOperationApply, withReplicas)},
+			want:    true,
+		},
+		{
+			name: "taken over by the hpa",
+			entries: []metav1.ManagedFieldsEntry{
+				entry(FieldManager, metav1.ManagedFieldsOperationApply, withoutReplicas),
+				entry("kube-controller-manager", metav1.ManagedFieldsOperationUpdate, `{"f:spec":{"f:replicas":{}}}`),
+			},
+			want: false,
+		},
+		{
+			name:    "updated by another manager",
+			entries: []metav1.ManagedFieldsEntry{entry("kubectl", metav1.ManagedFieldsOperationApply, withReplicas)},
+			want:    false,
+		},
+		{
+			name: "no managed fields",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{ManagedFields: tt.entries}}
+			if got := ownsReplicas(dp); got != tt.want {
+				t.Errorf("ownsReplicas() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
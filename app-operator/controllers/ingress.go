@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reconcileIngress 根据 spec.ingress apply Ingress,没有设置时删除
+func (r *ApplicationReconciler) reconcileIngress(ctx context.Context, app *dappsv1.Application) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if app.Spec.Ingress == nil {
+		ing := &networkingv1.Ingress{}
+		ing.SetName(app.Name)
+		ing.SetNamespace(app.Namespace)
+		if err := r.deleteChild(ctx, app, ing); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		app.Status.Ingress = nil
+		return ctrl.Result{}, nil
+	}
+
+	ing, err := r.constructIngress(app)
+	if err != nil {
+		log.Error(err, "Failed to construct Ingress, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	if err := r.Patch(ctx, ing, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply Ingress, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+
+	app.Status.Ingress = ing.Status.DeepCopy()
+	return ctrl.Result{}, nil
+}
+
+// constructIngress 构造用于 apply 的 Ingress,转发到 Application 的 Service
+func (r *ApplicationReconciler) constructIngress(app *dappsv1.Application) (*networkingv1.Ingress, error) {
+	spec := app.Spec.Ingress
+
+	var port int32
+	switch {
+	case spec.ServicePort != nil:
+		port = *spec.ServicePort
+	case len(app.Spec.Service.Ports) > 0:
+		port = app.Spec.Service.Ports[0].Port
+	default:
+		return nil, fmt.Errorf("the Service of Application %s has no ports", app.Name)
+	}
+	path := spec.Path
+	if path == "" {
+		path = "/"
+	}
+	pathType := networkingv1.PathTypePrefix
+
+	ing := &networkingv1.Ingress{}
+	ing.SetGroupVersionKind(networkingv1.SchemeGroupVersion.WithKind("Ingress"))
+	ing.SetName(app.Name)
+	ing.SetNamespace(app.Namespace)
+	ing.SetLabels(app.Labels)
+	ing.SetAnnotations(spec.Annotations)
+	ing.Spec = networkingv1.IngressSpec{
+		IngressClassName: spec.ClassName,
+		Rules: []networkingv1.IngressRule{{
+			Host: spec.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     path,
+						PathType: &pathType,
+						Backend: networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: app.Name,
+								Port: networkingv1.ServiceBackendPort{Number: port},
+							},
+						},
+					}},
+				},
+			},
+		}},
+	}
+	if spec.TLSSecretName != "" {
+		ing.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{spec.Host}, SecretName: spec.TLSSecretName}}
+	}
+
+	if err := ctrl.SetControllerReference(app, ing, r.Scheme); err != nil {
+		return nil, err
+	}
+	return ing, nil
+}
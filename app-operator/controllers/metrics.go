@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// reconcileTotal Application 的 reconcile 次数,注册到 controller-runtime 的 /metrics 中
+var reconcileTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "app_operator_application_reconcile_total",
+	Help: "Total number of Application reconciles.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(reconcileTotal)
+}
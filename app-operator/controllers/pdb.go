@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reconcileDisruptionBudget 根据 spec.disruptionBudget apply PodDisruptionBudget,没有设置时删除
+func (r *ApplicationReconciler) reconcileDisruptionBudget(ctx context.Context, app *dappsv1.Application) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if app.Spec.DisruptionBudget == nil {
+		pdb := &policyv1.PodDisruptionBudget{}
+		pdb.SetName(app.Name)
+		pdb.SetNamespace(app.Namespace)
+		if err := r.deleteChild(ctx, app, pdb); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		app.Status.DisruptionBudget = nil
+		return ctrl.Result{}, nil
+	}
+
+	pdb, err := r.constructDisruptionBudget(app)
+	if err != nil {
+		log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	if err := r.Patch(ctx, pdb, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply PodDisruptionBudget, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+
+	app.Status.DisruptionBudget = pdb.Status.DeepCopy()
+	return ctrl.Result{}, nil
+}
+
+// constructDisruptionBudget 构造用于 apply 的 PodDisruptionBudget,选择 Deployment 的所有 Pod
+func (r *ApplicationReconciler) constructDisruptionBudget(app *dappsv1.Application) (*policyv1.PodDisruptionBudget, error) {
+	spec := app.Spec.DisruptionBudget
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	pdb.SetGroupVersionKind(policyv1.SchemeGroupVersion.WithKind("PodDisruptionBudget"))
+	pdb.SetName(app.Name)
+	pdb.SetNamespace(app.Namespace)
+	pdb.SetLabels(app.Labels)
+	pdb.Spec = policyv1.PodDisruptionBudgetSpec{
+		Selector:       app.Spec.Deployment.Selector,
+		MinAvailable:   spec.MinAvailable,
+		MaxUnavailable: spec.MaxUnavailable,
+	}
+	if pdb.Spec.MinAvailable == nil && pdb.Spec.MaxUnavailable == nil {
+		maxUnavailable := intstr.FromInt(1)
+		pdb.Spec.MaxUnavailable = &maxUnavailable
+	}
+
+	if err := ctrl.SetControllerReference(app, pdb, r.Scheme); err != nil {
+		return nil, err
+	}
+	return pdb, nil
+}
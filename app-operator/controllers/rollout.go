@@ -0,0 +1,397 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// TrackLabel 区分稳定版本和新版本 Pod 的标签
+	TrackLabel  = "apps.costalong.com/track"
+	TrackStable = "stable"
+	TrackCanary = "canary"
+
+	// PromoteAnnotation 手动推进暂停的发布,处理后会被删除
+	PromoteAnnotation = "apps.costalong.com/promote"
+)
+
+func canaryName(app *dappsv1.Application) string {
+	return app.Name + "-canary"
+}
+
+// templateHash 计算 Pod 模板的 hash,用于判断模板是否发生变化
+func templateHash(template corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+func totalReplicas(app *dappsv1.Application) int32 {
+	if app.Spec.Deployment.Replicas != nil {
+		return *app.Spec.Deployment.Replicas
+	}
+	return 1
+}
+
+// reconcileRollout 使用稳定版本和新版本两个 Deployment 渐进式发布
+// Pod 模板变化时先创建新版本的 Deployment,按策略验证通过后再更新稳定版本,最后删除新版本的 Deployment
+// 新版本不可用时自动回滚,稳定版本保持不变
+func (r *ApplicationReconciler) reconcileRollout(ctx context.Context, app *dappsv1.Application,
+	template corev1.PodTemplateSpec) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if app.Status.Rollout == nil {
+		app.Status.Rollout = &dappsv1.RolloutStatus{}
+	}
+	st := app.Status.Rollout
+	desired := templateHash(template)
+	total := totalReplicas(app)
+
+	stable := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, stable)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Deployment, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	// 启用 HPA 时稳定版本的副本数由 HPA 决定,以 HPA 计算的期望副本数为总副本数
+	if app.Spec.Autoscaling != nil {
+		var current *appsv1.Deployment
+		if err == nil {
+			current = stable
+		}
+		hpa, err := r.getAutoscaler(ctx, app)
+		if err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		total = autoscaledReplicas(hpa, current, total)
+	}
+	app.Status.Replicas = total
+
+	// 第一次发布或者模板没有变化,直接更新稳定版本
+	if errors.IsNotFound(err) || st.StableHash == "" || st.StableHash == desired {
+		if err := r.applyTrack(ctx, app, app.Name, TrackStable, template, total); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		if err := r.deleteCanary(ctx, app); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		*st = dappsv1.RolloutStatus{Phase: dappsv1.RolloutHealthy, StableHash: desired}
+		return ctrl.Result{}, nil
+	}
+
+	// 新版本已经被回滚,保持稳定版本,直到模板再次变化
+	if st.Phase == dappsv1.RolloutAborted && st.CanaryHash == desired {
+		return ctrl.Result{}, r.applyTrack(ctx, app, app.Name, TrackStable, stable.Spec.Template, total)
+	}
+
+	now := metav1.Now()
+	if st.CanaryHash != desired {
+		st.CanaryHash = desired
+		st.CurrentStep = 0
+		st.StepStartedAt = &now
+		st.Phase = dappsv1.RolloutProgressing
+		st.Message = "rolling out new revision"
+		st.Replicas = total
+	}
+	total = rolloutReplicas(app, st, total)
+	app.Status.Replicas = total
+
+	canary := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: canaryName(app)}, canary); err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "Failed to get canary Deployment, will requeue after a short time.")
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		canary = nil
+	}
+
+	// 新版本不可用,自动回滚
+	if canary != nil && canaryFailed(canary, now.Time) {
+		return r.abortRollout(ctx, app, stable, total, "canary is not available, rolled back to the stable revision")
+	}
+
+	strategy := app.Spec.Strategy
+	progress := advanceRollout(strategy, st, canary, total, app.Annotations[PromoteAnnotation] == "true", now)
+	if progress.timedOut {
+		return r.abortRollout(ctx, app, stable, total, fmt.Sprintf(
+			"canary is not ready after %s, rolled back to the stable revision", progressDeadline(canary)))
+	}
+	if progress.promoted {
+		if err := r.consumePromotion(ctx, app); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+	}
+	canaryReplicas := progress.canaryReplicas
+
+	if err := r.applyTrack(ctx, app, canaryName(app), TrackCanary, template, canaryReplicas); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+
+	// 切换阶段更新稳定版本,新版本继续提供服务,稳定版本全部就绪后删除新版本
+	if st.Phase == dappsv1.RolloutPromoting {
+		st.Message = "promoting new revision to stable"
+		dp, err := r.applyTrackDeployment(ctx, app, app.Name, TrackStable, template, total)
+		if err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		}
+		target := total
+		if app.Spec.Autoscaling != nil && dp.Spec.Replicas != nil {
+			target = *dp.Spec.Replicas
+		}
+		if deploymentReady(dp, target) {
+			st.StableHash = desired
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// 金丝雀发布时稳定版本让出新版本占用的副本,蓝绿发布时稳定版本保持全部副本
+	// 启用 HPA 时稳定版本的副本数由 HPA 决定,新版本的副本在此之外额外创建
+	stableReplicas := total
+	if strategy.BlueGreen == nil {
+		stableReplicas = total - canaryReplicas
+		if stableReplicas < 0 {
+			stableReplicas = 0
+		}
+	}
+	if err := r.applyTrack(ctx, app, app.Name, TrackStable, stable.Spec.Template, stableReplicas); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	return ctrl.Result{RequeueAfter: progress.requeueAfter}, nil
+}
+
+// rolloutProgress 一次 reconcile 中推进发布步骤的结果
+type rolloutProgress struct {
+	canaryReplicas int32
+	// 定时暂停的剩余时间
+	requeueAfter time.Duration
+	// 使用了 promote 注解,需要删除注解
+	promoted bool
+	// 新版本在步骤开始后超过 progressDeadlineSeconds 仍然没有就绪
+	timedOut bool
+}
+
+// advanceRollout 根据新版本的状态推进发布的步骤,只修改 st,不访问 API server
+func advanceRollout(strategy *dappsv1.RolloutStrategy, st *dappsv1.RolloutStatus, canary *appsv1.Deployment,
+	total int32, promote bool, now metav1.Time) rolloutProgress {
+	var p rolloutProgress
+	// 是否在等待新版本的副本就绪
+	waiting := false
+
+	switch {
+	case strategy.BlueGreen != nil:
+		p.canaryReplicas = total
+		if st.Phase != dappsv1.RolloutPromoting {
+			if !deploymentReady(canary, total) {
+				waiting = true
+			} else if strategy.BlueGreen.AutoPromotion || promote {
+				st.Phase = dappsv1.RolloutPromoting
+			} else {
+				st.Phase = dappsv1.RolloutPaused
+				st.Message = "waiting for promotion"
+			}
+		}
+	case strategy.Canary != nil:
+		steps := strategy.Canary.Steps
+		for st.Phase != dappsv1.RolloutPromoting && int(st.CurrentStep) < len(steps) {
+			step := steps[st.CurrentStep]
+			p.canaryReplicas = canaryWeightReplicas(steps, int(st.CurrentStep), total)
+			if step.Weight != nil && !deploymentReady(canary, p.canaryReplicas) {
+				waiting = true
+				break
+			}
+			if step.Pause != nil {
+				if step.Pause.Duration != nil {
+					if wait := st.StepStartedAt.Add(step.Pause.Duration.Duration).Sub(now.Time); wait > 0 {
+						p.requeueAfter = wait
+						st.Message = fmt.Sprintf("paused at step %d", st.CurrentStep)
+						break
+					}
+				} else if !promote {
+					st.Phase = dappsv1.RolloutPaused
+					st.Message = fmt.Sprintf("paused at step %d, waiting for promotion", st.CurrentStep)
+					break
+				} else {
+					promote = false
+					p.promoted = true
+				}
+			}
+			st.CurrentStep++
+			st.StepStartedAt = &now
+			st.Phase = dappsv1.RolloutProgressing
+		}
+		if int(st.CurrentStep) >= len(steps) {
+			st.Phase = dappsv1.RolloutPromoting
+		}
+		p.canaryReplicas = canaryWeightReplicas(steps, int(st.CurrentStep), total)
+	}
+
+	if promote && st.Phase == dappsv1.RolloutPromoting {
+		p.promoted = true
+	}
+	// 暂停期间新版本变为不可用由 canaryFailed 根据 Available 条件判断
+	if waiting && st.Phase == dappsv1.RolloutProgressing && canary != nil && st.StepStartedAt != nil &&
+		now.Sub(st.StepStartedAt.Time) > progressDeadline(canary) {
+		p.timedOut = true
+	}
+	return p
+}
+
+// abortRollout 删除新版本的 Deployment 并恢复稳定版本,直到模板再次变化
+func (r *ApplicationReconciler) abortRollout(ctx context.Context, app *dappsv1.Application, stable *appsv1.Deployment,
+	total int32, message string) (ctrl.Result, error) {
+	log.FromContext(ctx).Info("Rolling back the canary.", "reason", message)
+	if err := r.deleteCanary(ctx, app); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	app.Status.Rollout.Phase = dappsv1.RolloutAborted
+	app.Status.Rollout.Message = message
+	return ctrl.Result{}, r.applyTrack(ctx, app, app.Name, TrackStable, stable.Spec.Template, total)
+}
+
+// rolloutReplicas 返回发布期间的总副本数,启用 HPA 时使用发布开始时记录的副本数,
+// 避免 HPA 调整稳定版本时新版本的副本数随之变化
+func rolloutReplicas(app *dappsv1.Application, st *dappsv1.RolloutStatus, total int32) int32 {
+	if app.Spec.Autoscaling != nil && st.Replicas > 0 {
+		return st.Replicas
+	}
+	return total
+}
+
+// canaryWeightReplicas 返回当前步骤及之前最后一个设置了 weight 的步骤对应的新版本副本数
+func canaryWeightReplicas(steps []dappsv1.CanaryStep, current int, total int32) int32 {
+	var weight int32
+	for i := 0; i < len(steps) && i <= current; i++ {
+		if steps[i].Weight != nil {
+			weight = *steps[i].Weight
+		}
+	}
+	return (total*weight + 99) / 100
+}
+
+func (r *ApplicationReconciler) applyTrack(ctx context.Context, app *dappsv1.Application, name, track string,
+	template corev1.PodTemplateSpec, replicas int32) error {
+	_, err := r.applyTrackDeployment(ctx, app, name, track, template, replicas)
+	return err
+}
+
+// applyTrackDeployment apply 稳定版本或者新版本的 Deployment,稳定版本的状态记录到 Application 中
+func (r *ApplicationReconciler) applyTrackDeployment(ctx context.Context, app *dappsv1.Application, name, track string,
+	template corev1.PodTemplateSpec, replicas int32) (*appsv1.Deployment, error) {
+	log := log.FromContext(ctx)
+
+	dp, err := r.constructDeployment(app, *template.DeepCopy())
+	if err != nil {
+		log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
+		return nil, err
+	}
+	dp.SetName(name)
+	dp.SetLabels(withTrack(app.Labels, track))
+	dp.Spec.Replicas = &replicas
+	// 启用 HPA 时稳定版本的副本数属于 HPA,新版本的副本数仍然按发布的步骤设置
+	if track == TrackStable && app.Spec.Autoscaling != nil {
+		if dp.Spec.Replicas, err = r.replicasUntilAutoscaled(ctx, client.ObjectKeyFromObject(dp)); err != nil {
+			return nil, err
+		}
+	}
+	dp.Spec.Template.SetLabels(withTrack(app.Labels, track))
+	// 稳定版本的 selector 不可修改,新版本的 selector 增加 track 标签,避免两个 Deployment 选择相同的 Pod
+	if track == TrackCanary && dp.Spec.Selector != nil {
+		dp.Spec.Selector = dp.Spec.Selector.DeepCopy()
+		dp.Spec.Selector.MatchLabels = withTrack(dp.Spec.Selector.MatchLabels, track)
+	}
+
+	if err := r.Patch(ctx, dp, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply Deployment, will requeue after a short time.", "name", name)
+		return nil, err
+	}
+	if track == TrackStable {
+		app.Status.Workflow = dp.Status
+	}
+	return dp, nil
+}
+
+func (r *ApplicationReconciler) deleteCanary(ctx context.Context, app *dappsv1.Application) error {
+	dp := &appsv1.Deployment{}
+	dp.SetName(canaryName(app))
+	dp.SetNamespace(app.Namespace)
+	if err := r.Delete(ctx, dp); err != nil && !errors.IsNotFound(err) {
+		log.FromContext(ctx).Error(err, "Failed to delete canary Deployment.")
+		return err
+	}
+	return nil
+}
+
+// consumePromotion 删除 promote 注解,使用副本提交,避免覆盖内存中的状态
+func (r *ApplicationReconciler) consumePromotion(ctx context.Context, app *dappsv1.Application) error {
+	latest := app.DeepCopy()
+	delete(latest.Annotations, PromoteAnnotation)
+	if err := r.Patch(ctx, latest, client.MergeFrom(app)); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to remove the promote annotation.")
+		return err
+	}
+	app.Annotations = latest.Annotations
+	app.ResourceVersion = latest.ResourceVersion
+	return nil
+}
+
+func withTrack(labels map[string]string, track string) map[string]string {
+	res := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		res[k] = v
+	}
+	res[TrackLabel] = track
+	return res
+}
+
+// deploymentReady 判断 Deployment 的副本是否已经全部更新并且可用
+func deploymentReady(dp *appsv1.Deployment, replicas int32) bool {
+	if dp == nil || dp.Spec.Replicas == nil || *dp.Spec.Replicas != replicas {
+		return false
+	}
+	s := dp.Status
+	return s.ObservedGeneration >= dp.Generation && s.UpdatedReplicas >= replicas &&
+		s.AvailableReplicas >= replicas && s.Replicas == replicas
+}
+
+// canaryFailed 判断新版本是否失败,除了 deploymentFailed 的情况,
+// Available 为 False 的时间超过 progressDeadlineSeconds 也认为失败,例如副本就绪后又不断重启
+func canaryFailed(canary *appsv1.Deployment, now time.Time) bool {
+	if deploymentFailed(canary.Status) {
+		return true
+	}
+	c := deploymentCondition(canary.Status, appsv1.DeploymentAvailable)
+	return c != nil && c.Status == corev1.ConditionFalse && now.Sub(c.LastTransitionTime.Time) > progressDeadline(canary)
+}
+
+// progressDeadline 返回 Deployment 的 progressDeadlineSeconds,没有设置时使用 Kubernetes 的默认值
+func progressDeadline(dp *appsv1.Deployment) time.Duration {
+	if dp.Spec.ProgressDeadlineSeconds != nil {
+		return time.Duration(*dp.Spec.ProgressDeadlineSeconds) * time.Second
+	}
+	return 600 * time.Second
+}
+
+// deploymentFailed 判断 Deployment 是否创建副本失败或者滚动更新超时
+func deploymentFailed(status appsv1.DeploymentStatus) bool {
+	if c := deploymentCondition(status, appsv1.DeploymentReplicaFailure); c != nil && c.Status == corev1.ConditionTrue {
+		return true
+	}
+	c := deploymentCondition(status, appsv1.DeploymentProgressing)
+	return c != nil && c.Reason == "ProgressDeadlineExceeded"
+}
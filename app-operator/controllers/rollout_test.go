@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func weightStep(weight int32) dappsv1.CanaryStep {
+	return dappsv1.CanaryStep{Weight: &weight}
+}
+
+func pauseStep(d time.Duration) dappsv1.CanaryStep {
+	if d == 0 {
+		return dappsv1.CanaryStep{Pause: &dappsv1.RolloutPause{}}
+	}
+	return dappsv1.CanaryStep{Pause: &dappsv1.RolloutPause{Duration: &metav1.Duration{Duration: d}}}
+}
+
+// newCanary 返回副本数为 replicas 的新版本 Deployment,ready 个副本已经更新并且可用
+func newCanary(replicas, ready int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo-canary", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+			Replicas:           replicas,
+			UpdatedReplicas:    ready,
+			AvailableReplicas:  ready,
+		},
+	}
+}
+
+func TestCanaryWeightReplicas(t *testing.T) {
+	steps := []dappsv1.CanaryStep{weightStep(10), pauseStep(0), weightStep(50), pauseStep(time.Minute), weightStep(100)}
+	tests := []struct {
+		name    string
+		steps   []dappsv1.CanaryStep
+		current int
+		total   int32
+		want    int32
+	}{
+		{name: "no steps", total: 10, want: 0},
+		{name: "first step", steps: steps, current: 0, total: 10, want: 1},
+		{name: "rounds up", steps: steps, current: 0, total: 3, want: 1},
+		{name: "pause keeps previous weight", steps: steps, current: 1, total: 10, want: 1},
+		{name: "half", steps: steps, current: 2, total: 5, want: 3},
+		{name: "all", steps: steps, current: 4, total: 5, want: 5},
+		{name: "past the last step", steps: steps, current: 5, total: 5, want: 5},
+		{name: "pause before any weight", steps: []dappsv1.CanaryStep{pauseStep(0), weightStep(20)}, current: 0, total: 10, want: 0},
+		{name: "no replicas", steps: steps, current: 4, total: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canaryWeightReplicas(tt.steps, tt.current, tt.total); got != tt.want {
+				t.Errorf("canaryWeightReplicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdvanceCanary(t *testing.T) {
+	now := metav1.NewTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ago := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(-d))
+		return &t
+	}
+	steps := []dappsv1.CanaryStep{weightStep(20), pauseStep(10 * time.Minute), weightStep(50), pauseStep(0), weightStep(100)}
+
+	tests := []struct {
+		name    string
+		st      dappsv1.RolloutStatus
+		canary  *appsv1.Deployment
+		promote bool
+
+		wantStep     int32
+		wantPhase    dappsv1.RolloutPhase
+		wantReplicas int32
+		wantRequeue  bool
+		wantPromoted bool
+		wantTimedOut bool
+	}{
+		{
+			name:         "canary not created yet",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, StepStartedAt: ago(0)},
+			wantStep:     0,
+			wantPhase:    dappsv1.RolloutProgressing,
+			wantReplicas: 2,
+		},
+		{
+			name:         "waiting for the weight",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, StepStartedAt: ago(time.Minute)},
+			canary:       newCanary(2, 1),
+			wantStep:     0,
+			wantPhase:    dappsv1.RolloutProgressing,
+			wantReplicas: 2,
+		},
+		{
+			name:         "weight reached, timed pause starts",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, StepStartedAt: ago(time.Minute)},
+			canary:       newCanary(2, 2),
+			wantStep:     1,
+			wantPhase:    dappsv1.RolloutProgressing,
+			wantReplicas: 2,
+			wantRequeue:  true,
+		},
+		{
+			name:         "timed pause elapsed",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, CurrentStep: 1, StepStartedAt: ago(11 * time.Minute)},
+			canary:       newCanary(2, 2),
+			wantStep:     2,
+			wantPhase:    dappsv1.RolloutProgressing,
+			wantReplicas: 5,
+		},
+		{
+			name:         "manual pause waits for promotion",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, CurrentStep: 2, StepStartedAt: ago(time.Minute)},
+			canary:       newCanary(5, 5),
+			wantStep:     3,
+			wantPhase:    dappsv1.RolloutPaused,
+			wantReplicas: 5,
+		},
+		{
+			name:         "promoted at manual pause",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutPaused, CurrentStep: 3, StepStartedAt: ago(time.Hour)},
+			canary:       newCanary(5, 5),
+			promote:      true,
+			wantStep:     4,
+			wantPhase:    dappsv1.RolloutProgressing,
+			wantReplicas: 10,
+			wantPromoted: true,
+		},
+		{
+			name:         "all steps done",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, CurrentStep: 4, StepStartedAt: ago(time.Minute)},
+			canary:       newCanary(10, 10),
+			wantStep:     5,
+			wantPhase:    dappsv1.RolloutPromoting,
+			wantReplicas: 10,
+		},
+		{
+			name:         "promote while promoting is consumed",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutPromoting, CurrentStep: 5, StepStartedAt: ago(time.Minute)},
+			canary:       newCanary(10, 10),
+			promote:      true,
+			wantStep:     5,
+			wantPhase:    dappsv1.RolloutPromoting,
+			wantReplicas: 10,
+			wantPromoted: true,
+		},
+		{
+			name:         "not ready after the progress deadline",
+			st:           dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, CurrentStep: 2, StepStartedAt: ago(11 * time.Minute)},
+			canary:       newCanary(5, 3),
+			wantStep:     2,
+			wantPhase:    dappsv1.RolloutProgressing,
+			wantReplicas: 5,
+			wantTimedOut: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := &dappsv1.RolloutStrategy{Canary: &dappsv1.CanaryStrategy{Steps: steps}}
+			st := tt.st
+			p := advanceRollout(strategy, &st, tt.canary, 10, tt.promote, now)
+
+			if st.CurrentStep != tt.wantStep || st.Phase != tt.wantPhase {
+				t.Errorf("step %d phase %s, want step %d phase %s", st.CurrentStep, st.Phase, tt.wantStep, tt.wantPhase)
+			}
+			if p.canaryReplicas != tt.wantReplicas {
+				t.Errorf("canary replicas = %d, want %d", p.canaryReplicas, tt.wantReplicas)
+			}
+			if (p.requeueAfter > 0) != tt.wantRequeue {
+				t.Errorf("requeueAfter = %s, want requeue %v", p.requeueAfter, tt.wantRequeue)
+			}
+			if p.promoted != tt.wantPromoted {
+				t.Errorf("promoted = %v, want %v", p.promoted, tt.wantPromoted)
+			}
+			if p.timedOut != tt.wantTimedOut {
+				t.Errorf("timedOut = %v, want %v", p.timedOut, tt.wantTimedOut)
+			}
+		})
+	}
+}
+
+func TestAdvanceBlueGreen(t *testing.T) {
+	now := metav1.NewTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	started := metav1.NewTime(now.Add(-time.Minute))
+
+	tests := []struct {
+		name          string
+		autoPromotion bool
+		promote       bool
+		canary        *appsv1.Deployment
+		startedAt     metav1.Time
+		wantPhase     dappsv1.RolloutPhase
+		wantPromoted  bool
+		wantTimedOut  bool
+	}{
+		{
+			name:      "waiting for the new revision",
+			canary:    newCanary(3, 1),
+			startedAt: started,
+			wantPhase: dappsv1.RolloutProgressing,
+		},
+		{
+			name:      "ready, waiting for promotion",
+			canary:    newCanary(3, 3),
+			startedAt: started,
+			wantPhase: dappsv1.RolloutPaused,
+		},
+		{
+			name:          "auto promotion",
+			autoPromotion: true,
+			canary:        newCanary(3, 3),
+			startedAt:     started,
+			wantPhase:     dappsv1.RolloutPromoting,
+		},
+		{
+			name:         "promoted",
+			promote:      true,
+			canary:       newCanary(3, 3),
+			startedAt:    started,
+			wantPhase:    dappsv1.RolloutPromoting,
+			wantPromoted: true,
+		},
+		{
+			name:         "not ready after the progress deadline",
+			canary:       newCanary(3, 1),
+			startedAt:    metav1.NewTime(now.Add(-time.Hour)),
+			wantPhase:    dappsv1.RolloutProgressing,
+			wantTimedOut: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := &dappsv1.RolloutStrategy{BlueGreen: &dappsv1.BlueGreenStrategy{AutoPromotion: tt.autoPromotion}}
+			st := dappsv1.RolloutStatus{Phase: dappsv1.RolloutProgressing, StepStartedAt: &tt.startedAt}
+			p := advanceRollout(strategy, &st, tt.canary, 3, tt.promote, now)
+
+			if st.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", st.Phase, tt.wantPhase)
+			}
+			if p.canaryReplicas != 3 {
+				t.Errorf("canary replicas = %d, want 3", p.canaryReplicas)
+			}
+			if p.promoted != tt.wantPromoted {
+				t.Errorf("promoted = %v, want %v", p.promoted, tt.wantPromoted)
+			}
+			if p.timedOut != tt.wantTimedOut {
+				t.Errorf("timedOut = %v, want %v", p.timedOut, tt.wantTimedOut)
+			}
+		})
+	}
+}
+
+func TestCanaryFailed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		conditions []appsv1.DeploymentCondition
+		want       bool
+	}{
+		{
+			name: "available",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue},
+			},
+		},
+		{
+			name: "replica failure",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentReplicaFailure, Status: corev1.ConditionTrue},
+			},
+			want: true,
+		},
+		{
+			name: "progress deadline exceeded",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+			},
+			want: true,
+		},
+		{
+			name: "unavailable for a short time",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(now.Add(-time.Minute))},
+			},
+		},
+		{
+			name: "unavailable longer than the progress deadline",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(now.Add(-11 * time.Minute))},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canary := newCanary(2, 2)
+			canary.Status.Conditions = tt.conditions
+			if got := canaryFailed(canary, now); got != tt.want {
+				t.Errorf("canaryFailed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,58 +5,91 @@ import (
 	dappsv1 "github.com/costa92/app-operator/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/types"
-	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 func (r *ApplicationReconciler) reconcileService(ctx context.Context, app *dappsv1.Application) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
-	var svc = &corev1.Service{}
-	err := r.Get(ctx, types.NamespacedName{
-		Namespace: app.Namespace,
-		Name:      app.Name,
-	}, svc)
-
-	if err == nil {
-		log.Info("The Service has already exist.")
-		if reflect.DeepEqual(svc.Status, app.Status.Network) {
-			return ctrl.Result{}, nil
-		}
-
-		app.Status.Network = svc.Status
-		if err := r.Status().Update(ctx, app); err != nil {
-			log.Error(err, "Failed to update Application status")
-			return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
-		}
-		log.Info("The Application status has been updated.")
-		return ctrl.Result{}, nil
+	svc, err := r.constructService(app)
+	if err != nil {
+		log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
 	}
 
-	if !errors.IsNotFound(err) {
-		log.Error(err, "Failed to get Service, will requeue after a short time.")
+	// 使用 server-side apply 创建或者更新 Service,clusterIP 等由 API Server 分配的字段不受影响
+	if err := r.Patch(ctx, svc, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply Service, will requeue after a short time.")
 		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
 	}
+	log.Info("The Service has been applied.")
 
-	newSvc := &corev1.Service{}
-	newSvc.SetName(app.Name)
-	newSvc.SetNamespace(app.Namespace)
-	newSvc.SetLabels(app.Labels)
-	newSvc.Spec = app.Spec.Service.ServiceSpec
-	newSvc.Spec.Selector = app.Labels
+	app.Status.Network = svc.Status
+	app.Status.ClusterIP = svc.Spec.ClusterIP
+	return ctrl.Result{}, r.reconcilePreviewService(ctx, app)
+}
+
+// reconcilePreviewService 蓝绿发布时创建选择新版本 Pod 的预览 Service,未启用蓝绿发布时删除
+func (r *ApplicationReconciler) reconcilePreviewService(ctx context.Context, app *dappsv1.Application) error {
+	log := log.FromContext(ctx)
+
+	if app.Spec.Strategy == nil || app.Spec.Strategy.BlueGreen == nil {
+		svc := &corev1.Service{}
+		svc.SetName(previewServiceName(app))
+		svc.SetNamespace(app.Namespace)
+		if err := r.Delete(ctx, svc); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete preview Service.")
+			return err
+		}
+		return nil
+	}
 
-	if err := ctrl.SetControllerReference(app, newSvc, r.Scheme); err != nil {
+	svc, err := r.constructService(app)
+	if err != nil {
 		log.Error(err, "Failed to SetControllerReference, will requeue after a short time.")
-		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+		return err
+	}
+	svc.SetName(previewServiceName(app))
+	svc.Spec.Selector = withTrack(app.Labels, TrackCanary)
+	if err := r.Patch(ctx, svc, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		log.Error(err, "Failed to apply preview Service, will requeue after a short time.")
+		return err
 	}
+	return nil
+}
 
-	if err := r.Create(ctx, newSvc); err != nil {
-		log.Error(err, "Failed to create Service, will requeue after a short time.")
-		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+func previewServiceName(app *dappsv1.Application) string {
+	if app.Spec.Strategy != nil && app.Spec.Strategy.BlueGreen != nil && app.Spec.Strategy.BlueGreen.PreviewService != "" {
+		return app.Spec.Strategy.BlueGreen.PreviewService
 	}
+	return app.Name + "-preview"
+}
 
-	log.Info("The Service has been created.")
-	return ctrl.Result{}, nil
+// serviceSelector 蓝绿发布时 Service 只选择稳定版本的 Pod,切换阶段选择新版本的 Pod
+func serviceSelector(app *dappsv1.Application) map[string]string {
+	if app.Spec.Strategy == nil || app.Spec.Strategy.BlueGreen == nil {
+		return app.Labels
+	}
+	if rollout := app.Status.Rollout; rollout != nil && rollout.Phase == dappsv1.RolloutPromoting {
+		return withTrack(app.Labels, TrackCanary)
+	}
+	return withTrack(app.Labels, TrackStable)
+}
+
+// constructService 构造用于 apply 的 Service,只包含 operator 管理的字段
+func (r *ApplicationReconciler) constructService(app *dappsv1.Application) (*corev1.Service, error) {
+	svc := &corev1.Service{}
+	svc.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Service"))
+	svc.SetName(app.Name)
+	svc.SetNamespace(app.Namespace)
+	svc.SetLabels(app.Labels)
+	svc.Spec = *app.Spec.Service.ServiceSpec.DeepCopy()
+	svc.Spec.Selector = serviceSelector(app)
+
+	if err := ctrl.SetControllerReference(app, svc, r.Scheme); err != nil {
+		return nil, err
+	}
+	return svc, nil
 }
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// updateStatus 根据 Deployment 和 Service 的状态计算 Application 的状态条件和整体状态,有变化时才更新
+func (r *ApplicationReconciler) updateStatus(ctx context.Context, app *dappsv1.Application, original *dappsv1.ApplicationStatus) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var ep = &corev1.Endpoints{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, ep)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Endpoints, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	app.Status.ReadyEndpoints = 0
+	for _, subset := range ep.Subsets {
+		app.Status.ReadyEndpoints += int32(len(subset.Addresses))
+	}
+
+	// 比较 Deployment 的 generation 和 observedGeneration,判断最新的 spec 是否已经被处理
+	var dp = &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, dp)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Deployment, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+
+	computeStatus(app, dp.Generation)
+	if reflect.DeepEqual(original, &app.Status) {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.Status().Update(ctx, app); err != nil {
+		log.Error(err, "Failed to update Application status")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuration}, err
+	}
+	log.Info("The Application status has been updated.")
+	return ctrl.Result{}, nil
+}
+
+// computeStatus 根据 Deployment 的状态计算 Available、Progressing、Degraded 条件和整体状态
+// generation 为 Deployment 当前的 generation
+func computeStatus(app *dappsv1.Application, generation int64) {
+	dp := app.Status.Workflow
+	desired := app.Status.Replicas
+
+	app.Status.ObservedGeneration = app.Generation
+	app.Status.ReadyReplicas = dp.ReadyReplicas
+	app.Status.Ready = fmt.Sprintf("%d/%d", dp.ReadyReplicas, desired)
+
+	// Degraded: 副本创建失败或者滚动更新超时
+	degraded := false
+	if c := deploymentCondition(dp, appsv1.DeploymentReplicaFailure); c != nil && c.Status == corev1.ConditionTrue {
+		degraded = true
+		setCondition(app, dappsv1.ConditionDegraded, metav1.ConditionTrue, c.Reason, c.Message)
+	} else if c := deploymentCondition(dp, appsv1.DeploymentProgressing); c != nil && c.Reason == "ProgressDeadlineExceeded" {
+		degraded = true
+		setCondition(app, dappsv1.ConditionDegraded, metav1.ConditionTrue, c.Reason, c.Message)
+	} else {
+		setCondition(app, dappsv1.ConditionDegraded, metav1.ConditionFalse, "AsExpected", "")
+	}
+
+	// Progressing: Deployment 还没有处理最新的 spec,或者还有副本没有更新到最新版本或者没有就绪
+	// 没有处理最新的 spec 时副本数等状态仍然是上一个版本的,不能据此判断发布已经完成
+	observed := dp.ObservedGeneration >= generation
+	progressing := !observed || dp.UpdatedReplicas < desired || dp.Replicas > dp.UpdatedReplicas || dp.AvailableReplicas < desired
+	if !observed {
+		setCondition(app, dappsv1.ConditionProgressing, metav1.ConditionTrue, "NewGeneration",
+			fmt.Sprintf("deployment generation %d has not been observed yet, observed %d", generation, dp.ObservedGeneration))
+	} else if progressing {
+		setCondition(app, dappsv1.ConditionProgressing, metav1.ConditionTrue, "RollingUpdate",
+			fmt.Sprintf("%d of %d replicas updated, %d available", dp.UpdatedReplicas, desired, dp.AvailableReplicas))
+	} else {
+		setCondition(app, dappsv1.ConditionProgressing, metav1.ConditionFalse, "Complete", "all replicas are up to date")
+	}
+
+	// Available: Deployment 满足最小可用副本数
+	if c := deploymentCondition(dp, appsv1.DeploymentAvailable); c != nil && c.Status == corev1.ConditionTrue {
+		setCondition(app, dappsv1.ConditionAvailable, metav1.ConditionTrue, c.Reason, c.Message)
+	} else if c != nil {
+		setCondition(app, dappsv1.ConditionAvailable, metav1.ConditionFalse, c.Reason, c.Message)
+	} else {
+		setCondition(app, dappsv1.ConditionAvailable, metav1.ConditionUnknown, "Pending", "deployment has not reported status yet")
+	}
+
+	switch {
+	case degraded:
+		app.Status.Phase = dappsv1.ApplicationDegraded
+	case desired > 0 && dp.ReadyReplicas == 0 && dp.UpdatedReplicas == 0:
+		app.Status.Phase = dappsv1.ApplicationPending
+	case progressing:
+		app.Status.Phase = dappsv1.ApplicationProgressing
+	default:
+		app.Status.Phase = dappsv1.ApplicationRunning
+	}
+}
+
+func deploymentCondition(status appsv1.DeploymentStatus, t appsv1.DeploymentConditionType) *appsv1.DeploymentCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == t {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func setCondition(app *dappsv1.Application, t string, status metav1.ConditionStatus, reason, message string) {
+	if reason == "" {
+		reason = string(status)
+	}
+	meta.SetStatusCondition(&app.Status.Conditions, metav1.Condition{
+		Type:               t,
+		Status:             status,
+		ObservedGeneration: app.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
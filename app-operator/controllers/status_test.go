@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"testing"
+
+	dappsv1 "github.com/costa92/app-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestComputeStatus(t *testing.T) {
+	available := []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue}}
+	tests := []struct {
+		name            string
+		status          appsv1.DeploymentStatus
+		generation      int64
+		wantProgressing metav1.ConditionStatus
+		wantPhase       dappsv1.ApplicationPhase
+	}{
+		{
+			name: "running",
+			status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2,
+				ReadyReplicas: 2, AvailableReplicas: 2, Conditions: available},
+			generation:      2,
+			wantProgressing: metav1.ConditionFalse,
+			wantPhase:       dappsv1.ApplicationRunning,
+		},
+		{
+			name: "new generation not observed",
+			status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2,
+				ReadyReplicas: 2, AvailableReplicas: 2, Conditions: available},
+			generation:      2,
+			wantProgressing: metav1.ConditionTrue,
+			wantPhase:       dappsv1.ApplicationProgressing,
+		},
+		{
+			name: "rolling update",
+			status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1,
+				ReadyReplicas: 2, AvailableReplicas: 2, Conditions: available},
+			generation:      2,
+			wantProgressing: metav1.ConditionTrue,
+			wantPhase:       dappsv1.ApplicationProgressing,
+		},
+		{
+			name:            "pending",
+			status:          appsv1.DeploymentStatus{ObservedGeneration: 1},
+			generation:      1,
+			wantProgressing: metav1.ConditionTrue,
+			wantPhase:       dappsv1.ApplicationPending,
+		},
+		{
+			name: "progress deadline exceeded",
+			status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1,
+				ReadyReplicas: 1, AvailableReplicas: 1, Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+				}},
+			generation:      2,
+			wantProgressing: metav1.ConditionTrue,
+			wantPhase:       dappsv1.ApplicationDegraded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Status.Replicas = 2
+			app.Status.Workflow = tt.status
+
+			computeStatus(app, tt.generation)
+			if c := meta.FindStatusCondition(app.Status.Conditions, dappsv1.ConditionProgressing); c == nil || c.Status != tt.wantProgressing {
+				t.Errorf("Progressing = %v, want %s", c, tt.wantProgressing)
+			}
+			if app.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", app.Status.Phase, tt.wantPhase)
+			}
+		})
+	}
+}
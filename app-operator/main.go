@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -54,11 +55,23 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var minReplicas, maxReplicas int
+	var allowedRegistries string
+	var requireResources bool
+	var defaultsNamespace string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&minReplicas, "min-replicas", 1, "The minimum replicas an Application may request.")
+	flag.IntVar(&maxReplicas, "max-replicas", 10, "The maximum replicas an Application may request.")
+	flag.StringVar(&allowedRegistries, "allowed-registries", "",
+		"Comma separated image registries Applications may use, e.g. docker.io,ghcr.io. Empty allows all registries.")
+	flag.BoolVar(&requireResources, "require-resources", true,
+		"Require CPU and memory requests and memory limits on every container of an Application.")
+	flag.StringVar(&defaultsNamespace, "defaults-namespace", "app-operator-system",
+		"The namespace whose ApplicationDefaults apply to Applications in every namespace.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -92,16 +105,34 @@ func main() {
 	}
 
 	if err = (&controllers.ApplicationReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Application")
 		os.Exit(1)
 	}
-	if err = (&appsv1.Application{}).SetupWebhookWithManager(mgr); err != nil {
+	validator := &appsv1.ApplicationValidator{
+		MinReplicas:      int32(minReplicas),
+		MaxReplicas:      int32(maxReplicas),
+		RequireResources: requireResources,
+	}
+	if allowedRegistries != "" {
+		validator.AllowedRegistries = strings.Split(allowedRegistries, ",")
+	}
+	defaulter := &appsv1.ApplicationDefaulter{
+		Reader:           mgr.GetAPIReader(),
+		ClusterNamespace: defaultsNamespace,
+	}
+	if err = (&appsv1.Application{}).SetupWebhookWithManager(mgr, validator, defaulter); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "Application")
 		os.Exit(1)
 	}
+	// v1 和 v2 之间的转换 webhook
+	if err = (&appsv2.Application{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create conversion webhook", "webhook", "Application")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
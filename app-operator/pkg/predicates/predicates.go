@@ -0,0 +1,146 @@
+// Package predicates 提供 Application 及其子资源的事件过滤条件
+//
+// 创建和删除事件总是通过,更新事件只有在 resourceVersion 变化,并且 generation
+// 或者按类型提取的字段(spec、status 等)发生变化时才通过。
+// 类型不匹配的对象不会 panic,只按 generation 比较。
+// ConfigMap 和 Secret 只缓存元数据,更新事件按 resourceVersion 判断。
+package predicates
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// FieldsFunc 提取对象中需要比较的字段,类型不匹配时返回 nil
+type FieldsFunc func(obj client.Object) interface{}
+
+// Changed 返回比较 generation 和 fields 提取的字段的过滤条件,fields 可以为空
+func Changed(fields FieldsFunc) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool {
+			return true
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			if e.ObjectNew.GetResourceVersion() == e.ObjectOld.GetResourceVersion() {
+				return false
+			}
+			if e.ObjectNew.GetGeneration() != e.ObjectOld.GetGeneration() {
+				return true
+			}
+			if fields == nil {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(fields(e.ObjectNew), fields(e.ObjectOld))
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// typed 把按具体类型提取字段的函数转换为 FieldsFunc
+func typed[T client.Object](f func(T) interface{}) FieldsFunc {
+	return func(obj client.Object) interface{} {
+		t, ok := obj.(T)
+		if !ok {
+			return nil
+		}
+		return f(t)
+	}
+}
+
+// AnnotationsChanged 在指定的注解发生变化时通过更新事件,用于 promote、rollback 等通过注解触发的操作
+func AnnotationsChanged(keys ...string) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool {
+			return false
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			newAnn, oldAnn := e.ObjectNew.GetAnnotations(), e.ObjectOld.GetAnnotations()
+			for _, key := range keys {
+				if newAnn[key] != oldAnn[key] {
+					return true
+				}
+			}
+			return false
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// Application 的 spec 变化会增加 generation,只需要比较 generation 和指定的注解
+func Application(annotations ...string) predicate.Predicate {
+	return predicate.Or(Changed(nil), AnnotationsChanged(annotations...))
+}
+
+// Deployment 比较 spec 和 status,副本就绪的变化需要同步到 Application 的状态中
+func Deployment() predicate.Predicate {
+	return Changed(typed(func(dp *appsv1.Deployment) interface{} {
+		return []interface{}{dp.Spec, dp.Status}
+	}))
+}
+
+// Service 比较 spec 和 status,Service 没有 generation
+func Service() predicate.Predicate {
+	return Changed(typed(func(svc *corev1.Service) interface{} {
+		return []interface{}{svc.Spec, svc.Status}
+	}))
+}
+
+// ConfigMap 和 Secret 只缓存元数据,没有数据可以比较,resourceVersion 变化时认为内容可能发生了变化
+func ConfigMap() predicate.Predicate {
+	return ResourceVersionChanged()
+}
+
+func Secret() predicate.Predicate {
+	return ResourceVersionChanged()
+}
+
+// ResourceVersionChanged 只过滤 resourceVersion 没有变化的周期性同步事件
+func ResourceVersionChanged() predicate.Funcs {
+	return Changed(func(obj client.Object) interface{} {
+		return obj.GetResourceVersion()
+	})
+}
+
+// HorizontalPodAutoscaler 比较 status,spec 的变化由 generation 判断
+func HorizontalPodAutoscaler() predicate.Predicate {
+	return Changed(typed(func(hpa *autoscalingv2.HorizontalPodAutoscaler) interface{} {
+		return hpa.Status
+	}))
+}
+
+// PodDisruptionBudget 比较 status,spec 的变化由 generation 判断
+func PodDisruptionBudget() predicate.Predicate {
+	return Changed(typed(func(pdb *policyv1.PodDisruptionBudget) interface{} {
+		return pdb.Status
+	}))
+}
+
+// Ingress 比较 status,spec 的变化由 generation 判断
+func Ingress() predicate.Predicate {
+	return Changed(typed(func(ing *networkingv1.Ingress) interface{} {
+		return ing.Status
+	}))
+}
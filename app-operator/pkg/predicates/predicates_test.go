@@ -0,0 +1,148 @@
+package predicates
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+func meta(rv string, generation int64, annotations map[string]string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: "demo", ResourceVersion: rv, Generation: generation, Annotations: annotations}
+}
+
+func TestCreateAndDeleteAlwaysPass(t *testing.T) {
+	obj := &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)}
+	p := Deployment()
+	if !p.Create(event.CreateEvent{Object: obj}) {
+		t.Error("create event should pass")
+	}
+	if !p.Delete(event.DeleteEvent{Object: obj}) {
+		t.Error("delete event should pass")
+	}
+	if p.Generic(event.GenericEvent{Object: obj}) {
+		t.Error("generic event should not pass")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	replicas := func(n int32) *int32 { return &n }
+	tests := []struct {
+		name     string
+		old, new client.Object
+		want     bool
+	}{
+		{
+			name: "deployment resync",
+			old:  &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)},
+			new:  &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)},
+			want: false,
+		},
+		{
+			name: "deployment generation changed",
+			old:  &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)},
+			new:  &appsv1.Deployment{ObjectMeta: meta("2", 2, nil)},
+			want: true,
+		},
+		{
+			name: "deployment status changed",
+			old:  &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)},
+			new: &appsv1.Deployment{ObjectMeta: meta("2", 1, nil),
+				Status: appsv1.DeploymentStatus{ReadyReplicas: 1}},
+			want: true,
+		},
+		{
+			name: "deployment metadata only",
+			old:  &appsv1.Deployment{ObjectMeta: meta("1", 1, nil), Spec: appsv1.DeploymentSpec{Replicas: replicas(2)}},
+			new: &appsv1.Deployment{ObjectMeta: meta("2", 1, map[string]string{"a": "b"}),
+				Spec: appsv1.DeploymentSpec{Replicas: replicas(2)}},
+			want: false,
+		},
+		{
+			name: "service spec changed",
+			old:  &corev1.Service{ObjectMeta: meta("1", 0, nil)},
+			new: &corev1.Service{ObjectMeta: meta("2", 0, nil),
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort}},
+			want: true,
+		},
+		{
+			name: "service metadata only",
+			old:  &corev1.Service{ObjectMeta: meta("1", 0, nil)},
+			new:  &corev1.Service{ObjectMeta: meta("2", 0, map[string]string{"a": "b"})},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+			switch tt.new.(type) {
+			case *appsv1.Deployment:
+				got = Deployment().Update(event.UpdateEvent{ObjectOld: tt.old, ObjectNew: tt.new})
+			case *corev1.Service:
+				got = Service().Update(event.UpdateEvent{ObjectOld: tt.old, ObjectNew: tt.new})
+			}
+			if got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 类型不匹配的对象不能 panic,只按 generation 比较
+func TestUpdateWrongType(t *testing.T) {
+	old := &corev1.ConfigMap{ObjectMeta: meta("1", 0, nil)}
+	new := &corev1.ConfigMap{ObjectMeta: meta("2", 0, nil), Data: map[string]string{"a": "b"}}
+	if Service().Update(event.UpdateEvent{ObjectOld: old, ObjectNew: new}) {
+		t.Error("update of an unexpected type should not pass")
+	}
+	if !ConfigMap().Update(event.UpdateEvent{ObjectOld: old, ObjectNew: new}) {
+		t.Error("ConfigMap data change should pass")
+	}
+}
+
+// 只缓存元数据的对象按 resourceVersion 判断
+func TestMetadataOnly(t *testing.T) {
+	object := func(rv string) *metav1.PartialObjectMetadata {
+		return &metav1.PartialObjectMetadata{ObjectMeta: meta(rv, 0, nil)}
+	}
+	for _, p := range []predicate.Predicate{ConfigMap(), Secret()} {
+		if !p.Update(event.UpdateEvent{ObjectOld: object("1"), ObjectNew: object("2")}) {
+			t.Error("resourceVersion change should pass")
+		}
+		if p.Update(event.UpdateEvent{ObjectOld: object("1"), ObjectNew: object("1")}) {
+			t.Error("resync should not pass")
+		}
+	}
+}
+
+func TestApplication(t *testing.T) {
+	const promote = "apps.costalong.com/promote"
+	p := Application(promote)
+
+	if !p.Update(event.UpdateEvent{
+		ObjectOld: &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)},
+		ObjectNew: &appsv1.Deployment{ObjectMeta: meta("2", 1, map[string]string{promote: "true"})},
+	}) {
+		t.Error("watched annotation change should pass")
+	}
+	if p.Update(event.UpdateEvent{
+		ObjectOld: &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)},
+		ObjectNew: &appsv1.Deployment{ObjectMeta: meta("2", 1, map[string]string{"other": "x"})},
+	}) {
+		t.Error("other annotation change should not pass")
+	}
+	if !p.Update(event.UpdateEvent{
+		ObjectOld: &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)},
+		ObjectNew: &appsv1.Deployment{ObjectMeta: meta("2", 2, nil)},
+	}) {
+		t.Error("generation change should pass")
+	}
+	if !p.Delete(event.DeleteEvent{Object: &appsv1.Deployment{ObjectMeta: meta("1", 1, nil)}}) {
+		t.Error("delete event should pass")
+	}
+}
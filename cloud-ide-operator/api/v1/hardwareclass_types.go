@@ -0,0 +1,59 @@
+/*
+Copyright 2023 Costalong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HardwareClassSpec defines the desired state of HardwareClass
+type HardwareClassSpec struct {
+	// 使用该硬件规格的工作空间的调度约束
+	WorkSpaceScheduling `json:",inline"`
+}
+
+// HardwareClassStatus defines the observed state of HardwareClass
+type HardwareClassStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:subresource:status
+
+// HardwareClass is the Schema for the hardwareclasses API
+// HardwareClass 的名字对应工作空间的 Hardware 字段,例如 2C4G10G,
+// 平台管理员通过它把不同规格的工作空间调度到不同的节点上
+type HardwareClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HardwareClassSpec   `json:"spec,omitempty"`
+	Status HardwareClassStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HardwareClassList contains a list of HardwareClass
+type HardwareClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HardwareClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HardwareClass{}, &HardwareClassList{})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -37,6 +38,21 @@ const (
 	WorkspacePhaseStopped WorkSpacePhase = "Stopped"
 )
 
+// SecurityProfile 工作空间 Pod 的安全配置档,创建 Pod 前只和命名空间的 Pod Security Admission 级别比较,
+// 不会校验其它准入策略
+// +kubebuilder:validation:Enum=restricted;baseline;privileged-for-docker
+type SecurityProfile string
+
+const (
+	// SecurityProfileRestricted 非 root 运行,丢弃所有 capabilities,根文件系统只读,镜像需要支持以 1000 用户运行
+	SecurityProfileRestricted SecurityProfile = "restricted"
+	// SecurityProfileBaseline 非 root 运行,根文件系统可写
+	SecurityProfileBaseline SecurityProfile = "baseline"
+	// SecurityProfilePrivilegedForDocker 工作空间容器以特权运行,例如直接在工作空间容器中运行 Docker 守护进程,
+	// 使用 docker sidecar 时不需要
+	SecurityProfilePrivilegedForDocker SecurityProfile = "privileged-for-docker"
+)
+
 // WorkSpaceSpec defines the desired state of WorkSpace
 type WorkSpaceSpec struct {
 	// 表示该工作空间使用的cpu、内存和存储的规格
@@ -54,11 +70,201 @@ type WorkSpaceSpec struct {
 	MountPath string `json:"mountPath"`
 	// 要进行的操作，用于启动或者停止工作空间
 	Operation WorkSpaceOperation `json:"operation,omitempty"`
+	// Pod 的安全配置档,为空时使用模板中的配置,都没有设置时不设置安全上下文,使用镜像中的用户运行
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+	// 工作空间所属的用户,用于按用户统计使用量
+	User string `json:"user,omitempty"`
+	// 工作空间使用的模板(WorkSpaceTemplate)名字,未设置的字段使用模板中的值
+	Template string `json:"template,omitempty"`
+	// 存储卷的配置
+	WorkSpaceVolumes `json:",inline"`
+	// 克隆的来源工作空间,第一次创建 PVC 时复制来源工作空间的存储卷,
+	// 来源工作空间必须和当前工作空间在同一个命名空间下,并且存储类支持 PVC 克隆
+	SourceWorkspace string `json:"sourceWorkspace,omitempty"`
+	// 从 VolumeSnapshot 创建存储卷,同时设置时优先于 SourceWorkspace
+	SourceSnapshot string `json:"sourceSnapshot,omitempty"`
+	// 从对象存储中的备份恢复,只在第一次创建 PVC 时生效
+	RestoreFrom *BackupRestore `json:"restoreFrom,omitempty"`
+	// 调度约束,会和 Hardware 对应的 HardwareClass 合并,工作空间中的设置优先
+	WorkSpaceScheduling `json:",inline"`
+	// 容器的生命周期钩子
+	Lifecycle *WorkSpaceLifecycle `json:"lifecycle,omitempty"`
+
+	// 工作空间中运行的应用对外暴露的端口,会生成预览地址
+	//+listType=map
+	//+listMapKey=port
+	Ports []ExposedPort `json:"ports,omitempty"`
+
+	// 和工作空间一起运行的伴随服务,例如数据库、缓存,在 Pod 中以 sidecar 的形式运行,通过 localhost 或者服务的名字访问
+	//+listType=map
+	//+listMapKey=name
+	Companions []Companion `json:"companions,omitempty"`
+
+	// 在工作空间中构建容器,设置后会以 sidecar 的形式运行 rootless 的 Docker 或者 BuildKit
+	Docker *WorkSpaceDocker `json:"docker,omitempty"`
+
+	// 注入到容器中的环境变量
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// 从 Secret 或 ConfigMap 中批量注入环境变量
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+	// 以文件形式挂载到容器中的 Secret,例如 SSH 密钥、访问凭证
+	SecretMounts []SecretMount `json:"secretMounts,omitempty"`
+	// 用户的 dotfiles,在容器启动前放入存储卷中
+	Dotfiles *Dotfiles `json:"dotfiles,omitempty"`
+}
+
+// WorkSpaceVolumes 描述工作空间的存储卷,工作空间和模板中都可以设置
+type WorkSpaceVolumes struct {
+	// PVC 使用的存储类,为空时使用集群默认的存储类
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// PVC 的访问模式,为空时使用 ReadWriteOnce
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	// PVC 的卷模式,为 Block 时以块设备的形式提供给容器,设备路径为 mountPath
+	VolumeMode *corev1.PersistentVolumeMode `json:"volumeMode,omitempty"`
+	// 额外挂载的只读共享卷,例如数据集、缓存
+	SharedVolumes []SharedVolume `json:"sharedVolumes,omitempty"`
+	// 使用临时存储,不创建 PVC,工作空间停止后数据会丢失
+	// 工作空间中没有设置时使用模板中的值
+	Ephemeral *bool `json:"ephemeral,omitempty"`
+}
+
+// BackupRestore 指定用于恢复的备份
+type BackupRestore struct {
+	// 备份所属的 WorkSpaceBackupPolicy,使用其中的对象存储配置
+	Policy string `json:"policy"`
+	// 备份所属的工作空间
+	Workspace string `json:"workspace"`
+	// 备份的名字,对应策略状态中的 backups
+	Backup string `json:"backup"`
+}
+
+// WorkSpaceScheduling 描述工作空间 Pod 的调度约束,工作空间和 HardwareClass 中都可以设置
+type WorkSpaceScheduling struct {
+	NodeSelector              map[string]string                 `json:"nodeSelector,omitempty"`
+	Affinity                  *corev1.Affinity                  `json:"affinity,omitempty"`
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	PriorityClassName         string                            `json:"priorityClassName,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
+// ExposedPort 工作空间中应用监听的端口
+type ExposedPort struct {
+	// 端口的名字,同时作为 Service 端口的名字。为空或者和其它端口重名时使用 port-{port}
+	//+kubebuilder:validation:Pattern=`^[a-z]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:MaxLength=15
+	//+optional
+	Name string `json:"name,omitempty"`
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+	// 为 true 时任何人都可以访问预览地址,否则需要经过 IDE 网关的认证
+	Public bool `json:"public,omitempty"`
+}
+
+// PreviewURL 暴露端口的预览地址
+type PreviewURL struct {
+	Name   string `json:"name"`
+	Port   int32  `json:"port"`
+	Public bool   `json:"public,omitempty"`
+	URL    string `json:"url"`
+}
+
+// Companion 描述和工作空间一起启动和停止的伴随服务
+type Companion struct {
+	// 服务的名字,同时作为容器的名字
+	//+kubebuilder:validation:Pattern=`^[a-z]([-a-z0-9]*[a-z0-9])?$`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// 服务启动的命令和参数,为空时使用镜像中的设置
+	Command []string        `json:"command,omitempty"`
+	Args    []string        `json:"args,omitempty"`
+	Env     []corev1.EnvVar `json:"env,omitempty"`
+	// 服务监听的端口,工作空间中通过 localhost 访问
+	Ports []int32 `json:"ports,omitempty"`
+	// 服务数据的挂载位置,数据保存在工作空间存储卷的 .companions/{name} 目录中
+	DataPath string `json:"dataPath,omitempty"`
+	// 服务使用的cpu和内存的上限
+	Cpu    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	// 服务容器的安全上下文,工作空间的安全配置档不会作用到伴随服务的容器上。
+	// 为空时使用镜像中的用户运行,并按照安全配置档丢弃 capabilities;restricted 时镜像的用户必须是非 root 用户。
+	// 检查命名空间的 Pod Security Admission 级别时包括伴随服务的容器
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+}
+
+// DockerEngine 工作空间中构建容器使用的引擎
+// +kubebuilder:validation:Enum=docker;buildkit
+type DockerEngine string
+
+const (
+	DockerEngineDocker   DockerEngine = "docker"
+	DockerEngineBuildKit DockerEngine = "buildkit"
+)
+
+// WorkSpaceDocker 描述工作空间中的 rootless Docker 或者 BuildKit sidecar
+// 只有 sidecar 以特权运行,工作空间容器仍然使用工作空间的安全配置档,命名空间需要允许 privileged 级别
+type WorkSpaceDocker struct {
+	// 使用的引擎,默认为 docker
+	Engine DockerEngine `json:"engine,omitempty"`
+	// sidecar 使用的镜像,为空时使用引擎对应的默认镜像
+	Image string `json:"image,omitempty"`
+	// 镜像和构建缓存的存储大小,缓存使用单独的 PVC,默认为 20Gi
+	CacheStorage string `json:"cacheStorage,omitempty"`
+	// sidecar 使用的cpu和内存的上限
+	Cpu    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+}
+
+// WorkSpaceLifecycle 描述用户自定义的生命周期命令,每一项是一条 shell 命令,按顺序执行,任意一条失败即视为钩子失败。
+// 钩子失败不会重启容器,结果记录在工作空间的 PostStartHook 和 PreStopHook 状态条件中。
+// postStart 失败时就绪探针失败一次并记录钩子的输出,之后容器照常就绪,失败的结果保留到下一次启动
+type WorkSpaceLifecycle struct {
+	// 容器启动后执行的命令,例如安装工具、启动语言服务器
+	PostStart []string `json:"postStart,omitempty"`
+	// 容器停止前执行的命令,例如清理缓存、推送未提交的分支
+	PreStop []string `json:"preStop,omitempty"`
+}
+
+// SharedVolume 描述一个以只读方式挂载到工作空间中的已有 PVC
+type SharedVolume struct {
+	// 卷的名字,在工作空间中唯一
+	Name string `json:"name"`
+	// 已有的 PVC 的名字,必须和工作空间在同一个命名空间下
+	ClaimName string `json:"claimName"`
+	// 挂载位置
+	MountPath string `json:"mountPath"`
+	// 只挂载 PVC 中的子目录
+	SubPath string `json:"subPath,omitempty"`
+}
+
+// SecretMount 描述一个以文件形式挂载到容器中的 Secret
+type SecretMount struct {
+	// Secret 的名字,必须和工作空间在同一个命名空间下
+	SecretName string `json:"secretName"`
+	// 挂载位置,例如 /root/.ssh
+	MountPath string `json:"mountPath"`
+}
+
+// Dotfiles 描述 dotfiles 的来源,Repository 和 ConfigMap 二选一,同时设置时优先使用 Repository
+type Dotfiles struct {
+	// dotfiles 所在的 git 仓库地址
+	Repository string `json:"repository,omitempty"`
+	// 保存 dotfiles 的 ConfigMap,每个 key 对应一个文件
+	ConfigMap string `json:"configMap,omitempty"`
 }
 
 // WorkSpaceStatus defines the observed state of WorkSpace
 type WorkSpaceStatus struct {
 	Phase WorkSpacePhase `json:"phase,omitempty"`
+	// 工作空间使用的 Pod 和 PVC 的名字,从预热池中领取时与工作空间的名字不同,为空时使用工作空间的名字
+	PodName   string `json:"podName,omitempty"`
+	ClaimName string `json:"claimName,omitempty"`
+	// 暴露端口的预览地址
+	Previews []PreviewURL `json:"previews,omitempty"`
+	// 工作空间的状态条件,例如生命周期钩子是否执行成功
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,108 @@
+/*
+Copyright 2023 Costalong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupStorage 描述保存备份的 S3 兼容对象存储,例如 MinIO
+type BackupStorage struct {
+	// 对象存储的地址,例如 http://minio.minio.svc:9000
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	// 备份对象名字的前缀,备份保存在 {prefix}{workspace}/{backup}.tar.gz
+	Prefix string `json:"prefix,omitempty"`
+	// 访问对象存储的凭证所在的 Secret,包含 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// WorkSpaceBackupPolicySpec defines the desired state of WorkSpaceBackupPolicy
+type WorkSpaceBackupPolicySpec struct {
+	// 选择需要备份的工作空间,为空时备份命名空间中所有的工作空间
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// 两次备份之间的间隔,例如 24h,不能小于 5m
+	//+kubebuilder:validation:XValidation:rule="duration(self) >= duration('5m')",message="interval must be at least 5m"
+	Interval metav1.Duration `json:"interval"`
+	// 每个工作空间保留的备份数量,更早的备份会被删除
+	//+kubebuilder:validation:Minimum=1
+	Retention int32 `json:"retention"`
+	// 保存备份的对象存储
+	Storage BackupStorage `json:"storage"`
+	// 执行备份和恢复的镜像,需要包含 sh、tar 和 mc,为空时使用 minio/mc
+	Image string `json:"image,omitempty"`
+}
+
+// BackupPhase 工作空间最近一次备份的状态
+type BackupPhase string
+
+const (
+	BackupPhaseRunning   BackupPhase = "Running"
+	BackupPhaseSucceeded BackupPhase = "Succeeded"
+	BackupPhaseFailed    BackupPhase = "Failed"
+)
+
+// WorkSpaceBackupStatus 一个工作空间的备份状态
+type WorkSpaceBackupStatus struct {
+	Workspace string `json:"workspace"`
+	// 最近一次备份的状态和开始时间
+	Phase          BackupPhase  `json:"phase,omitempty"`
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+	// 最近一次成功的备份的完成时间
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+	// 正在执行的备份 Job
+	ActiveJob string `json:"activeJob,omitempty"`
+	// 对象存储中保留的备份,按时间从旧到新排列
+	Backups []string `json:"backups,omitempty"`
+}
+
+// WorkSpaceBackupPolicyStatus defines the observed state of WorkSpaceBackupPolicy
+type WorkSpaceBackupPolicyStatus struct {
+	// 每个工作空间的备份状态
+	//+listType=map
+	//+listMapKey=workspace
+	Workspaces []WorkSpaceBackupStatus `json:"workspaces,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Interval",type=string,JSONPath=`.spec.interval`
+//+kubebuilder:printcolumn:name="Retention",type=integer,JSONPath=`.spec.retention`
+//+kubebuilder:printcolumn:name="Bucket",type=string,JSONPath=`.spec.storage.bucket`
+
+// WorkSpaceBackupPolicy is the Schema for the workspacebackuppolicies API
+// 定期把工作空间存储卷中的内容打包上传到对象存储,工作空间可以通过 restoreFrom 从备份中恢复
+type WorkSpaceBackupPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WorkSpaceBackupPolicySpec   `json:"spec,omitempty"`
+	Status WorkSpaceBackupPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WorkSpaceBackupPolicyList contains a list of WorkSpaceBackupPolicy
+type WorkSpaceBackupPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WorkSpaceBackupPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WorkSpaceBackupPolicy{}, &WorkSpaceBackupPolicyList{})
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 Costalong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WorkSpaceTemplateSpec defines the desired state of WorkSpaceTemplate
+type WorkSpaceTemplateSpec struct {
+	// 工作空间默认使用的cpu、内存和存储的规格
+	Cpu     string `json:"cpu,omitempty"`
+	Memory  string `json:"memory,omitempty"`
+	Storage string `json:"storage,omitempty"`
+	// 工作空间默认的硬件描述
+	Hardware string `json:"hardware,omitempty"`
+	// 工作空间默认使用的镜像
+	Image string `json:"image,omitempty"`
+	// code-server 默认监听的端口
+	Port int32 `json:"port,omitempty"`
+	// 存储卷默认的挂载位置
+	MountPath string `json:"mountPath,omitempty"`
+	// 存储卷默认的配置
+	WorkSpaceVolumes `json:",inline"`
+
+	// 工作空间默认的安全配置档
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+
+	// 工作空间默认的伴随服务
+	Companions []Companion `json:"companions,omitempty"`
+
+	// 是否在节点上预先拉取模板的镜像,以减少工作空间冷启动的时间
+	PrePull bool `json:"prePull,omitempty"`
+	// 工作空间允许访问的出口,DNS 总是允许访问,其余的出口流量都会被 NetworkPolicy 拒绝。
+	// 开启网络隔离时需要包含 dotfiles 仓库、扩展市场等工作空间依赖的地址
+	EgressAllowList []networkingv1.NetworkPolicyEgressRule `json:"egressAllowList,omitempty"`
+	// 预先启动的空闲工作空间数量,创建工作空间时直接从池中领取,为 0 时不使用预热池
+	//+kubebuilder:validation:Minimum=0
+	PoolSize int32 `json:"poolSize,omitempty"`
+}
+
+// NodePrePullStatus 表示模板镜像在某个节点上的预拉取状态
+type NodePrePullStatus struct {
+	NodeName string `json:"nodeName"`
+	// 镜像是否已经拉取到该节点上
+	Ready bool `json:"ready"`
+}
+
+// WorkSpaceTemplateStatus defines the observed state of WorkSpaceTemplate
+type WorkSpaceTemplateStatus struct {
+	// 每个预拉取节点上的镜像状态
+	PrePullNodes []NodePrePullStatus `json:"prePullNodes,omitempty"`
+	// 已经拉取好镜像的节点数量
+	PrePullReadyNodes int32 `json:"prePullReadyNodes,omitempty"`
+	// 预热池中已经就绪、可以被领取的工作空间数量
+	PoolReady int32 `json:"poolReady,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`
+//+kubebuilder:printcolumn:name="Warm Nodes",type=integer,JSONPath=`.status.prePullReadyNodes`
+//+kubebuilder:printcolumn:name="Pool",type=integer,JSONPath=`.spec.poolSize`
+//+kubebuilder:printcolumn:name="Pool Ready",type=integer,JSONPath=`.status.poolReady`
+
+// WorkSpaceTemplate is the Schema for the workspacetemplates API
+type WorkSpaceTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WorkSpaceTemplateSpec   `json:"spec,omitempty"`
+	Status WorkSpaceTemplateStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WorkSpaceTemplateList contains a list of WorkSpaceTemplate
+type WorkSpaceTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WorkSpaceTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WorkSpaceTemplate{}, &WorkSpaceTemplateList{})
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Costalong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// WorkSpaceUsageSpec defines the desired state of WorkSpaceUsage
+type WorkSpaceUsageSpec struct {
+	// 工作空间的名字
+	Workspace string `json:"workspace"`
+	// 工作空间的 UID,同名的工作空间删除后重新创建时使用新的 WorkSpaceUsage
+	WorkspaceUID types.UID `json:"workspaceUID,omitempty"`
+	// 工作空间所属的用户
+	User string `json:"user,omitempty"`
+}
+
+// RunningInterval 工作空间的一次运行,记录运行时使用的规格
+type RunningInterval struct {
+	Start metav1.Time `json:"start"`
+	// 为空表示工作空间仍在运行
+	End      *metav1.Time `json:"end,omitempty"`
+	Hardware string       `json:"hardware,omitempty"`
+	Cpu      string       `json:"cpu,omitempty"`
+	Memory   string       `json:"memory,omitempty"`
+}
+
+// StorageInterval 工作空间存储卷存在的区间,工作空间停止时存储卷仍然占用存储
+type StorageInterval struct {
+	Start metav1.Time `json:"start"`
+	// 为空表示存储卷仍然存在
+	End     *metav1.Time `json:"end,omitempty"`
+	Storage string       `json:"storage,omitempty"`
+}
+
+// WorkSpaceUsageStatus defines the observed state of WorkSpaceUsage
+// 每种区间最多保留 1000 个,超出时删除最早结束的区间
+type WorkSpaceUsageStatus struct {
+	// 工作空间每次运行的区间
+	Running []RunningInterval `json:"running,omitempty"`
+	// 工作空间存储卷存在的区间
+	Storage []StorageInterval `json:"storage,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Workspace",type=string,JSONPath=`.spec.workspace`
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.user`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WorkSpaceUsage is the Schema for the workspaceusages API
+// 记录工作空间的运行和存储区间,名字是工作空间的名字加上 UID,用于按工作空间、用户和命名空间统计费用,工作空间删除后仍然保留
+type WorkSpaceUsage struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WorkSpaceUsageSpec   `json:"spec,omitempty"`
+	Status WorkSpaceUsageStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WorkSpaceUsageList contains a list of WorkSpaceUsage
+type WorkSpaceUsageList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WorkSpaceUsage `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WorkSpaceUsage{}, &WorkSpaceUsageList{})
+}
@@ -22,34 +22,500 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupRestore) DeepCopyInto(out *BackupRestore) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupRestore.
+func (in *BackupRestore) DeepCopy() *BackupRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupStorage) DeepCopyInto(out *BackupStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupStorage.
+func (in *BackupStorage) DeepCopy() *BackupStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Companion) DeepCopyInto(out *Companion) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]corev1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		*out = new(corev1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Companion.
+func (in *Companion) DeepCopy() *Companion {
+	if in == nil {
+		return nil
+	}
+	out := new(Companion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Dotfiles) DeepCopyInto(out *Dotfiles) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Dotfiles.
+func (in *Dotfiles) DeepCopy() *Dotfiles {
+	if in == nil {
+		return nil
+	}
+	out := new(Dotfiles)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExposedPort) DeepCopyInto(out *ExposedPort) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExposedPort.
+func (in *ExposedPort) DeepCopy() *ExposedPort {
+	if in == nil {
+		return nil
+	}
+	out := new(ExposedPort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HardwareClass) DeepCopyInto(out *HardwareClass) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HardwareClass.
+func (in *HardwareClass) DeepCopy() *HardwareClass {
+	if in == nil {
+		return nil
+	}
+	out := new(HardwareClass)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HardwareClass) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HardwareClassList) DeepCopyInto(out *HardwareClassList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]HardwareClass, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HardwareClassList.
+func (in *HardwareClassList) DeepCopy() *HardwareClassList {
+	if in == nil {
+		return nil
+	}
+	out := new(HardwareClassList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HardwareClassList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HardwareClassSpec) DeepCopyInto(out *HardwareClassSpec) {
+	*out = *in
+	in.WorkSpaceScheduling.DeepCopyInto(&out.WorkSpaceScheduling)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HardwareClassSpec.
+func (in *HardwareClassSpec) DeepCopy() *HardwareClassSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HardwareClassSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HardwareClassStatus) DeepCopyInto(out *HardwareClassStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HardwareClassStatus.
+func (in *HardwareClassStatus) DeepCopy() *HardwareClassStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HardwareClassStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePrePullStatus) DeepCopyInto(out *NodePrePullStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePrePullStatus.
+func (in *NodePrePullStatus) DeepCopy() *NodePrePullStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePrePullStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreviewURL) DeepCopyInto(out *PreviewURL) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviewURL.
+func (in *PreviewURL) DeepCopy() *PreviewURL {
+	if in == nil {
+		return nil
+	}
+	out := new(PreviewURL)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RunningInterval) DeepCopyInto(out *RunningInterval) {
+	*out = *in
+	in.Start.DeepCopyInto(&out.Start)
+	if in.End != nil {
+		in, out := &in.End, &out.End
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RunningInterval.
+func (in *RunningInterval) DeepCopy() *RunningInterval {
+	if in == nil {
+		return nil
+	}
+	out := new(RunningInterval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretMount) DeepCopyInto(out *SecretMount) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretMount.
+func (in *SecretMount) DeepCopy() *SecretMount {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretMount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SharedVolume) DeepCopyInto(out *SharedVolume) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SharedVolume.
+func (in *SharedVolume) DeepCopy() *SharedVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(SharedVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageInterval) DeepCopyInto(out *StorageInterval) {
+	*out = *in
+	in.Start.DeepCopyInto(&out.Start)
+	if in.End != nil {
+		in, out := &in.End, &out.End
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageInterval.
+func (in *StorageInterval) DeepCopy() *StorageInterval {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageInterval)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WorkSpace) DeepCopyInto(out *WorkSpace) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpace.
+func (in *WorkSpace) DeepCopy() *WorkSpace {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpace)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WorkSpace) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceBackupPolicy) DeepCopyInto(out *WorkSpaceBackupPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceBackupPolicy.
+func (in *WorkSpaceBackupPolicy) DeepCopy() *WorkSpaceBackupPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceBackupPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WorkSpaceBackupPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceBackupPolicyList) DeepCopyInto(out *WorkSpaceBackupPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WorkSpaceBackupPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceBackupPolicyList.
+func (in *WorkSpaceBackupPolicyList) DeepCopy() *WorkSpaceBackupPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceBackupPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WorkSpaceBackupPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceBackupPolicySpec) DeepCopyInto(out *WorkSpaceBackupPolicySpec) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Interval = in.Interval
+	out.Storage = in.Storage
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceBackupPolicySpec.
+func (in *WorkSpaceBackupPolicySpec) DeepCopy() *WorkSpaceBackupPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceBackupPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceBackupPolicyStatus) DeepCopyInto(out *WorkSpaceBackupPolicyStatus) {
+	*out = *in
+	if in.Workspaces != nil {
+		in, out := &in.Workspaces, &out.Workspaces
+		*out = make([]WorkSpaceBackupStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceBackupPolicyStatus.
+func (in *WorkSpaceBackupPolicyStatus) DeepCopy() *WorkSpaceBackupPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceBackupPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceBackupStatus) DeepCopyInto(out *WorkSpaceBackupStatus) {
+	*out = *in
+	if in.LastBackupTime != nil {
+		in, out := &in.LastBackupTime, &out.LastBackupTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulTime != nil {
+		in, out := &in.LastSuccessfulTime, &out.LastSuccessfulTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Backups != nil {
+		in, out := &in.Backups, &out.Backups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpace.
-func (in *WorkSpace) DeepCopy() *WorkSpace {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceBackupStatus.
+func (in *WorkSpaceBackupStatus) DeepCopy() *WorkSpaceBackupStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(WorkSpace)
+	out := new(WorkSpaceBackupStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *WorkSpace) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceDocker) DeepCopyInto(out *WorkSpaceDocker) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceDocker.
+func (in *WorkSpaceDocker) DeepCopy() *WorkSpaceDocker {
+	if in == nil {
+		return nil
 	}
-	return nil
+	out := new(WorkSpaceDocker)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceLifecycle) DeepCopyInto(out *WorkSpaceLifecycle) {
+	*out = *in
+	if in.PostStart != nil {
+		in, out := &in.PostStart, &out.PostStart
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PreStop != nil {
+		in, out := &in.PreStop, &out.PreStop
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceLifecycle.
+func (in *WorkSpaceLifecycle) DeepCopy() *WorkSpaceLifecycle {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceLifecycle)
+	in.DeepCopyInto(out)
+	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -84,9 +550,103 @@ func (in *WorkSpaceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceScheduling) DeepCopyInto(out *WorkSpaceScheduling) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]corev1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceScheduling.
+func (in *WorkSpaceScheduling) DeepCopy() *WorkSpaceScheduling {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceScheduling)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WorkSpaceSpec) DeepCopyInto(out *WorkSpaceSpec) {
 	*out = *in
+	in.WorkSpaceVolumes.DeepCopyInto(&out.WorkSpaceVolumes)
+	if in.RestoreFrom != nil {
+		in, out := &in.RestoreFrom, &out.RestoreFrom
+		*out = new(BackupRestore)
+		**out = **in
+	}
+	in.WorkSpaceScheduling.DeepCopyInto(&out.WorkSpaceScheduling)
+	if in.Lifecycle != nil {
+		in, out := &in.Lifecycle, &out.Lifecycle
+		*out = new(WorkSpaceLifecycle)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]ExposedPort, len(*in))
+		copy(*out, *in)
+	}
+	if in.Companions != nil {
+		in, out := &in.Companions, &out.Companions
+		*out = make([]Companion, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Docker != nil {
+		in, out := &in.Docker, &out.Docker
+		*out = new(WorkSpaceDocker)
+		**out = **in
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]corev1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EnvFrom != nil {
+		in, out := &in.EnvFrom, &out.EnvFrom
+		*out = make([]corev1.EnvFromSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SecretMounts != nil {
+		in, out := &in.SecretMounts, &out.SecretMounts
+		*out = make([]SecretMount, len(*in))
+		copy(*out, *in)
+	}
+	if in.Dotfiles != nil {
+		in, out := &in.Dotfiles, &out.Dotfiles
+		*out = new(Dotfiles)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceSpec.
@@ -102,6 +662,18 @@ func (in *WorkSpaceSpec) DeepCopy() *WorkSpaceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WorkSpaceStatus) DeepCopyInto(out *WorkSpaceStatus) {
 	*out = *in
+	if in.Previews != nil {
+		in, out := &in.Previews, &out.Previews
+		*out = make([]PreviewURL, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceStatus.
@@ -113,3 +685,255 @@ func (in *WorkSpaceStatus) DeepCopy() *WorkSpaceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceTemplate) DeepCopyInto(out *WorkSpaceTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceTemplate.
+func (in *WorkSpaceTemplate) DeepCopy() *WorkSpaceTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WorkSpaceTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceTemplateList) DeepCopyInto(out *WorkSpaceTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WorkSpaceTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceTemplateList.
+func (in *WorkSpaceTemplateList) DeepCopy() *WorkSpaceTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WorkSpaceTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceTemplateSpec) DeepCopyInto(out *WorkSpaceTemplateSpec) {
+	*out = *in
+	in.WorkSpaceVolumes.DeepCopyInto(&out.WorkSpaceVolumes)
+	if in.Companions != nil {
+		in, out := &in.Companions, &out.Companions
+		*out = make([]Companion, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EgressAllowList != nil {
+		in, out := &in.EgressAllowList, &out.EgressAllowList
+		*out = make([]networkingv1.NetworkPolicyEgressRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceTemplateSpec.
+func (in *WorkSpaceTemplateSpec) DeepCopy() *WorkSpaceTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceTemplateStatus) DeepCopyInto(out *WorkSpaceTemplateStatus) {
+	*out = *in
+	if in.PrePullNodes != nil {
+		in, out := &in.PrePullNodes, &out.PrePullNodes
+		*out = make([]NodePrePullStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceTemplateStatus.
+func (in *WorkSpaceTemplateStatus) DeepCopy() *WorkSpaceTemplateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceTemplateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceUsage) DeepCopyInto(out *WorkSpaceUsage) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceUsage.
+func (in *WorkSpaceUsage) DeepCopy() *WorkSpaceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WorkSpaceUsage) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceUsageList) DeepCopyInto(out *WorkSpaceUsageList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WorkSpaceUsage, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceUsageList.
+func (in *WorkSpaceUsageList) DeepCopy() *WorkSpaceUsageList {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceUsageList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WorkSpaceUsageList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceUsageSpec) DeepCopyInto(out *WorkSpaceUsageSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceUsageSpec.
+func (in *WorkSpaceUsageSpec) DeepCopy() *WorkSpaceUsageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceUsageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceUsageStatus) DeepCopyInto(out *WorkSpaceUsageStatus) {
+	*out = *in
+	if in.Running != nil {
+		in, out := &in.Running, &out.Running
+		*out = make([]RunningInterval, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = make([]StorageInterval, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceUsageStatus.
+func (in *WorkSpaceUsageStatus) DeepCopy() *WorkSpaceUsageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceUsageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkSpaceVolumes) DeepCopyInto(out *WorkSpaceVolumes) {
+	*out = *in
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.AccessModes != nil {
+		in, out := &in.AccessModes, &out.AccessModes
+		*out = make([]corev1.PersistentVolumeAccessMode, len(*in))
+		copy(*out, *in)
+	}
+	if in.VolumeMode != nil {
+		in, out := &in.VolumeMode, &out.VolumeMode
+		*out = new(corev1.PersistentVolumeMode)
+		**out = **in
+	}
+	if in.SharedVolumes != nil {
+		in, out := &in.SharedVolumes, &out.SharedVolumes
+		*out = make([]SharedVolume, len(*in))
+		copy(*out, *in)
+	}
+	if in.Ephemeral != nil {
+		in, out := &in.Ephemeral, &out.Ephemeral
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkSpaceVolumes.
+func (in *WorkSpaceVolumes) DeepCopy() *WorkSpaceVolumes {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkSpaceVolumes)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// BackupPolicyLabel 备份 Job 上记录所属 WorkSpaceBackupPolicy 的标签
+	BackupPolicyLabel = "apps.costalong.com/backup-policy"
+	// 备份 Job 上记录备份名字和需要删除的旧备份的注解
+	backupNameAnnotation  = "apps.costalong.com/backup"
+	backupPruneAnnotation = "apps.costalong.com/backup-prune"
+
+	defaultBackupImage = "minio/mc:RELEASE.2023-10-30T18-43-32Z"
+	backupMountPath    = "/workspace"
+	// 备份的名字使用开始时间,按名字排序即按时间排序
+	backupNameLayout = "20060102-150405"
+	// 两次备份之间的最小间隔,和 CRD 中 interval 的校验规则一致
+	minBackupInterval = 5 * time.Minute
+)
+
+// 管道的退出码是 mc 的退出码,镜像中的 sh 不一定支持 pipefail,因此把 tar 的退出码写到文件中检查。
+// 打包失败时删除上传的不完整的备份,不删除旧的备份
+const backupScript = `set -e
+mc alias set backup "$S3_ENDPOINT" "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY"
+status="${TMPDIR:-/tmp}/tar.status"
+{ tar czf - -C "$WORKSPACE_PATH" . && echo 0 >"$status"; } | mc pipe "backup/$S3_BUCKET/$S3_PATH/$BACKUP.tar.gz"
+if [ "$(cat "$status" 2>/dev/null)" != 0 ]; then
+  echo "archive $WORKSPACE_PATH failed" >&2
+  mc rm "backup/$S3_BUCKET/$S3_PATH/$BACKUP.tar.gz" || true
+  exit 1
+fi
+for old in $PRUNE; do
+  mc rm "backup/$S3_BUCKET/$S3_PATH/$old.tar.gz" || true
+done
+`
+
+const restoreScript = `set -e
+mc alias set backup "$S3_ENDPOINT" "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY"
+mc cat "backup/$S3_BUCKET/$S3_PATH/$BACKUP.tar.gz" | tar xzf - -C "$WORKSPACE_PATH"
+`
+
+// constructBackupJob 构造备份或者恢复工作空间存储卷的 Job,backup 为备份的名字
+// workspace 为备份所属的工作空间,claim 为挂载的 PVC
+func constructBackupJob(policy *v1.WorkSpaceBackupPolicy, name, workspace, claim, backup, script string) *batchv1.Job {
+	storage := policy.Spec.Storage
+	image := policy.Spec.Image
+	if image == "" {
+		image = defaultBackupImage
+	}
+	credential := func(key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: storage.CredentialsSecret},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	// 备份和恢复的 Job 使用 restricted 安全配置档,mc 的配置写到 /tmp 中
+	podContext, containerContext := constructSecurityContext(v1.SecurityProfileRestricted)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: policy.Namespace,
+			Labels: map[string]string{
+				"app":             "cloud-ide",
+				WorkSpaceLabel:    workspace,
+				BackupPolicyLabel: policy.Name,
+			},
+			Annotations: map[string]string{backupNameAnnotation: backup},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: pointer.Int32(2),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": "cloud-ide", BackupPolicyLabel: policy.Name},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: podContext,
+					Volumes: []corev1.Volume{
+						{
+							Name: "workspace",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+							},
+						},
+						{
+							Name:         "tmp",
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:            "backup",
+							Image:           image,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Command:         []string{"/bin/sh", "-c", script},
+							Env: []corev1.EnvVar{
+								{Name: "S3_ENDPOINT", Value: storage.Endpoint},
+								{Name: "S3_BUCKET", Value: storage.Bucket},
+								{Name: "S3_PATH", Value: strings.TrimSuffix(storage.Prefix+workspace, "/")},
+								{Name: "BACKUP", Value: backup},
+								{Name: "WORKSPACE_PATH", Value: backupMountPath},
+								{Name: "MC_CONFIG_DIR", Value: "/tmp/.mc"},
+								credential("AWS_ACCESS_KEY_ID"),
+								credential("AWS_SECRET_ACCESS_KEY"),
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "workspace", MountPath: backupMountPath},
+								{Name: "tmp", MountPath: "/tmp"},
+							},
+							SecurityContext: containerContext,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// backupJobName 备份 Job 的名字,工作空间的名字过长时截断,保证 Job 的名字可以作为标签的值
+func backupJobName(workspace, suffix string) string {
+	if max := 63 - len(suffix) - 1; len(workspace) > max {
+		workspace = strings.TrimSuffix(workspace[:max], "-")
+	}
+	return fmt.Sprintf("%s-%s", workspace, suffix)
+}
+
+// jobFinished 返回 Job 是否已经结束以及是否成功
+func jobFinished(job *batchv1.Job) (finished bool, succeeded bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// backupInterval 返回策略的备份间隔,CRD 校验之前创建的策略间隔可能为 0,不足最小间隔时使用最小间隔
+func backupInterval(policy *v1.WorkSpaceBackupPolicy) time.Duration {
+	if policy.Spec.Interval.Duration < minBackupInterval {
+		return minBackupInterval
+	}
+	return policy.Spec.Interval.Duration
+}
+
+// pruneBackups 返回创建新的备份之后超出保留数量需要删除的旧备份,backups 按时间从旧到新排列
+func pruneBackups(backups []string, retention int32) []string {
+	keep := int(retention) - 1
+	if keep < 0 {
+		keep = 0
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	return backups[:len(backups)-keep]
+}
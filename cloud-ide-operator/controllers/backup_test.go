@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPruneBackups(t *testing.T) {
+	backups := []string{"20240101-000000", "20240102-000000", "20240103-000000"}
+	tests := []struct {
+		name      string
+		backups   []string
+		retention int32
+		want      []string
+	}{
+		{
+			name:      "no backups",
+			retention: 1,
+		},
+		{
+			name:      "below retention",
+			backups:   backups,
+			retention: 5,
+		},
+		{
+			name:      "new backup reaches retention",
+			backups:   backups,
+			retention: 4,
+		},
+		{
+			name:      "prune oldest",
+			backups:   backups,
+			retention: 3,
+			want:      backups[:1],
+		},
+		{
+			name:      "keep only the new backup",
+			backups:   backups,
+			retention: 1,
+			want:      backups,
+		},
+		{
+			name:      "invalid retention",
+			backups:   backups,
+			retention: 0,
+			want:      backups,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pruneBackups(tt.backups, tt.retention); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pruneBackups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupRetention(t *testing.T) {
+	space := &v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default"}}
+	policy := &v1.WorkSpaceBackupPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "daily", Namespace: "default", UID: "policy-uid"},
+		Spec: v1.WorkSpaceBackupPolicySpec{
+			Interval:  metav1.Duration{Duration: 24 * time.Hour},
+			Retention: 2,
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: claimKey(space).Name, Namespace: space.Namespace}}
+	ws := newTestReconciler(t, space, policy, pvc)
+	r := &WorkSpaceBackupPolicyReconciler{Client: ws.Client, Scheme: ws.Scheme}
+
+	ctx := context.Background()
+	st := v1.WorkSpaceBackupStatus{Workspace: space.Name}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	wants := [][]string{
+		{"20240101-000000"},
+		{"20240101-000000", "20240102-000000"},
+		{"20240102-000000", "20240103-000000"},
+		{"20240103-000000", "20240104-000000"},
+	}
+	for i, want := range wants {
+		if _, err := r.startBackup(ctx, policy, space, &st, now); err != nil {
+			t.Fatal(err)
+		}
+
+		// 模拟备份 Job 执行成功
+		job := &batchv1.Job{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: st.ActiveJob, Namespace: policy.Namespace}, job); err != nil {
+			t.Fatal(err)
+		}
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		if err := r.Client.Status().Update(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.observeJob(ctx, policy.Namespace, &st); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(st.Backups, want) {
+			t.Fatalf("backup %d: backups = %v, want %v", i, st.Backups, want)
+		}
+		if st.Phase != v1.BackupPhaseSucceeded || st.ActiveJob != "" {
+			t.Fatalf("backup %d: phase %q active job %q", i, st.Phase, st.ActiveJob)
+		}
+		now = now.Add(policy.Spec.Interval.Duration)
+	}
+}
+
+func TestObserveFailedBackupKeepsBackups(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ws-backup-20240103-000000",
+			Namespace: "default",
+			Annotations: map[string]string{
+				backupNameAnnotation:  "20240103-000000",
+				backupPruneAnnotation: "20240101-000000",
+			},
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}},
+		},
+	}
+	ws := newTestReconciler(t, job)
+	r := &WorkSpaceBackupPolicyReconciler{Client: ws.Client, Scheme: ws.Scheme}
+
+	backups := []string{"20240101-000000", "20240102-000000"}
+	st := v1.WorkSpaceBackupStatus{Workspace: "ws", ActiveJob: job.Name, Backups: append([]string(nil), backups...)}
+	if _, err := r.observeJob(context.Background(), job.Namespace, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Phase != v1.BackupPhaseFailed {
+		t.Errorf("phase = %q, want %q", st.Phase, v1.BackupPhaseFailed)
+	}
+	if !reflect.DeepEqual(st.Backups, backups) {
+		t.Errorf("backups = %v, want %v", st.Backups, backups)
+	}
+}
+
+// TestBackupScript 用记录调用的 mc 和可以失败的 tar 执行备份脚本
+func TestBackupScript(t *testing.T) {
+	tests := []struct {
+		name      string
+		tarExit   int
+		wantErr   bool
+		wantCalls []string
+		// 不能出现的调用
+		forbidden []string
+	}{
+		{
+			name:      "succeeded",
+			wantCalls: []string{"pipe backup/bucket/ws/20240103-000000.tar.gz", "rm backup/bucket/ws/20240101-000000.tar.gz"},
+		},
+		{
+			name:      "archive failed",
+			tarExit:   2,
+			wantErr:   true,
+			wantCalls: []string{"rm backup/bucket/ws/20240103-000000.tar.gz"},
+			forbidden: []string{"rm backup/bucket/ws/20240101-000000.tar.gz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			bin := filepath.Join(dir, "bin")
+			calls := filepath.Join(dir, "calls")
+			if err := os.Mkdir(bin, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			stubs := map[string]string{
+				"mc":  "#!/bin/sh\necho \"$*\" >>" + calls + "\n[ \"$1\" = pipe ] && cat >/dev/null\nexit 0\n",
+				"tar": fmt.Sprintf("#!/bin/sh\necho partial\nexit %d\n", tt.tarExit),
+			}
+			for name, stub := range stubs {
+				if err := os.WriteFile(filepath.Join(bin, name), []byte(stub), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := exec.Command("/bin/sh", "-c", backupScript)
+			cmd.Env = []string{
+				"PATH=" + bin + ":/usr/bin:/bin",
+				"TMPDIR=" + dir,
+				"S3_BUCKET=bucket",
+				"S3_PATH=ws",
+				"BACKUP=20240103-000000",
+				"WORKSPACE_PATH=" + dir,
+				"PRUNE=20240101-000000",
+			}
+			err := cmd.Run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("backup script error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			data, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimSpace(string(data)), "\n")
+			for _, want := range tt.wantCalls {
+				if !contains(got, want) {
+					t.Errorf("mc calls = %q, want %q", got, want)
+				}
+			}
+			for _, call := range tt.forbidden {
+				if contains(got, call) {
+					t.Errorf("mc calls = %q, must not contain %q", got, call)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     time.Duration
+	}{
+		{interval: 0, want: minBackupInterval},
+		{interval: -time.Hour, want: minBackupInterval},
+		{interval: time.Minute, want: minBackupInterval},
+		{interval: 24 * time.Hour, want: 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		policy := &v1.WorkSpaceBackupPolicy{Spec: v1.WorkSpaceBackupPolicySpec{Interval: metav1.Duration{Duration: tt.interval}}}
+		if got := backupInterval(policy); got != tt.want {
+			t.Errorf("backupInterval(%s) = %s, want %s", tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestRestoreFailureIsTerminal(t *testing.T) {
+	space := &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default", UID: "ws-uid", Generation: 1},
+		Spec:       v1.WorkSpaceSpec{RestoreFrom: &v1.BackupRestore{Policy: "daily", Workspace: "old", Backup: "20240101-000000"}},
+	}
+	policy := &v1.WorkSpaceBackupPolicy{ObjectMeta: metav1.ObjectMeta{Name: "daily", Namespace: "default"}}
+	r := newTestReconciler(t, space, policy)
+	ctx := context.Background()
+	key := client.ObjectKey{Name: backupJobName(space.Name, "restore"), Namespace: space.Namespace}
+
+	if restored, err := r.restoreBackup(ctx, space); err != nil || restored {
+		t.Fatalf("restoreBackup() = %v, %v, want the restore job created", restored, err)
+	}
+	job := &batchv1.Job{}
+	if err := r.Client.Get(ctx, key, job); err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	if err := r.Client.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := r.restoreBackup(ctx, space); err != nil || restored || !restoreFailed(space) {
+		t.Fatalf("restoreBackup() = %v, %v, conditions %+v, want failed", restored, err, space.Status.Conditions)
+	}
+
+	// 同一个 generation 中不再创建恢复 Job
+	if restored, err := r.restoreBackup(ctx, space); err != nil || restored || !restoreFailed(space) {
+		t.Fatalf("restoreBackup() = %v, %v, want still failed", restored, err)
+	}
+	if err := r.Client.Get(ctx, key, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Fatalf("restore job recreated after failure: %v", err)
+	}
+
+	// 修改工作空间后重新恢复
+	space.Generation = 2
+	if restored, err := r.restoreBackup(ctx, space); err != nil || restored || restoreFailed(space) {
+		t.Fatalf("restoreBackup() = %v, %v, conditions %+v, want restoring again", restored, err, space.Status.Conditions)
+	}
+	if err := r.Client.Get(ctx, key, &batchv1.Job{}); err != nil {
+		t.Errorf("restore job not recreated: %v", err)
+	}
+}
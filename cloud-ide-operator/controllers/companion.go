@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"path"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 伴随服务的数据保存在工作空间存储卷中的目录
+const companionDataDir = ".companions"
+
+// addCompanions 把伴随服务作为 sidecar 加入工作空间的 Pod,和工作空间共享网络,随 Pod 一起启动和停止
+// 数据保存在工作空间存储卷的子目录中,块设备上没有文件系统,使用 emptyDir
+func addCompanions(pod *corev1.Pod, space *v1.WorkSpace, volumeName string) {
+	dataVolume := volumeName
+	if isBlockVolume(space) {
+		dataVolume = "companion-data"
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         dataVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	// 和 docker-compose 一样,也可以通过服务的名字访问伴随服务
+	alias := corev1.HostAlias{IP: "127.0.0.1"}
+	for _, c := range space.Spec.Companions {
+		alias.Hostnames = append(alias.Hostnames, c.Name)
+		container := corev1.Container{
+			Name:            c.Name,
+			Image:           c.Image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         c.Command,
+			Args:            c.Args,
+			Env:             c.Env,
+			Resources:       limitResources(c.Cpu, c.Memory),
+			SecurityContext: c.SecurityContext.DeepCopy(),
+		}
+		for _, port := range c.Ports {
+			container.Ports = append(container.Ports, corev1.ContainerPort{ContainerPort: port})
+		}
+		if c.DataPath != "" {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      dataVolume,
+				MountPath: c.DataPath,
+				SubPath:   path.Join(companionDataDir, c.Name),
+			})
+		}
+		pod.Spec.Containers = append(pod.Spec.Containers, container)
+	}
+	pod.Spec.HostAliases = append(pod.Spec.HostAliases, alias)
+}
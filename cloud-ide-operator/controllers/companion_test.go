@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestCompanionSecurityContext(t *testing.T) {
+	root := &corev1.SecurityContext{RunAsUser: pointer.Int64(0), RunAsNonRoot: pointer.Bool(false)}
+	space := &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default"},
+		Spec: v1.WorkSpaceSpec{
+			Image:           "code-server",
+			MountPath:       "/home/coder",
+			SecurityProfile: v1.SecurityProfileRestricted,
+			Companions: []v1.Companion{
+				{Name: "postgres", Image: "postgres:15", DataPath: "/var/lib/postgresql/data", SecurityContext: root},
+				{Name: "redis", Image: "redis:7"},
+			},
+		},
+	}
+
+	pod := (&WorkSpaceReconciler{}).constructPod(space)
+	containers := map[string]corev1.Container{}
+	for _, c := range pod.Spec.Containers {
+		containers[c.Name] = c
+	}
+
+	if sc := containers["ws"].SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("workspace container security context = %+v, want restricted", sc)
+	}
+	if sc := containers["postgres"].SecurityContext; sc == nil || *sc.RunAsUser != 0 || sc.ReadOnlyRootFilesystem != nil {
+		t.Errorf("postgres security context = %+v, want its own", sc)
+	}
+	// 没有设置安全上下文的伴随服务使用镜像中的用户,同时满足 restricted 级别
+	if sc := containers["redis"].SecurityContext; sc == nil || sc.RunAsUser != nil || sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot {
+		t.Errorf("redis security context = %+v, want restricted with the image user", sc)
+	}
+	if sc := pod.Spec.SecurityContext; sc.RunAsUser != nil || sc.RunAsGroup != nil {
+		t.Errorf("pod security context = %+v, want the user set on containers only", sc)
+	}
+	if level := containerSecurityLevel(&pod.Spec, &pod.Spec.Containers[len(pod.Spec.Containers)-1]); level != podSecurityLevels["restricted"] {
+		t.Errorf("redis pod security level = %d, want restricted", level)
+	}
+	for _, name := range []string{"postgres", "redis"} {
+		for _, mount := range containers[name].VolumeMounts {
+			if mount.Name == "tmp" {
+				t.Errorf("companion %s mounts the read only profile tmp volume", name)
+			}
+		}
+	}
+	if space.Spec.Companions[0].SecurityContext != root || *root.RunAsUser != 0 {
+		t.Error("companion security context in the spec was modified")
+	}
+}
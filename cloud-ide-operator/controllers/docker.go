@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	dockerContainerName = "docker"
+	dockerSocketVolume  = "docker-socket"
+	dockerCacheVolume   = "docker-cache"
+	// 主容器和 sidecar 共享 socket 的目录
+	dockerSocketPath = "/run/workspace-docker"
+
+	defaultDockerImage        = "docker:24.0.7-dind-rootless"
+	defaultBuildKitImage      = "moby/buildkit:v0.12.4-rootless"
+	defaultDockerCacheStorage = "20Gi"
+)
+
+// dockerCacheKey 工作空间中 Docker 镜像和构建缓存使用的 PVC
+func dockerCacheKey(key client.ObjectKey) client.ObjectKey {
+	return client.ObjectKey{Name: key.Name + "-docker-cache", Namespace: key.Namespace}
+}
+
+// addDocker 添加 rootless Docker 或者 BuildKit sidecar,通过共享的 emptyDir 把 socket 暴露给主容器
+func addDocker(pod *corev1.Pod, space *v1.WorkSpace) {
+	docker := space.Spec.Docker
+
+	// 缓存随工作空间持久化,临时存储的工作空间使用 emptyDir
+	cacheSource := corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: dockerCacheKey(client.ObjectKeyFromObject(space)).Name},
+	}
+	if isEphemeral(space) {
+		cacheSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes,
+		corev1.Volume{Name: dockerSocketVolume, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: dockerCacheVolume, VolumeSource: cacheSource},
+	)
+
+	sidecar := corev1.Container{
+		Name:            dockerContainerName,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: dockerSocketVolume, MountPath: dockerSocketPath},
+		},
+		// rootless 模式下也需要特权才能创建用户命名空间和挂载 overlay
+		SecurityContext: &corev1.SecurityContext{
+			Privileged: pointer.Bool(true),
+			RunAsUser:  pointer.Int64(workspaceUser),
+			RunAsGroup: pointer.Int64(workspaceUser),
+		},
+		Resources: limitResources(docker.Cpu, docker.Memory),
+	}
+
+	var env corev1.EnvVar
+	switch docker.Engine {
+	case v1.DockerEngineBuildKit:
+		socket := dockerSocketPath + "/buildkitd.sock"
+		sidecar.Image = defaultBuildKitImage
+		sidecar.Args = []string{"--addr", "unix://" + socket, "--oci-worker-no-process-sandbox"}
+		sidecar.VolumeMounts = append(sidecar.VolumeMounts, corev1.VolumeMount{
+			Name: dockerCacheVolume, MountPath: "/home/user/.local/share/buildkit",
+		})
+		sidecar.ReadinessProbe = socketProbe(socket)
+		env = corev1.EnvVar{Name: "BUILDKIT_HOST", Value: "unix://" + socket}
+	default:
+		socket := dockerSocketPath + "/docker.sock"
+		sidecar.Image = defaultDockerImage
+		sidecar.Args = []string{"--host", "unix://" + socket}
+		sidecar.Env = []corev1.EnvVar{{Name: "DOCKER_TLS_CERTDIR", Value: ""}}
+		sidecar.VolumeMounts = append(sidecar.VolumeMounts, corev1.VolumeMount{
+			Name: dockerCacheVolume, MountPath: "/home/rootless/.local/share/docker",
+		})
+		sidecar.ReadinessProbe = socketProbe(socket)
+		// 停止前先停止 sidecar 中运行的容器,让它们有机会正常退出
+		sidecar.Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", "docker -H unix://" + socket + " ps -q | xargs -r docker -H unix://" + socket + " stop"}},
+			},
+		}
+		env = corev1.EnvVar{Name: "DOCKER_HOST", Value: "unix://" + socket}
+	}
+	if docker.Image != "" {
+		sidecar.Image = docker.Image
+	}
+
+	container := &pod.Spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: dockerSocketVolume, MountPath: dockerSocketPath})
+	if !hasEnv(container.Env, env.Name) {
+		container.Env = append(container.Env, env)
+	}
+	pod.Spec.Containers = append(pod.Spec.Containers, sidecar)
+}
+
+func socketProbe(socket string) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: []string{"test", "-S", socket}},
+		},
+		PeriodSeconds: 5,
+	}
+}
+
+// limitResources 返回 sidecar 的资源上限,没有设置或者无法解析的资源不做限制
+func limitResources(cpu, memory string) corev1.ResourceRequirements {
+	limits := corev1.ResourceList{}
+	if quantity, err := resource.ParseQuantity(cpu); err == nil {
+		limits[corev1.ResourceCPU] = quantity
+	}
+	if quantity, err := resource.ParseQuantity(memory); err == nil {
+		limits[corev1.ResourceMemory] = quantity
+	}
+	if len(limits) == 0 {
+		return corev1.ResourceRequirements{}
+	}
+	return corev1.ResourceRequirements{Limits: limits}
+}
+
+// createDockerCache 创建 Docker 缓存使用的 PVC,和工作空间的 PVC 使用相同的存储类
+func (r *WorkSpaceReconciler) createDockerCache(ctx context.Context, space *v1.WorkSpace) error {
+	if space.Spec.Docker == nil || isEphemeral(space) {
+		return nil
+	}
+
+	exist, err := r.checkPVCExist(ctx, dockerCacheKey(client.ObjectKeyFromObject(space)))
+	if err != nil {
+		return err
+	}
+	if exist {
+		return nil
+	}
+
+	storage := space.Spec.Docker.CacheStorage
+	if storage == "" {
+		storage = defaultDockerCacheStorage
+	}
+	quantity, err := resource.ParseQuantity(storage)
+	if err != nil {
+		klog.Errorf("parse docker cache storage error:%v", err)
+		return err
+	}
+
+	key := dockerCacheKey(client.ObjectKeyFromObject(space))
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels: map[string]string{
+				"app":          "cloud-ide",
+				WorkSpaceLabel: space.Name,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: space.Spec.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: quantity},
+			},
+		},
+	}
+
+	if err := r.Client.Create(ctx, pvc); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DotfilesImage 用于拉取 dotfiles 的初始化容器镜像
+	DotfilesImage = "alpine/git:2.36.3"
+
+	dotfilesVolumeName = "volume-dotfiles"
+	dotfilesSourcePath = "/dotfiles"
+)
+
+// 从 git 仓库拉取 dotfiles 到 $WORKSPACE/.dotfiles,并把其中的隐藏文件链接到工作空间目录下
+// 已经拉取过的仓库只做更新,不会覆盖用户在工作空间中已有的同名文件
+const dotfilesRepositoryScript = `set -e
+cd "$WORKSPACE"
+if [ -d .dotfiles/.git ]; then
+  git -C .dotfiles pull --ff-only || true
+else
+  git clone --depth 1 "$DOTFILES_REPOSITORY" .dotfiles
+fi
+for f in .dotfiles/.[!.]*; do
+  name=$(basename "$f")
+  [ "$name" = ".git" ] && continue
+  [ -e "$name" ] || ln -s "$f" "$name"
+done
+`
+
+// 把 ConfigMap 中的文件复制到工作空间目录下,ConfigMap 挂载时生成的 ..data 等目录需要跳过
+const dotfilesConfigMapScript = `set -e
+for name in $(ls -A "$DOTFILES_SOURCE"); do
+  case "$name" in ..*) continue ;; esac
+  cp -L "$DOTFILES_SOURCE/$name" "$WORKSPACE/$name"
+done
+`
+
+// addDotfiles 为 Pod 添加一个初始化容器,在 code-server 启动前把 dotfiles 放入存储卷
+func addDotfiles(pod *corev1.Pod, space *v1.WorkSpace, volumeName string) {
+	container := corev1.Container{
+		Name:            "dotfiles",
+		Image:           DotfilesImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c"},
+		Env: []corev1.EnvVar{
+			{Name: "WORKSPACE", Value: space.Spec.MountPath},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      volumeName,
+				MountPath: space.Spec.MountPath,
+			},
+		},
+	}
+
+	dotfiles := space.Spec.Dotfiles
+	switch {
+	case dotfiles.Repository != "":
+		// 仓库地址通过环境变量传入,避免拼接到脚本中
+		container.Args = []string{dotfilesRepositoryScript}
+		container.Env = append(container.Env, corev1.EnvVar{Name: "DOTFILES_REPOSITORY", Value: dotfiles.Repository})
+	case dotfiles.ConfigMap != "":
+		container.Args = []string{dotfilesConfigMapScript}
+		container.Env = append(container.Env, corev1.EnvVar{Name: "DOTFILES_SOURCE", Value: dotfilesSourcePath})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      dotfilesVolumeName,
+			ReadOnly:  true,
+			MountPath: dotfilesSourcePath,
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: dotfilesVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: dotfiles.ConfigMap},
+				},
+			},
+		})
+	default:
+		return
+	}
+
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func envValue(env []corev1.EnvVar, name string) (string, bool) {
+	for _, e := range env {
+		if e.Name == name {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestAddDotfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		dotfiles *v1.Dotfiles
+		// 为空时不添加初始化容器
+		script    string
+		env       map[string]string
+		configMap string
+	}{
+		{
+			name:     "repository",
+			dotfiles: &v1.Dotfiles{Repository: "https://github.com/alice/dotfiles"},
+			script:   dotfilesRepositoryScript,
+			env:      map[string]string{"WORKSPACE": "/home/coder", "DOTFILES_REPOSITORY": "https://github.com/alice/dotfiles"},
+		},
+		{
+			name:      "configmap",
+			dotfiles:  &v1.Dotfiles{ConfigMap: "alice-dotfiles"},
+			script:    dotfilesConfigMapScript,
+			env:       map[string]string{"WORKSPACE": "/home/coder", "DOTFILES_SOURCE": dotfilesSourcePath},
+			configMap: "alice-dotfiles",
+		},
+		{
+			name:     "repository wins",
+			dotfiles: &v1.Dotfiles{Repository: "https://github.com/alice/dotfiles", ConfigMap: "alice-dotfiles"},
+			script:   dotfilesRepositoryScript,
+			env:      map[string]string{"WORKSPACE": "/home/coder", "DOTFILES_REPOSITORY": "https://github.com/alice/dotfiles"},
+		},
+		{
+			name:     "empty",
+			dotfiles: &v1.Dotfiles{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			space := newPodWorkSpace()
+			space.Spec.Dotfiles = tt.dotfiles
+			pod := &corev1.Pod{}
+			addDotfiles(pod, space, "volume-user-workspace")
+
+			if tt.script == "" {
+				if len(pod.Spec.InitContainers) != 0 || len(pod.Spec.Volumes) != 0 {
+					t.Fatalf("dotfiles added without a source: %+v", pod.Spec)
+				}
+				return
+			}
+			if len(pod.Spec.InitContainers) != 1 {
+				t.Fatalf("init containers = %d, want 1", len(pod.Spec.InitContainers))
+			}
+			c := pod.Spec.InitContainers[0]
+			if c.Image != DotfilesImage || len(c.Args) != 1 || c.Args[0] != tt.script {
+				t.Errorf("image %q args %q, want %q running the %s script", c.Image, c.Args, DotfilesImage, tt.name)
+			}
+			if len(c.Env) != len(tt.env) {
+				t.Errorf("env = %v, want %v", c.Env, tt.env)
+			}
+			for name, value := range tt.env {
+				if got, ok := envValue(c.Env, name); !ok || got != value {
+					t.Errorf("env %s = %q, want %q", name, got, value)
+				}
+			}
+			if m := c.VolumeMounts[0]; m.Name != "volume-user-workspace" || m.MountPath != "/home/coder" || m.ReadOnly {
+				t.Errorf("workspace mount = %+v", m)
+			}
+
+			if tt.configMap == "" {
+				if len(pod.Spec.Volumes) != 0 || len(c.VolumeMounts) != 1 {
+					t.Errorf("configmap volume added for a repository: %+v", pod.Spec.Volumes)
+				}
+				return
+			}
+			if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].ConfigMap == nil || pod.Spec.Volumes[0].ConfigMap.Name != tt.configMap {
+				t.Fatalf("volumes = %+v, want configmap %s", pod.Spec.Volumes, tt.configMap)
+			}
+			if len(c.VolumeMounts) != 2 || c.VolumeMounts[1].MountPath != dotfilesSourcePath || !c.VolumeMounts[1].ReadOnly {
+				t.Errorf("configmap mount = %+v", c.VolumeMounts)
+			}
+		})
+	}
+}
+
+func TestConstructPodDotfiles(t *testing.T) {
+	block := corev1.PersistentVolumeBlock
+	tests := []struct {
+		name       string
+		volumeMode *corev1.PersistentVolumeMode
+		want       int
+	}{
+		{name: "filesystem", want: 1},
+		// 块设备上没有文件系统,不能放入 dotfiles
+		{name: "block", volumeMode: &block, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			space := newPodWorkSpace()
+			space.Spec.Dotfiles = &v1.Dotfiles{Repository: "https://github.com/alice/dotfiles"}
+			space.Spec.VolumeMode = tt.volumeMode
+			pod := (&WorkSpaceReconciler{}).constructPod(space)
+			if got := len(pod.Spec.InitContainers); got != tt.want {
+				t.Errorf("init containers = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// applyHardwareClass 把 Hardware 对应的 HardwareClass 中的调度约束合并到工作空间中,
+// HardwareClass 的名字是 Hardware 的小写形式,找不到时 Hardware 只用于展示
+func applyHardwareClass(ctx context.Context, c client.Client, spec *v1.WorkSpaceSpec) error {
+	if spec.Hardware == "" {
+		return nil
+	}
+
+	class := &v1.HardwareClass{}
+	if err := c.Get(ctx, client.ObjectKey{Name: strings.ToLower(spec.Hardware)}, class); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	mergeScheduling(&spec.WorkSpaceScheduling, &class.Spec.WorkSpaceScheduling)
+	return nil
+}
+
+// 工作空间中的设置优先,nodeSelector 按 key 合并,tolerations 追加
+func mergeScheduling(spec *v1.WorkSpaceScheduling, class *v1.WorkSpaceScheduling) {
+	if len(class.NodeSelector) > 0 {
+		selector := make(map[string]string, len(class.NodeSelector)+len(spec.NodeSelector))
+		for k, v := range class.NodeSelector {
+			selector[k] = v
+		}
+		for k, v := range spec.NodeSelector {
+			selector[k] = v
+		}
+		spec.NodeSelector = selector
+	}
+	if spec.Affinity == nil && class.Affinity != nil {
+		spec.Affinity = class.Affinity.DeepCopy()
+	}
+	if len(class.Tolerations) > 0 {
+		tolerations := append([]corev1.Toleration{}, spec.Tolerations...)
+		spec.Tolerations = append(tolerations, class.Tolerations...)
+	}
+	if spec.PriorityClassName == "" {
+		spec.PriorityClassName = class.PriorityClassName
+	}
+	if len(spec.TopologySpreadConstraints) == 0 {
+		spec.TopologySpreadConstraints = class.TopologySpreadConstraints
+	}
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+func TestMergeScheduling(t *testing.T) {
+	gpuToleration := corev1.Toleration{Key: "gpu", Operator: corev1.TolerationOpExists}
+	userToleration := corev1.Toleration{Key: "spot", Operator: corev1.TolerationOpExists}
+	classAffinity := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}}
+	userAffinity := &corev1.Affinity{PodAffinity: &corev1.PodAffinity{}}
+	spread := []corev1.TopologySpreadConstraint{{MaxSkew: 1, TopologyKey: "zone"}}
+
+	class := v1.WorkSpaceScheduling{
+		NodeSelector:              map[string]string{"accelerator": "a100", "pool": "gpu"},
+		Affinity:                  classAffinity,
+		Tolerations:               []corev1.Toleration{gpuToleration},
+		PriorityClassName:         "gpu",
+		TopologySpreadConstraints: spread,
+	}
+
+	tests := []struct {
+		name string
+		spec v1.WorkSpaceScheduling
+		want v1.WorkSpaceScheduling
+	}{
+		{
+			name: "empty workspace",
+			spec: v1.WorkSpaceScheduling{},
+			want: class,
+		},
+		{
+			name: "workspace wins",
+			spec: v1.WorkSpaceScheduling{
+				NodeSelector:              map[string]string{"pool": "dev", "zone": "a"},
+				Affinity:                  userAffinity,
+				Tolerations:               []corev1.Toleration{userToleration},
+				PriorityClassName:         "high",
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{MaxSkew: 2, TopologyKey: "node"}},
+			},
+			want: v1.WorkSpaceScheduling{
+				NodeSelector:              map[string]string{"accelerator": "a100", "pool": "dev", "zone": "a"},
+				Affinity:                  userAffinity,
+				Tolerations:               []corev1.Toleration{userToleration, gpuToleration},
+				PriorityClassName:         "high",
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{MaxSkew: 2, TopologyKey: "node"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec
+			mergeScheduling(&spec, &class)
+			if !equality.Semantic.DeepEqual(spec, tt.want) {
+				t.Errorf("mergeScheduling() = %+v, want %+v", spec, tt.want)
+			}
+		})
+	}
+
+	if len(class.NodeSelector) != 2 || len(class.Tolerations) != 1 {
+		t.Errorf("mergeScheduling() modified the hardware class: %+v", class)
+	}
+}
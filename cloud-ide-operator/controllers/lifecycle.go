@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ConditionPostStartHook postStart 钩子是否执行成功
+	ConditionPostStartHook = "PostStartHook"
+	// ConditionPreStopHook preStop 钩子是否执行成功
+	ConditionPreStopHook = "PreStopHook"
+
+	// kubelet 在钩子无法执行时记录的事件和容器状态,以及就绪探针失败的事件
+	reasonFailedPostStartHook = "FailedPostStartHook"
+	reasonFailedPreStopHook   = "FailedPreStopHook"
+	reasonPostStartHookError  = "PostStartHookError"
+	reasonUnhealthy           = "Unhealthy"
+
+	// 钩子的退出码和输出写在容器的这个目录中
+	hookStatusDir = "/tmp/.cloud-ide"
+	// 钩子写入终止信息和探针输出的前缀,后面是钩子的退出码
+	postStartResultPrefix = "postStart exit code: "
+	preStopResultPrefix   = "preStop exit code: "
+	// 终止信息和探针输出中保留的钩子输出的长度
+	hookOutputLimit = 1024
+)
+
+// constructLifecycle 把用户的命令转换成容器的生命周期钩子,命令按顺序在 shell 中执行
+func constructLifecycle(lifecycle *v1.WorkSpaceLifecycle) *corev1.Lifecycle {
+	if lifecycle == nil || (len(lifecycle.PostStart) == 0 && len(lifecycle.PreStop) == 0) {
+		return nil
+	}
+	return &corev1.Lifecycle{
+		PostStart: lifecycleHandler("postStart", lifecycle.PostStart),
+		PreStop:   lifecycleHandler("preStop", lifecycle.PreStop),
+	}
+}
+
+// lifecycleHandler 钩子总是以 0 退出,否则 postStart 失败时 kubelet 会不断重启容器。
+// 命令的退出码和输出记录在 hookStatusDir 中,preStop 的结果同时写入容器的终止信息
+func lifecycleHandler(hook string, commands []string) *corev1.LifecycleHandler {
+	if len(commands) == 0 {
+		return nil
+	}
+	status := hookStatusDir + "/" + hook
+	script := fmt.Sprintf("mkdir -p %s\n(\nset -e\n%s\n) >%s.log 2>&1\necho $? >%s\n",
+		hookStatusDir, strings.Join(commands, "\n"), status, status)
+	if hook == "preStop" {
+		script += fmt.Sprintf("{ echo \"%s$(cat %s)\"; tail -c %d %s.log; } >%s\n",
+			preStopResultPrefix, status, hookOutputLimit, status, corev1.TerminationMessagePathDefault)
+	}
+	script += "exit 0"
+	return &corev1.LifecycleHandler{
+		Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", script}},
+	}
+}
+
+// postStartProbe postStart 钩子执行成功后容器才就绪。钩子失败时探针只失败一次,输出钩子的日志,
+// kubelet 会把输出记录到 Unhealthy 事件中,之后探针通过,用户仍然可以进入工作空间排查问题
+func postStartProbe(lifecycle *v1.WorkSpaceLifecycle) *corev1.Probe {
+	if lifecycle == nil || len(lifecycle.PostStart) == 0 {
+		return nil
+	}
+	status := hookStatusDir + "/postStart"
+	script := fmt.Sprintf(`test -f %s || exit 1; test "$(cat %s)" = 0 && exit 0; test -f %s.reported && exit 0; `+
+		`touch %s.reported; echo "%s$(cat %s)"; tail -c %d %s.log; exit 1`,
+		status, status, status, status, postStartResultPrefix, status, hookOutputLimit, status)
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", script}},
+		},
+		PeriodSeconds: 10,
+	}
+}
+
+// preStopResult 从工作空间容器的终止信息中读取 preStop 钩子的结果,容器还没有终止时 done 为 false
+func preStopResult(pod *corev1.Pod, container string) (succeeded bool, message string, done bool) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != container || status.State.Terminated == nil {
+			continue
+		}
+		message = status.State.Terminated.Message
+		if !strings.HasPrefix(message, preStopResultPrefix) {
+			return false, "", false
+		}
+		code := strings.TrimPrefix(message, preStopResultPrefix)
+		if i := strings.IndexByte(code, '\n'); i >= 0 {
+			code = code[:i]
+		}
+		return code == "0", message, true
+	}
+	return false, "", false
+}
+
+// checkPostStartHook 根据容器状态和 kubelet 记录的事件判断 postStart 钩子是否执行成功,结果记录到状态条件中。
+// 钩子失败后容器仍然会就绪,因此同一个 generation 得到的结果不再改变,直到下一次启动修改 spec
+func (r *WorkSpaceReconciler) checkPostStartHook(ctx context.Context, space *v1.WorkSpace) {
+	if space.Spec.Lifecycle == nil || len(space.Spec.Lifecycle.PostStart) == 0 {
+		meta.RemoveStatusCondition(&space.Status.Conditions, ConditionPostStartHook)
+		return
+	}
+
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(ctx, podKey(space), pod); err != nil {
+		return
+	}
+
+	// 钩子无法执行时 kubelet 会杀掉容器,容器在重启前处于 PostStartHookError 状态
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == reasonPostStartHookError {
+			setHookCondition(space, ConditionPostStartHook, metav1.ConditionFalse, "Failed", status.State.Waiting.Message)
+			return
+		}
+	}
+	if current := meta.FindStatusCondition(space.Status.Conditions, ConditionPostStartHook); current != nil &&
+		current.Status != metav1.ConditionUnknown && current.ObservedGeneration == space.Generation {
+		return
+	}
+
+	container := workspaceContainer(pod)
+	// 命令执行失败时就绪探针失败一次,失败事件中记录了钩子的输出,之后容器会就绪,因此先检查事件
+	if message := r.hookFailure(ctx, pod, reasonUnhealthy, containerFieldPath(container)); strings.Contains(message, postStartResultPrefix) {
+		setHookCondition(space, ConditionPostStartHook, metav1.ConditionFalse, "Failed", message)
+		return
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container && status.Ready {
+			setHookCondition(space, ConditionPostStartHook, metav1.ConditionTrue, "Succeeded", "hook completed in pod "+pod.Name)
+			return
+		}
+	}
+	if message := r.hookFailure(ctx, pod, reasonFailedPostStartHook, ""); message != "" {
+		setHookCondition(space, ConditionPostStartHook, metav1.ConditionFalse, "Failed", message)
+	}
+}
+
+// checkPreStopHook 在 Pod 停止的过程中检查 preStop 钩子是否执行失败,返回 Pod 是否仍在停止中。
+// 每次停止都会修改 spec,因此用 ObservedGeneration 区分本次停止和之前的结果
+func (r *WorkSpaceReconciler) checkPreStopHook(ctx context.Context, space *v1.WorkSpace) bool {
+	if space.Spec.Lifecycle == nil || len(space.Spec.Lifecycle.PreStop) == 0 {
+		meta.RemoveStatusCondition(&space.Status.Conditions, ConditionPreStopHook)
+		return false
+	}
+
+	current := meta.FindStatusCondition(space.Status.Conditions, ConditionPreStopHook)
+	key := podKey(space)
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(ctx, key, pod); err != nil {
+		// Pod 已经删除,停止过程中没有出现失败事件说明钩子执行成功
+		if errors.IsNotFound(err) && current != nil && current.Status == metav1.ConditionUnknown &&
+			current.ObservedGeneration == space.Generation {
+			setHookCondition(space, ConditionPreStopHook, metav1.ConditionTrue, "Succeeded", "hook completed in pod "+key.Name)
+		}
+		return false
+	}
+
+	// 钩子执行完成后容器终止,终止信息中记录了钩子的退出码
+	if succeeded, message, done := preStopResult(pod, workspaceContainer(pod)); done {
+		if succeeded {
+			setHookCondition(space, ConditionPreStopHook, metav1.ConditionTrue, "Succeeded", "hook completed in pod "+pod.Name)
+		} else {
+			setHookCondition(space, ConditionPreStopHook, metav1.ConditionFalse, "Failed", message)
+		}
+	} else if message := r.hookFailure(ctx, pod, reasonFailedPreStopHook, ""); message != "" {
+		setHookCondition(space, ConditionPreStopHook, metav1.ConditionFalse, "Failed", message)
+	} else if current == nil || current.ObservedGeneration != space.Generation {
+		setHookCondition(space, ConditionPreStopHook, metav1.ConditionUnknown, "Running", "pod "+pod.Name+" is stopping")
+	}
+	return true
+}
+
+// 查询 kubelet 为当前 Pod 记录的钩子失败事件,fieldPath 不为空时只查询对应容器的事件,没有失败时返回空字符串
+func (r *WorkSpaceReconciler) hookFailure(ctx context.Context, pod *corev1.Pod, reason, fieldPath string) string {
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+
+	events := &corev1.EventList{}
+	set := fields.Set{
+		"involvedObject.uid": string(pod.UID),
+		"reason":             reason,
+	}
+	if fieldPath != "" {
+		set["involvedObject.fieldPath"] = fieldPath
+	}
+	selector := fields.SelectorFromSet(set)
+	if err := reader.List(ctx, events, client.InNamespace(pod.Namespace), client.MatchingFieldsSelector{Selector: selector}); err != nil {
+		klog.Errorf("list pod events error:%v", err)
+		return ""
+	}
+
+	var latest *corev1.Event
+	for i := range events.Items {
+		if latest == nil || latest.LastTimestamp.Before(&events.Items[i].LastTimestamp) {
+			latest = &events.Items[i]
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Message
+}
+
+// workspaceContainer 返回工作空间容器的名字。工作空间容器总是 Pod 中的第一个容器,
+// 从资源池认领的 Pod 中容器以资源池工作空间的名字命名,不能用当前工作空间的名字查找
+func workspaceContainer(pod *corev1.Pod) string {
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+// 事件中记录的容器的路径
+func containerFieldPath(name string) string {
+	return fmt.Sprintf("spec.containers{%s}", name)
+}
+
+func setHookCondition(space *v1.WorkSpace, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: space.Generation,
+	})
+}
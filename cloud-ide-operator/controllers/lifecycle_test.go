@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestLifecycleHandlerAlwaysSucceeds(t *testing.T) {
+	for _, hook := range []string{"postStart", "preStop"} {
+		handler := lifecycleHandler(hook, []string{"make deps", "false"})
+		script := handler.Exec.Command[2]
+		if !strings.HasSuffix(script, "\nexit 0") {
+			t.Errorf("%s hook does not exit 0:\n%s", hook, script)
+		}
+		if !strings.Contains(script, "set -e\nmake deps\nfalse\n") {
+			t.Errorf("%s hook does not run the commands with set -e:\n%s", hook, script)
+		}
+	}
+	if strings.Contains(lifecycleHandler("postStart", []string{"true"}).Exec.Command[2], corev1.TerminationMessagePathDefault) {
+		t.Error("postStart hook writes the termination message")
+	}
+	if !strings.Contains(lifecycleHandler("preStop", []string{"true"}).Exec.Command[2], corev1.TerminationMessagePathDefault) {
+		t.Error("preStop hook does not write the termination message")
+	}
+	if lifecycleHandler("postStart", nil) != nil {
+		t.Error("empty hook is not nil")
+	}
+}
+
+func TestPreStopResult(t *testing.T) {
+	terminated := func(name, message string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{
+			Name:  name,
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		statuses      []corev1.ContainerStatus
+		wantSucceeded bool
+		wantDone      bool
+	}{
+		{
+			name:     "running",
+			statuses: []corev1.ContainerStatus{{Name: "ws", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}},
+		},
+		{
+			name:          "succeeded",
+			statuses:      []corev1.ContainerStatus{terminated("ws", preStopResultPrefix+"0\n")},
+			wantSucceeded: true,
+			wantDone:      true,
+		},
+		{
+			name:     "failed",
+			statuses: []corev1.ContainerStatus{terminated("ws", preStopResultPrefix+"1\ngit: not found\n")},
+			wantDone: true,
+		},
+		{
+			name:     "other container",
+			statuses: []corev1.ContainerStatus{terminated("docker", preStopResultPrefix+"0\n")},
+		},
+		{
+			name:     "no hook result",
+			statuses: []corev1.ContainerStatus{terminated("ws", "")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: tt.statuses}}
+			succeeded, _, done := preStopResult(pod, "ws")
+			if succeeded != tt.wantSucceeded || done != tt.wantDone {
+				t.Errorf("preStopResult() = %v, %v, want %v, %v", succeeded, done, tt.wantSucceeded, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestPostStartProbeReportsOnce(t *testing.T) {
+	script := postStartProbe(&v1.WorkSpaceLifecycle{PostStart: []string{"make deps"}}).Exec.Command[2]
+	// 钩子失败时探针只失败一次,之后通过
+	for _, want := range []string{"test -f " + hookStatusDir + "/postStart.reported && exit 0", "touch " + hookStatusDir + "/postStart.reported"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("probe does not contain %q:\n%s", want, script)
+		}
+	}
+	if postStartProbe(&v1.WorkSpaceLifecycle{PreStop: []string{"true"}}) != nil {
+		t.Error("probe added without a postStart hook")
+	}
+}
+
+// eventReader 按字段选择器过滤事件,fake client 不支持多个字段的选择器
+type eventReader struct {
+	client.Reader
+	events []corev1.Event
+}
+
+func (r eventReader) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	options := &client.ListOptions{}
+	options.ApplyOptions(opts)
+	events := list.(*corev1.EventList)
+	for _, event := range r.events {
+		set := fields.Set{
+			"involvedObject.uid":       string(event.InvolvedObject.UID),
+			"involvedObject.fieldPath": event.InvolvedObject.FieldPath,
+			"reason":                   event.Reason,
+		}
+		if options.FieldSelector == nil || options.FieldSelector.Matches(set) {
+			events.Items = append(events.Items, event)
+		}
+	}
+	return nil
+}
+
+func TestCheckPostStartHook(t *testing.T) {
+	conditionTrue, conditionFalse := metav1.ConditionTrue, metav1.ConditionFalse
+	unhealthy := func(container, message string) corev1.Event {
+		return corev1.Event{
+			InvolvedObject: corev1.ObjectReference{UID: "pod-uid", FieldPath: containerFieldPath(container)},
+			Reason:         reasonUnhealthy,
+			Message:        message,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		podName  string
+		statuses []corev1.ContainerStatus
+		events   []corev1.Event
+		// 之前记录的结果
+		current *metav1.Condition
+		want    *metav1.ConditionStatus
+	}{
+		{
+			name:     "workspace container ready",
+			statuses: []corev1.ContainerStatus{{Name: "ws", Ready: true}, {Name: "docker"}},
+			want:     &conditionTrue,
+		},
+		{
+			name:     "pool-claimed container ready",
+			podName:  "pool-go-abcde",
+			statuses: []corev1.ContainerStatus{{Name: "pool-go-abcde", Ready: true}, {Name: "docker"}},
+			want:     &conditionTrue,
+		},
+		{
+			name: "hook error",
+			statuses: []corev1.ContainerStatus{{Name: "ws", State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: reasonPostStartHookError, Message: "exec: /bin/sh: not found"},
+			}}},
+			want: &conditionFalse,
+		},
+		{
+			name:     "only sidecar ready",
+			statuses: []corev1.ContainerStatus{{Name: "ws"}, {Name: "docker", Ready: true}},
+		},
+		{
+			name:     "command failed",
+			statuses: []corev1.ContainerStatus{{Name: "ws", Ready: true}},
+			events:   []corev1.Event{unhealthy("ws", postStartResultPrefix+"2\nmake: *** No rule to make target 'deps'")},
+			want:     &conditionFalse,
+		},
+		{
+			name:     "pool-claimed command failed",
+			podName:  "pool-go-abcde",
+			statuses: []corev1.ContainerStatus{{Name: "pool-go-abcde"}},
+			events:   []corev1.Event{unhealthy("pool-go-abcde", postStartResultPrefix+"1\n")},
+			want:     &conditionFalse,
+		},
+		{
+			name:     "sidecar unhealthy",
+			statuses: []corev1.ContainerStatus{{Name: "ws", Ready: true}},
+			events:   []corev1.Event{unhealthy("docker", postStartResultPrefix+"1\n")},
+			want:     &conditionTrue,
+		},
+		{
+			// 失败事件过期后容器仍然就绪,失败的结果保留到下一次启动
+			name:     "failure kept",
+			statuses: []corev1.ContainerStatus{{Name: "ws", Ready: true}},
+			current:  &metav1.Condition{Type: ConditionPostStartHook, Status: metav1.ConditionFalse, Reason: "Failed", ObservedGeneration: 2},
+			want:     &conditionFalse,
+		},
+		{
+			name:     "failure from previous start",
+			statuses: []corev1.ContainerStatus{{Name: "ws", Ready: true}},
+			current:  &metav1.Condition{Type: ConditionPostStartHook, Status: metav1.ConditionFalse, Reason: "Failed", ObservedGeneration: 1},
+			want:     &conditionTrue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			space := &v1.WorkSpace{
+				ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default", Generation: 2},
+				Spec:       v1.WorkSpaceSpec{Lifecycle: &v1.WorkSpaceLifecycle{PostStart: []string{"make deps"}}},
+				Status:     v1.WorkSpaceStatus{PodName: tt.podName},
+			}
+			if tt.current != nil {
+				space.Status.Conditions = []metav1.Condition{*tt.current}
+			}
+			key := podKey(space)
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, UID: "pod-uid"}}
+			for _, status := range tt.statuses {
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: status.Name})
+			}
+			pod.Status.ContainerStatuses = tt.statuses
+			r := newTestReconciler(t, pod)
+			r.APIReader = eventReader{Reader: r.Client, events: tt.events}
+			r.checkPostStartHook(context.Background(), space)
+
+			condition := meta.FindStatusCondition(space.Status.Conditions, ConditionPostStartHook)
+			if tt.want == nil {
+				if condition != nil {
+					t.Errorf("condition = %+v, want none", condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != *tt.want {
+				t.Errorf("condition = %+v, want status %s", condition, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// NetworkPolicyConfig 工作空间网络隔离的配置
+type NetworkPolicyConfig struct {
+	// 是否为工作空间生成 NetworkPolicy
+	Enabled bool
+	// IDE 网关或者 Ingress Controller 所在的命名空间和 Pod 的标签,只允许它们访问工作空间
+	GatewayNamespaceSelector map[string]string
+	GatewayPodSelector       map[string]string
+}
+
+// constructNetworkPolicy 默认拒绝所有流量,入口只允许来自 IDE 网关,出口只允许 DNS 和模板中的白名单
+// 没有配置网关的选择器时拒绝所有入口流量,空的 NetworkPolicyPeer 是无效的
+func (r *WorkSpaceReconciler) constructNetworkPolicy(space *v1.WorkSpace, tpl *v1.WorkSpaceTemplate) *networkingv1.NetworkPolicy {
+	var ingress []networkingv1.NetworkPolicyIngressRule
+	gateway := networkingv1.NetworkPolicyPeer{}
+	if len(r.NetworkPolicy.GatewayNamespaceSelector) > 0 {
+		gateway.NamespaceSelector = &metav1.LabelSelector{MatchLabels: r.NetworkPolicy.GatewayNamespaceSelector}
+	}
+	if len(r.NetworkPolicy.GatewayPodSelector) > 0 {
+		gateway.PodSelector = &metav1.LabelSelector{MatchLabels: r.NetworkPolicy.GatewayPodSelector}
+	}
+	if gateway.NamespaceSelector != nil || gateway.PodSelector != nil {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{From: []networkingv1.NetworkPolicyPeer{gateway}})
+	}
+
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dnsPort := intstr.FromInt(53)
+	egress := []networkingv1.NetworkPolicyEgressRule{
+		{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dnsPort},
+				{Protocol: &tcp, Port: &dnsPort},
+			},
+		},
+	}
+	if tpl != nil {
+		egress = append(egress, tpl.Spec.EgressAllowList...)
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      space.Name,
+			Namespace: space.Namespace,
+			Labels: map[string]string{
+				"app":          "cloud-ide",
+				WorkSpaceLabel: space.Name,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{WorkSpaceLabel: space.Name},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     ingress,
+			Egress:      egress,
+		},
+	}
+	return policy
+}
+
+// reconcileNetworkPolicy 创建或者更新工作空间的 NetworkPolicy,NetworkPolicy 随工作空间一起删除
+func (r *WorkSpaceReconciler) reconcileNetworkPolicy(ctx context.Context, space *v1.WorkSpace, tpl *v1.WorkSpaceTemplate) error {
+	if !r.NetworkPolicy.Enabled {
+		return nil
+	}
+
+	return r.applyNetworkPolicy(ctx, space, r.constructNetworkPolicy(space, tpl))
+}
+
+// applyNetworkPolicy 把 NetworkPolicy 更新为期望的状态,NetworkPolicy 随 owner 一起删除
+func (r *WorkSpaceReconciler) applyNetworkPolicy(ctx context.Context, owner client.Object, desired *networkingv1.NetworkPolicy) error {
+	if err := controllerutil.SetControllerReference(owner, desired, r.Scheme); err != nil {
+		return err
+	}
+
+	policy := &networkingv1.NetworkPolicy{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(desired), policy); err != nil {
+		if errors.IsNotFound(err) {
+			return r.Client.Create(ctx, desired)
+		}
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(policy.Spec, desired.Spec) {
+		return nil
+	}
+	policy.Spec = desired.Spec
+	return r.Client.Update(ctx, policy)
+}
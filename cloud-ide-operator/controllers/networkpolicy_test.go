@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestConstructNetworkPolicy(t *testing.T) {
+	space := &v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default"}}
+	httpsPort := intstr.FromInt(443)
+	tpl := &v1.WorkSpaceTemplate{Spec: v1.WorkSpaceTemplateSpec{
+		EgressAllowList: []networkingv1.NetworkPolicyEgressRule{
+			{Ports: []networkingv1.NetworkPolicyPort{{Port: &httpsPort}}},
+		},
+	}}
+
+	tests := []struct {
+		name        string
+		config      NetworkPolicyConfig
+		tpl         *v1.WorkSpaceTemplate
+		wantIngress int
+		wantEgress  int
+	}{
+		{
+			name:        "namespace selector",
+			config:      NetworkPolicyConfig{GatewayNamespaceSelector: map[string]string{"name": "gateway"}},
+			wantIngress: 1,
+			wantEgress:  1,
+		},
+		{
+			name: "namespace and pod selector",
+			config: NetworkPolicyConfig{
+				GatewayNamespaceSelector: map[string]string{"name": "gateway"},
+				GatewayPodSelector:       map[string]string{"app": "gateway"},
+			},
+			tpl:         tpl,
+			wantIngress: 1,
+			wantEgress:  2,
+		},
+		{
+			name:        "no gateway",
+			tpl:         tpl,
+			wantIngress: 0,
+			wantEgress:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &WorkSpaceReconciler{NetworkPolicy: tt.config}
+			policy := r.constructNetworkPolicy(space, tt.tpl)
+
+			if selector := policy.Spec.PodSelector.MatchLabels; selector[WorkSpaceLabel] != space.Name {
+				t.Errorf("pod selector = %v", selector)
+			}
+			if len(policy.Spec.PolicyTypes) != 2 {
+				t.Errorf("policy types = %v, want ingress and egress", policy.Spec.PolicyTypes)
+			}
+			if len(policy.Spec.Ingress) != tt.wantIngress {
+				t.Fatalf("got %d ingress rules, want %d", len(policy.Spec.Ingress), tt.wantIngress)
+			}
+			for _, rule := range policy.Spec.Ingress {
+				for _, peer := range rule.From {
+					if peer.NamespaceSelector == nil && peer.PodSelector == nil && peer.IPBlock == nil {
+						t.Error("ingress rule has an empty peer")
+					}
+				}
+			}
+			if len(policy.Spec.Egress) != tt.wantEgress {
+				t.Errorf("got %d egress rules, want %d", len(policy.Spec.Egress), tt.wantEgress)
+			}
+		})
+	}
+}
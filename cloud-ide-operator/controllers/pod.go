@@ -2,25 +2,27 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	v1 "github.com/costa92/cloud-ide-operator/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"time"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // 检查pod是否存在
-func (r *WorkSpaceReconciler) checkPodExist(key client.ObjectKey) (bool, error) {
+func (r *WorkSpaceReconciler) checkPodExist(ctx context.Context, key client.ObjectKey) (bool, error) {
 	pod := &corev1.Pod{}
 	// 先查询一下
-	err := r.Client.Get(context.Background(), key, pod)
+	err := r.Client.Get(ctx, key, pod)
 	if err != nil {
 		// 判断是否存在
 		if errors.IsNotFound(err) {
-			return false, err
+			return false, nil
 		}
 		klog.Errorf("get pod error:%v", err)
 		return false, err
@@ -29,8 +31,8 @@ func (r *WorkSpaceReconciler) checkPodExist(key client.ObjectKey) (bool, error)
 }
 
 // 删除 pod
-func (r *WorkSpaceReconciler) deletePod(key client.ObjectKey) error {
-	exist, err := r.checkPodExist(key)
+func (r *WorkSpaceReconciler) deletePod(ctx context.Context, key client.ObjectKey) error {
+	exist, err := r.checkPodExist(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -42,9 +44,6 @@ func (r *WorkSpaceReconciler) deletePod(key client.ObjectKey) error {
 	pod.Name = key.Name           // 名字
 	pod.Namespace = key.Namespace // 空间
 
-	ctx, cancelFuc := context.WithTimeout(context.Background(), time.Second*35)
-	defer cancelFuc()
-
 	// 删除
 	err = r.Client.Delete(ctx, pod)
 	if err != nil {
@@ -65,10 +64,11 @@ func (r *WorkSpaceReconciler) constructPod(space *v1.WorkSpace) *corev1.Pod {
 			APIVersion: "v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      space.Name,
+			Name:      podKey(space).Name,
 			Namespace: space.Namespace,
 			Labels: map[string]string{
-				"app": "cloud-ide",
+				"app":          "cloud-ide",
+				WorkSpaceLabel: space.Name,
 			},
 		},
 
@@ -78,7 +78,7 @@ func (r *WorkSpaceReconciler) constructPod(space *v1.WorkSpace) *corev1.Pod {
 					Name: volumeName,
 					VolumeSource: corev1.VolumeSource{
 						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: space.Name,
+							ClaimName: claimKey(space).Name,
 							ReadOnly:  false,
 						},
 					},
@@ -102,11 +102,124 @@ func (r *WorkSpaceReconciler) constructPod(space *v1.WorkSpace) *corev1.Pod {
 							MountPath: space.Spec.MountPath,
 						},
 					},
+					Env:            space.Spec.Env,
+					EnvFrom:        space.Spec.EnvFrom,
+					Lifecycle:      constructLifecycle(space.Spec.Lifecycle),
+					ReadinessProbe: postStartProbe(space.Spec.Lifecycle),
 				},
 			},
 		},
 	}
 
+	container := &pod.Spec.Containers[0]
+	for _, p := range space.Spec.Ports {
+		container.Ports = append(container.Ports, corev1.ContainerPort{Name: p.Name, ContainerPort: p.Port})
+	}
+	if isEphemeral(space) {
+		// 临时存储的工作空间使用 emptyDir,大小不超过 storage
+		emptyDir := &corev1.EmptyDirVolumeSource{}
+		if quantity, err := resource.ParseQuantity(space.Spec.Storage); err == nil {
+			emptyDir.SizeLimit = &quantity
+		}
+		pod.Spec.Volumes[0].VolumeSource = corev1.VolumeSource{EmptyDir: emptyDir}
+	} else if isBlockVolume(space) {
+		// 块设备不能挂载为目录,以设备的形式提供给容器
+		container.VolumeMounts = nil
+		container.VolumeDevices = []corev1.VolumeDevice{
+			{
+				Name:       volumeName,
+				DevicePath: space.Spec.MountPath,
+			},
+		}
+	}
+
+	// 只读挂载共享卷
+	for _, sv := range space.Spec.SharedVolumes {
+		name := "shared-" + sv.Name
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: sv.ClaimName,
+					ReadOnly:  true,
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      name,
+			ReadOnly:  true,
+			MountPath: sv.MountPath,
+			SubPath:   sv.SubPath,
+		})
+	}
+
+	// 以文件形式挂载 Secret
+	for i, sm := range space.Spec.SecretMounts {
+		name := fmt.Sprintf("secret-%d", i)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  sm.SecretName,
+					DefaultMode: pointer.Int32(0400),
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      name,
+			ReadOnly:  true,
+			MountPath: sm.MountPath,
+		})
+	}
+
+	// 调度约束
+	scheduling := space.Spec.WorkSpaceScheduling
+	pod.Spec.NodeSelector = scheduling.NodeSelector
+	pod.Spec.Tolerations = scheduling.Tolerations
+	pod.Spec.PriorityClassName = scheduling.PriorityClassName
+	pod.Spec.TopologySpreadConstraints = scheduling.TopologySpreadConstraints
+	pod.Spec.Affinity = &corev1.Affinity{}
+	if scheduling.Affinity != nil {
+		pod.Spec.Affinity = scheduling.Affinity.DeepCopy()
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+
+	// 优先调度到已经预拉取了镜像的节点上
+	nodeAffinity := pod.Spec.Affinity.NodeAffinity
+	nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		corev1.PreferredSchedulingTerm{
+			Weight: 100,
+			Preference: corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{
+					{
+						Key:      PrePullLabel(space.Spec.Image),
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"true"},
+					},
+				},
+			},
+		})
+
+	// 启动前把 dotfiles 放入存储卷,块设备上没有文件系统,不支持 dotfiles
+	if space.Spec.Dotfiles != nil && !isBlockVolume(space) {
+		addDotfiles(pod, space, volumeName)
+	}
+
+	// 伴随服务和工作空间运行在同一个 Pod 中
+	if len(space.Spec.Companions) > 0 {
+		addCompanions(pod, space, volumeName)
+	}
+
+	// 在工作空间中构建容器
+	if space.Spec.Docker != nil {
+		addDocker(pod, space)
+	}
+
+	// 安全配置档需要作用到所有容器上,放在最后设置
+	applySecurityProfile(pod, space)
+
 	if Mode == ModelRelease {
 
 		pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
@@ -115,17 +228,24 @@ func (r *WorkSpaceReconciler) constructPod(space *v1.WorkSpace) *corev1.Pod {
 				corev1.ResourceMemory: resource.MustParse("1Gi"),
 			},
 
-			Limits: map[corev1.ResourceName]resource.Quantity{
-				corev1.ResourceCPU:    resource.MustParse(space.Spec.Cpu),
-				corev1.ResourceMemory: resource.MustParse(space.Spec.Memory),
-			},
+			Limits: limitResources(space.Spec.Cpu, space.Spec.Memory).Limits,
 		}
 	}
 	return pod
 }
-func (r *WorkSpaceReconciler) createPod(space *v1.WorkSpace, key client.ObjectKey) error {
+
+// 工作空间是否使用临时存储
+func isEphemeral(space *v1.WorkSpace) bool {
+	return space.Spec.Ephemeral != nil && *space.Spec.Ephemeral
+}
+
+func isBlockVolume(space *v1.WorkSpace) bool {
+	return !isEphemeral(space) && space.Spec.VolumeMode != nil && *space.Spec.VolumeMode == corev1.PersistentVolumeBlock
+}
+
+func (r *WorkSpaceReconciler) createPod(ctx context.Context, space *v1.WorkSpace, key client.ObjectKey) error {
 	// 1.检查Pod是否存在
-	exist, err := r.checkPodExist(key)
+	exist, err := r.checkPodExist(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -139,12 +259,10 @@ func (r *WorkSpaceReconciler) createPod(space *v1.WorkSpace, key client.ObjectKe
 	pod := r.constructPod(space)
 
 	// 设置控制器，如果设置了控制器,那么被控制的资源的变化也会被发送到队列中
-	//if err = controllerutil.SetControllerReference(space, pod, r.Scheme); err != nil {
-	//	return err
-	//}
+	if err = controllerutil.SetControllerReference(space, pod, r.Scheme); err != nil {
+		return err
+	}
 
-	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelFunc()
 	err = r.Client.Create(ctx, pod)
 	if err != nil {
 		// 如果Pod已经存在,直接返回
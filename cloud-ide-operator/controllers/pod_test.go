@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPodWorkSpace() *v1.WorkSpace {
+	return &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default"},
+		Spec:       v1.WorkSpaceSpec{Image: "code-server", Port: 8080, MountPath: "/home/coder"},
+	}
+}
+
+func TestConstructPodLimits(t *testing.T) {
+	mode := Mode
+	Mode = ModelRelease
+	defer func() { Mode = mode }()
+
+	tests := []struct {
+		name   string
+		cpu    string
+		memory string
+		want   corev1.ResourceList
+	}{
+		{
+			name:   "both set",
+			cpu:    "4",
+			memory: "8Gi",
+			want: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("4"),
+				corev1.ResourceMemory: resource.MustParse("8Gi"),
+			},
+		},
+		{
+			name: "unset",
+		},
+		{
+			name:   "invalid cpu",
+			cpu:    "four",
+			memory: "8Gi",
+			want:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			space := &v1.WorkSpace{
+				ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default"},
+				Spec:       v1.WorkSpaceSpec{Cpu: tt.cpu, Memory: tt.memory, Image: "code-server", MountPath: "/home/coder"},
+			}
+			got := (&WorkSpaceReconciler{}).constructPod(space).Spec.Containers[0].Resources.Limits
+			if len(got) != len(tt.want) {
+				t.Fatalf("limits = %v, want %v", got, tt.want)
+			}
+			for name, quantity := range tt.want {
+				if q, ok := got[name]; !ok || q.Cmp(quantity) != 0 {
+					t.Errorf("limit %s = %v, want %v", name, got[name], quantity)
+				}
+			}
+		})
+	}
+}
+
+func TestConstructPodEnv(t *testing.T) {
+	envFrom := []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "tokens"}}},
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}},
+	}
+	tests := []struct {
+		name   string
+		env    []corev1.EnvVar
+		docker *v1.WorkSpaceDocker
+		want   []corev1.EnvVar
+	}{
+		{
+			name: "user env",
+			env:  []corev1.EnvVar{{Name: "GOPROXY", Value: "direct"}, {Name: "TZ", Value: "Asia/Shanghai"}},
+			want: []corev1.EnvVar{{Name: "GOPROXY", Value: "direct"}, {Name: "TZ", Value: "Asia/Shanghai"}},
+		},
+		{
+			name:   "docker host appended",
+			env:    []corev1.EnvVar{{Name: "GOPROXY", Value: "direct"}},
+			docker: &v1.WorkSpaceDocker{},
+			want: []corev1.EnvVar{
+				{Name: "GOPROXY", Value: "direct"},
+				{Name: "DOCKER_HOST", Value: "unix://" + dockerSocketPath + "/docker.sock"},
+			},
+		},
+		{
+			name:   "user docker host wins",
+			env:    []corev1.EnvVar{{Name: "DOCKER_HOST", Value: "tcp://docker:2375"}},
+			docker: &v1.WorkSpaceDocker{},
+			want:   []corev1.EnvVar{{Name: "DOCKER_HOST", Value: "tcp://docker:2375"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			space := newPodWorkSpace()
+			space.Spec.Env = tt.env
+			space.Spec.EnvFrom = envFrom
+			space.Spec.Docker = tt.docker
+			c := (&WorkSpaceReconciler{}).constructPod(space).Spec.Containers[0]
+			if !equality.Semantic.DeepEqual(c.Env, tt.want) {
+				t.Errorf("env = %v, want %v", c.Env, tt.want)
+			}
+			if !equality.Semantic.DeepEqual(c.EnvFrom, envFrom) {
+				t.Errorf("envFrom = %v, want %v", c.EnvFrom, envFrom)
+			}
+		})
+	}
+}
+
+func TestConstructPodSecretMounts(t *testing.T) {
+	space := newPodWorkSpace()
+	space.Spec.SecretMounts = []v1.SecretMount{
+		{SecretName: "ssh", MountPath: "/home/coder/.ssh"},
+		{SecretName: "kubeconfig", MountPath: "/home/coder/.kube"},
+	}
+	pod := (&WorkSpaceReconciler{}).constructPod(space)
+
+	for i, sm := range space.Spec.SecretMounts {
+		name := fmt.Sprintf("secret-%d", i)
+		var volume *corev1.Volume
+		for j := range pod.Spec.Volumes {
+			if pod.Spec.Volumes[j].Name == name {
+				volume = &pod.Spec.Volumes[j]
+			}
+		}
+		if volume == nil || volume.Secret == nil {
+			t.Fatalf("secret volume %s not found in %+v", name, pod.Spec.Volumes)
+		}
+		if volume.Secret.SecretName != sm.SecretName || volume.Secret.DefaultMode == nil || *volume.Secret.DefaultMode != 0400 {
+			t.Errorf("volume %s = %+v, want secret %s with mode 0400", name, volume.Secret, sm.SecretName)
+		}
+
+		found := false
+		for _, m := range pod.Spec.Containers[0].VolumeMounts {
+			if m.Name == name {
+				found = true
+				if m.MountPath != sm.MountPath || !m.ReadOnly {
+					t.Errorf("mount %s = %+v, want read only at %s", name, m, sm.MountPath)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("secret %s not mounted into the workspace container", sm.SecretName)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// PoolLabel 预热池中的 Pod 和 PVC 上记录所属模板名字的标签
+	PoolLabel = "apps.costalong.com/pool"
+	// PoolStateLabel 预热池中的 Pod 和 PVC 的状态,空闲或者已经被领取
+	PoolStateLabel   = "apps.costalong.com/pool-state"
+	PoolStateIdle    = "idle"
+	PoolStateClaimed = "claimed"
+	// PoolGenerationAnnotation 创建预热 Pod 时模板的 generation,模板变化后旧的空闲 Pod 会被替换
+	PoolGenerationAnnotation = "apps.costalong.com/template-generation"
+
+	// 比较工作空间和预热池中的 Pod 是否一致时使用的名字
+	poolProbeName = "pool-probe"
+)
+
+// poolKey 预热池的 NetworkPolicy 使用的名字
+func poolKey(tpl *v1.WorkSpaceTemplate) client.ObjectKey {
+	return client.ObjectKey{Name: tpl.Name + "-pool", Namespace: tpl.Namespace}
+}
+
+// newPoolWorkSpace 根据模板构造预热池中使用的工作空间,用来生成预热的 Pod 和 PVC
+func newPoolWorkSpace(tpl *v1.WorkSpaceTemplate, name string) *v1.WorkSpace {
+	space := &v1.WorkSpace{}
+	space.Name = name
+	space.Namespace = tpl.Namespace
+	space.Spec.Template = tpl.Name
+	space.Spec.Operation = v1.WorkSpaceStart
+	mergeTemplate(&space.Spec, &tpl.Spec)
+	return space
+}
+
+// 只有工作空间生成的 Pod 和 PVC 与预热池中的完全一致时才能领取,
+// 例如设置了环境变量或者 dotfiles 的工作空间仍然需要单独创建
+func (r *WorkSpaceReconciler) poolCompatible(ctx context.Context, space *v1.WorkSpace, tpl *v1.WorkSpaceTemplate) bool {
+	// 预热池中的 Pod 已经在运行,无法在启动前从备份中恢复存储卷
+	if space.Spec.RestoreFrom != nil {
+		return false
+	}
+
+	probe := space.DeepCopy()
+	probe.Name = poolProbeName
+	probe.Status.PodName = ""
+	probe.Status.ClaimName = ""
+	pool := newPoolWorkSpace(tpl, poolProbeName)
+	if err := applyHardwareClass(ctx, r.Client, &pool.Spec); err != nil {
+		return false
+	}
+
+	if !equality.Semantic.DeepEqual(r.constructPod(probe).Spec, r.constructPod(pool).Spec) {
+		return false
+	}
+
+	probePVC, err := r.constructPVC(probe)
+	if err != nil {
+		return false
+	}
+	poolPVC, err := r.constructPVC(pool)
+	if err != nil {
+		return false
+	}
+	return equality.Semantic.DeepEqual(probePVC.Spec, poolPVC.Spec)
+}
+
+// claimFromPool 第一次启动工作空间时,从模板的预热池中领取一个已经就绪的 Pod 和 PVC,
+// 领取时修改它们的标签和 OwnerReference,并把名字记录到工作空间的状态中
+func (r *WorkSpaceReconciler) claimFromPool(ctx context.Context, space *v1.WorkSpace, tpl *v1.WorkSpaceTemplate) error {
+	if tpl == nil || tpl.Spec.PoolSize == 0 || space.Status.PodName != "" {
+		return nil
+	}
+
+	// 已经有自己的 PVC,说明不是第一次启动
+	exist, err := r.checkPVCExist(ctx, claimKey(space))
+	if err != nil || exist {
+		return err
+	}
+
+	// 上一次已经领取成功,但是状态没有写回
+	claimed := &corev1.PodList{}
+	if err := r.Client.List(ctx, claimed, client.InNamespace(space.Namespace),
+		client.MatchingLabels{PoolLabel: tpl.Name, WorkSpaceLabel: space.Name}); err != nil {
+		return err
+	}
+	if len(claimed.Items) > 0 {
+		return r.recordClaim(ctx, space, claimed.Items[0].Name)
+	}
+
+	if !r.poolCompatible(ctx, space, tpl) {
+		return nil
+	}
+
+	idle := &corev1.PodList{}
+	if err := r.Client.List(ctx, idle, client.InNamespace(space.Namespace),
+		client.MatchingLabels{PoolLabel: tpl.Name, PoolStateLabel: PoolStateIdle}); err != nil {
+		return err
+	}
+
+	for i := range idle.Items {
+		pod := &idle.Items[i]
+		if pod.DeletionTimestamp != nil || !isPodReady(pod) {
+			continue
+		}
+
+		// 使用 resourceVersion 做乐观锁,同一个 Pod 只会被一个工作空间领取成功
+		if err := r.claimObject(ctx, space, pod); err != nil {
+			if errors.IsConflict(err) || errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		// PVC 和 Pod 同名,Pod 已经领取成功后 PVC 不会再被其他工作空间领取
+		if !isEphemeral(space) {
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				pvc := &corev1.PersistentVolumeClaim{}
+				if err := r.Client.Get(ctx, client.ObjectKeyFromObject(pod), pvc); err != nil {
+					return err
+				}
+				return r.claimObject(ctx, space, pvc)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		klog.Infof("workspace %s/%s claimed %s from pool %s", space.Namespace, space.Name, pod.Name, tpl.Name)
+		return r.recordClaim(ctx, space, pod.Name)
+	}
+	return nil
+}
+
+// reconcilePoolNetworkPolicy 空闲的预热 Pod 还没有工作空间的标签,使用模板的 NetworkPolicy 隔离,
+// 规则和工作空间的 NetworkPolicy 相同
+func (r *WorkSpaceReconciler) reconcilePoolNetworkPolicy(ctx context.Context, tpl *v1.WorkSpaceTemplate) error {
+	if !r.NetworkPolicy.Enabled {
+		return nil
+	}
+
+	desired := r.constructNetworkPolicy(newPoolWorkSpace(tpl, poolKey(tpl).Name), tpl)
+	desired.Labels = map[string]string{
+		"app":     "cloud-ide",
+		PoolLabel: tpl.Name,
+	}
+	desired.Spec.PodSelector = metav1.LabelSelector{
+		MatchLabels: map[string]string{PoolLabel: tpl.Name, PoolStateLabel: PoolStateIdle},
+	}
+	return r.applyNetworkPolicy(ctx, tpl, desired)
+}
+
+func (r *WorkSpaceReconciler) claimObject(ctx context.Context, space *v1.WorkSpace, object client.Object) error {
+	labels := object.GetLabels()
+	labels[PoolStateLabel] = PoolStateClaimed
+	labels[WorkSpaceLabel] = space.Name
+	object.SetLabels(labels)
+	object.SetOwnerReferences(nil)
+	if err := ctrl.SetControllerReference(space, object, r.Scheme); err != nil {
+		return err
+	}
+
+	return r.Client.Update(ctx, object)
+}
+
+// 更新状态时使用副本,避免服务端返回的对象覆盖内存中已经合并了模板的 spec
+func (r *WorkSpaceReconciler) recordClaim(ctx context.Context, space *v1.WorkSpace, name string) error {
+	latest := space.DeepCopy()
+	latest.Status.PodName = name
+	latest.Status.ClaimName = name
+	if err := r.Client.Status().Update(ctx, latest); err != nil {
+		return err
+	}
+	space.Status = latest.Status
+	space.ResourceVersion = latest.ResourceVersion
+	return nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// WarmPoolReconciler 为设置了 poolSize 的模板维护一组预先启动的空闲工作空间,
+// 空闲的 Pod 被工作空间领取后会在后台补充
+type WarmPoolReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// 分片选择器,和 WorkSpaceReconciler 使用相同的选择器
+	Shard labels.Selector
+	// 预热的 Pod 和工作空间使用相同的网络隔离配置
+	NetworkPolicy NetworkPolicyConfig
+}
+
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacetemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacetemplates/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=hardwareclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+
+func (r *WarmPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	tpl := &v1.WorkSpaceTemplate{}
+	if err := r.Client.Get(ctx, req.NamespacedName, tpl); err != nil {
+		// 模板被删除后,预热池中的 Pod 和 PVC 会被垃圾回收
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		klog.Errorf("[Pool] get workspace template error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods, client.InNamespace(tpl.Namespace),
+		client.MatchingLabels{PoolLabel: tpl.Name, PoolStateLabel: PoolStateIdle}); err != nil {
+		klog.Errorf("[Pool] list pool pods error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	// 模板变化之前创建的空闲 Pod 已经过期,需要替换
+	generation := strconv.FormatInt(tpl.Generation, 10)
+	var current []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if pod.Annotations[PoolGenerationAnnotation] != generation {
+			if err := r.deleteEntry(ctx, client.ObjectKeyFromObject(&pod)); err != nil {
+				klog.Errorf("[Pool] delete stale pool entry error:%v", err)
+				return ctrl.Result{Requeue: true}, err
+			}
+			continue
+		}
+		current = append(current, pod)
+	}
+
+	// 缩容
+	size := int(tpl.Spec.PoolSize)
+	for len(current) > size {
+		last := current[len(current)-1]
+		if err := r.deleteEntry(ctx, client.ObjectKeyFromObject(&last)); err != nil {
+			klog.Errorf("[Pool] delete pool entry error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		current = current[:len(current)-1]
+	}
+
+	// 补充
+	allowed, err := r.preparePool(ctx, tpl)
+	if err != nil {
+		klog.Errorf("[Pool] prepare pool error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+	for i := len(current); allowed && i < size; i++ {
+		if err := r.createEntry(ctx, tpl, generation); err != nil {
+			klog.Errorf("[Pool] create pool entry error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+	}
+
+	var ready int32
+	for i := range current {
+		if isPodReady(&current[i]) {
+			ready++
+		}
+	}
+	if tpl.Status.PoolReady != ready {
+		tpl.Status.PoolReady = ready
+		if err := r.Client.Status().Update(ctx, tpl); err != nil {
+			klog.Errorf("[Pool] update template status error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *WarmPoolReconciler) workspaceReconciler() *WorkSpaceReconciler {
+	return &WorkSpaceReconciler{Client: r.Client, Scheme: r.Scheme, NetworkPolicy: r.NetworkPolicy}
+}
+
+// preparePool 预热的 Pod 和工作空间的 Pod 一样,需要先检查命名空间是否允许模板的安全配置档,并隔离网络
+// 不允许时不创建预热的 Pod
+func (r *WarmPoolReconciler) preparePool(ctx context.Context, tpl *v1.WorkSpaceTemplate) (bool, error) {
+	if tpl.Spec.PoolSize == 0 {
+		return false, nil
+	}
+
+	ws := r.workspaceReconciler()
+	space := newPoolWorkSpace(tpl, poolKey(tpl).Name)
+	allowed, err := ws.checkSecurityProfile(ctx, space)
+	if err != nil {
+		return false, err
+	}
+	if !allowed {
+		if condition := meta.FindStatusCondition(space.Status.Conditions, ConditionSecurityProfile); condition != nil {
+			klog.Warningf("[Pool] skip pool of template %s/%s: %s", tpl.Namespace, tpl.Name, condition.Message)
+		}
+		return false, nil
+	}
+	return true, ws.reconcilePoolNetworkPolicy(ctx, tpl)
+}
+
+// 预热池中的每一项由同名的 PVC 和 Pod 组成,使用临时存储的模板只有 Pod
+// 创建 Pod 失败时删除已经创建的 PVC,避免 PVC 泄漏
+func (r *WarmPoolReconciler) createEntry(ctx context.Context, tpl *v1.WorkSpaceTemplate, generation string) error {
+	ws := r.workspaceReconciler()
+	space := newPoolWorkSpace(tpl, fmt.Sprintf("%s-pool-%s", tpl.Name, utilrand.String(5)))
+	if err := applyHardwareClass(ctx, r.Client, &space.Spec); err != nil {
+		return err
+	}
+
+	if !isEphemeral(space) {
+		pvc, err := ws.constructPVC(space)
+		if err != nil {
+			return err
+		}
+		if err := r.markPoolObject(tpl, pvc, generation); err != nil {
+			return err
+		}
+		if err := r.Client.Create(ctx, pvc); err != nil {
+			return err
+		}
+	}
+
+	pod := ws.constructPod(space)
+	delete(pod.Labels, WorkSpaceLabel)
+	err := r.markPoolObject(tpl, pod, generation)
+	if err == nil {
+		err = r.Client.Create(ctx, pod)
+	}
+	if err != nil {
+		if e := r.deleteEntry(ctx, client.ObjectKeyFromObject(pod)); e != nil {
+			klog.Errorf("[Pool] delete pool entry %s/%s error:%v", pod.Namespace, pod.Name, e)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *WarmPoolReconciler) markPoolObject(tpl *v1.WorkSpaceTemplate, object client.Object, generation string) error {
+	labels := object.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[PoolLabel] = tpl.Name
+	labels[PoolStateLabel] = PoolStateIdle
+	object.SetLabels(labels)
+	object.SetAnnotations(map[string]string{PoolGenerationAnnotation: generation})
+	return ctrl.SetControllerReference(tpl, object, r.Scheme)
+}
+
+func (r *WarmPoolReconciler) deleteEntry(ctx context.Context, key client.ObjectKey) error {
+	pod := &corev1.Pod{}
+	pod.Name = key.Name
+	pod.Namespace = key.Namespace
+	if err := r.Client.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Name = key.Name
+	pvc.Namespace = key.Namespace
+	if err := r.Client.Delete(ctx, pvc); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WarmPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	shard, err := shardPredicate(mgr, r.Shard)
+	if err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		WithEventFilter(shard).
+		Named("warmpool").
+		For(&v1.WorkSpaceTemplate{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.Pod{}).
+		Owns(&networkingv1.NetworkPolicy{}, builder.WithPredicates(predicateOwnedDeleted)).
+		Complete(r)
+}
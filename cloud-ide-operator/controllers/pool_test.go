@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newTestReconciler(t *testing.T, objects ...client.Object) *WorkSpaceReconciler {
+	t.Helper()
+	scheme := newTestScheme(t)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	return &WorkSpaceReconciler{Client: c, Scheme: scheme}
+}
+
+func newTestTemplate() *v1.WorkSpaceTemplate {
+	return &v1.WorkSpaceTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "go", Namespace: "default", UID: "tpl-uid"},
+		Spec: v1.WorkSpaceTemplateSpec{
+			Cpu:       "2",
+			Memory:    "4Gi",
+			Storage:   "10Gi",
+			Image:     "code-server:go",
+			Port:      8080,
+			MountPath: "/home/coder",
+			PoolSize:  1,
+		},
+	}
+}
+
+func newTemplateWorkSpace(tpl *v1.WorkSpaceTemplate, name string) *v1.WorkSpace {
+	space := &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: tpl.Namespace, UID: "ws-uid"},
+		Spec:       v1.WorkSpaceSpec{Template: tpl.Name, Operation: v1.WorkSpaceStart},
+	}
+	mergeTemplate(&space.Spec, &tpl.Spec)
+	return space
+}
+
+func newPoolEntry(tpl *v1.WorkSpaceTemplate, name string, ready bool) (*corev1.Pod, *corev1.PersistentVolumeClaim) {
+	labels := map[string]string{PoolLabel: tpl.Name, PoolStateLabel: PoolStateIdle}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: tpl.Namespace, Labels: labels}}
+	pod.Status.Phase = corev1.PodRunning
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: tpl.Namespace, Labels: map[string]string{
+		PoolLabel: tpl.Name, PoolStateLabel: PoolStateIdle,
+	}}}
+	return pod, pvc
+}
+
+func TestPoolCompatible(t *testing.T) {
+	tpl := newTestTemplate()
+	tests := []struct {
+		name   string
+		modify func(space *v1.WorkSpace)
+		want   bool
+	}{
+		{
+			name:   "same as template",
+			modify: func(*v1.WorkSpace) {},
+			want:   true,
+		},
+		{
+			name: "env",
+			modify: func(space *v1.WorkSpace) {
+				space.Spec.Env = []corev1.EnvVar{{Name: "FOO", Value: "bar"}}
+			},
+			want: false,
+		},
+		{
+			name: "image",
+			modify: func(space *v1.WorkSpace) {
+				space.Spec.Image = "code-server:python"
+			},
+			want: false,
+		},
+		{
+			name: "storage",
+			modify: func(space *v1.WorkSpace) {
+				space.Spec.Storage = "20Gi"
+			},
+			want: false,
+		},
+		{
+			name: "restore from backup",
+			modify: func(space *v1.WorkSpace) {
+				space.Spec.RestoreFrom = &v1.BackupRestore{}
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(t)
+			space := newTemplateWorkSpace(tpl, "ws")
+			tt.modify(space)
+			if got := r.poolCompatible(context.Background(), space, tpl); got != tt.want {
+				t.Errorf("poolCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimFromPool(t *testing.T) {
+	tpl := newTestTemplate()
+	notReadyPod, notReadyPVC := newPoolEntry(tpl, "go-pool-aaaaa", false)
+	readyPod, readyPVC := newPoolEntry(tpl, "go-pool-bbbbb", true)
+	space := newTemplateWorkSpace(tpl, "ws")
+
+	r := newTestReconciler(t, tpl, space.DeepCopy(), notReadyPod, notReadyPVC, readyPod, readyPVC)
+	ctx := context.Background()
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(space), space); err != nil {
+		t.Fatal(err)
+	}
+	mergeTemplate(&space.Spec, &tpl.Spec)
+	if err := r.claimFromPool(ctx, space, tpl); err != nil {
+		t.Fatal(err)
+	}
+
+	if space.Status.PodName != readyPod.Name || space.Status.ClaimName != readyPVC.Name {
+		t.Fatalf("claimed pod %q and pvc %q, want %q", space.Status.PodName, space.Status.ClaimName, readyPod.Name)
+	}
+
+	for _, object := range []client.Object{&corev1.Pod{}, &corev1.PersistentVolumeClaim{}} {
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(readyPod), object); err != nil {
+			t.Fatal(err)
+		}
+		if state := object.GetLabels()[PoolStateLabel]; state != PoolStateClaimed {
+			t.Errorf("%T pool state = %q, want %q", object, state, PoolStateClaimed)
+		}
+		if owner := metav1.GetControllerOf(object); owner == nil || owner.UID != space.UID {
+			t.Errorf("%T controller = %v, want workspace", object, owner)
+		}
+	}
+
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(notReadyPod), pod); err != nil {
+		t.Fatal(err)
+	}
+	if state := pod.Labels[PoolStateLabel]; state != PoolStateIdle {
+		t.Errorf("not ready pod pool state = %q, want %q", state, PoolStateIdle)
+	}
+}
+
+func TestClaimFromPoolSkipsExistingPVC(t *testing.T) {
+	tpl := newTestTemplate()
+	readyPod, readyPVC := newPoolEntry(tpl, "go-pool-bbbbb", true)
+	space := newTemplateWorkSpace(tpl, "ws")
+	own := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: space.Name, Namespace: space.Namespace}}
+
+	r := newTestReconciler(t, tpl, space.DeepCopy(), readyPod, readyPVC, own)
+	if err := r.claimFromPool(context.Background(), space, tpl); err != nil {
+		t.Fatal(err)
+	}
+	if space.Status.PodName != "" {
+		t.Errorf("workspace with its own pvc claimed %q", space.Status.PodName)
+	}
+}
+
+// 创建 Pod 总是失败的客户端
+type failPodClient struct {
+	client.Client
+}
+
+func (c failPodClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*corev1.Pod); ok {
+		return fmt.Errorf("create pod failed")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestCreateEntryDeletesPVCOnPodFailure(t *testing.T) {
+	tpl := newTestTemplate()
+	ws := newTestReconciler(t, tpl)
+	r := &WarmPoolReconciler{Client: failPodClient{ws.Client}, Scheme: ws.Scheme}
+
+	ctx := context.Background()
+	if err := r.createEntry(ctx, tpl, "1"); err == nil {
+		t.Fatal("createEntry() succeeded, want error")
+	}
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := ws.Client.List(ctx, pvcs); err != nil {
+		t.Fatal(err)
+	}
+	if len(pvcs.Items) != 0 {
+		t.Errorf("%d pvc left after pod creation failed", len(pvcs.Items))
+	}
+}
+
+func TestPreparePool(t *testing.T) {
+	tests := []struct {
+		name          string
+		enforce       string
+		profile       v1.SecurityProfile
+		wantAllowed   bool
+		wantPolicyNum int
+	}{
+		{
+			name:          "allowed",
+			enforce:       "baseline",
+			profile:       v1.SecurityProfileBaseline,
+			wantAllowed:   true,
+			wantPolicyNum: 1,
+		},
+		{
+			name:          "forbidden by namespace",
+			enforce:       "baseline",
+			profile:       v1.SecurityProfilePrivilegedForDocker,
+			wantAllowed:   false,
+			wantPolicyNum: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := newTestTemplate()
+			tpl.Spec.SecurityProfile = tt.profile
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   tpl.Namespace,
+				Labels: map[string]string{podSecurityEnforceLabel: tt.enforce},
+			}}
+			ws := newTestReconciler(t, tpl, ns)
+			r := &WarmPoolReconciler{Client: ws.Client, Scheme: ws.Scheme, NetworkPolicy: NetworkPolicyConfig{Enabled: true}}
+
+			ctx := context.Background()
+			allowed, err := r.preparePool(ctx, tpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != tt.wantAllowed {
+				t.Errorf("preparePool() = %v, want %v", allowed, tt.wantAllowed)
+			}
+
+			policies := &networkingv1.NetworkPolicyList{}
+			if err := ws.Client.List(ctx, policies); err != nil {
+				t.Fatal(err)
+			}
+			if len(policies.Items) != tt.wantPolicyNum {
+				t.Fatalf("got %d network policies, want %d", len(policies.Items), tt.wantPolicyNum)
+			}
+			if tt.wantPolicyNum > 0 {
+				selector := policies.Items[0].Spec.PodSelector.MatchLabels
+				if selector[PoolLabel] != tpl.Name || selector[PoolStateLabel] != PoolStateIdle {
+					t.Errorf("pool network policy selects %v", selector)
+				}
+			}
+		})
+	}
+}
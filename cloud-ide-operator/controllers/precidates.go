@@ -1,7 +1,11 @@
 package controllers
 
 import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
@@ -10,6 +14,54 @@ var predicatePVC = predicate.NewPredicateFuncs(func(object client.Object) bool {
 	return false
 })
 
-var predicatePod = predicate.NewPredicateFuncs(func(object client.Object) bool {
-	return false
-})
+// 只有容器重启或者等待原因变化时才触发 Reconcile 方法,用于更新生命周期钩子的状态
+var predicatePod = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool {
+		return false
+	},
+	DeleteFunc: func(event.DeleteEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldPod, ok := e.ObjectOld.(*corev1.Pod)
+		if !ok {
+			return false
+		}
+		newPod, ok := e.ObjectNew.(*corev1.Pod)
+		if !ok {
+			return false
+		}
+		return containerStateKey(oldPod) != containerStateKey(newPod) || isPodReady(oldPod) != isPodReady(newPod)
+	},
+	GenericFunc: func(event.GenericEvent) bool {
+		return false
+	},
+}
+
+func containerStateKey(pod *corev1.Pod) string {
+	var key string
+	for _, status := range pod.Status.ContainerStatuses {
+		key += fmt.Sprintf("%s:%d", status.Name, status.RestartCount)
+		if status.State.Waiting != nil {
+			key += ":" + status.State.Waiting.Reason
+		}
+		key += ";"
+	}
+	return key
+}
+
+// NetworkPolicy、Service、Ingress 等附属对象被删除时重新创建,其他事件不触发 Reconcile 方法
+var predicateOwnedDeleted = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool {
+		return false
+	},
+	DeleteFunc: func(event.DeleteEvent) bool {
+		return true
+	},
+	UpdateFunc: func(event.UpdateEvent) bool {
+		return false
+	},
+	GenericFunc: func(event.GenericEvent) bool {
+		return false
+	},
+}
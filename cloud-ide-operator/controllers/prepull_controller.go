@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// PrePullName 预拉取 DaemonSet 的名字
+	PrePullName = "cloud-ide-prepull"
+	// PrePullLabelPrefix 节点上记录镜像预拉取状态的标签前缀,标签名为前缀加镜像名的哈希
+	PrePullLabelPrefix = "prepull.apps.costalong.com/"
+
+	prePullPauseImage = "registry.k8s.io/pause:3.9"
+	// 提供静态链接的 true 命令,预拉取的镜像中不一定有 shell,例如 distroless 镜像
+	prePullToolsImage = "busybox:1.36-musl"
+	prePullToolsPath  = "/prepull"
+)
+
+// ImagePrePullReconciler 维护一个预拉取 DaemonSet,把工作空间模板和运维配置中的镜像提前拉取到节点上,
+// 并把每个节点的拉取状态记录到节点标签和模板状态中
+type ImagePrePullReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// 预拉取 DaemonSet 所在的命名空间
+	Namespace string
+	// 运维配置中需要预拉取的镜像
+	Images []string
+	// 只在匹配的节点上预拉取
+	NodeSelector map[string]string
+}
+
+// PrePullLabel 返回记录镜像预拉取状态的节点标签名
+func PrePullLabel(image string) string {
+	sum := sha256.Sum256([]byte(image))
+	return PrePullLabelPrefix + hex.EncodeToString(sum[:])[:16]
+}
+
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacetemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacetemplates/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+
+// Reconcile 每次协调都会重新计算全部需要预拉取的镜像,因此不关心请求的是哪个对象
+func (r *ImagePrePullReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	templates := &v1.WorkSpaceTemplateList{}
+	if err := r.Client.List(ctx, templates); err != nil {
+		klog.Errorf("[PrePull] list workspace templates error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	images := r.collectImages(templates.Items)
+	if len(images) == 0 {
+		if err := r.deleteDaemonSet(ctx); err != nil {
+			klog.Errorf("[PrePull] delete daemonset error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.applyDaemonSet(ctx, images); err != nil {
+		klog.Errorf("[PrePull] apply daemonset error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	pulled, err := r.pulledImages(ctx)
+	if err != nil {
+		klog.Errorf("[PrePull] list prepull pods error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	if err := r.labelNodes(ctx, images, pulled); err != nil {
+		klog.Errorf("[PrePull] label nodes error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	for i := range templates.Items {
+		r.updateTemplateStatus(ctx, &templates.Items[i], pulled)
+	}
+	return ctrl.Result{}, nil
+}
+
+// 合并模板和运维配置中的镜像,去重后排序,保证 DaemonSet 不会因为顺序变化而滚动更新
+func (r *ImagePrePullReconciler) collectImages(templates []v1.WorkSpaceTemplate) []string {
+	set := map[string]struct{}{}
+	for _, image := range r.Images {
+		if image != "" {
+			set[image] = struct{}{}
+		}
+	}
+	for _, tpl := range templates {
+		if tpl.Spec.PrePull && tpl.Spec.Image != "" {
+			set[tpl.Spec.Image] = struct{}{}
+		}
+	}
+
+	images := make([]string, 0, len(set))
+	for image := range set {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// 每个镜像对应一个初始化容器,初始化容器运行结束说明镜像已经拉取到了节点上,
+// 主容器只运行一个 pause 镜像,让 Pod 常驻,节点上的镜像不会被回收。
+// 第一个初始化容器把静态链接的 true 命令复制到共享的 emptyDir 中,后面的初始化容器都运行这个命令,
+// 不依赖镜像中的 shell
+func (r *ImagePrePullReconciler) constructDaemonSet(images []string) *appsv1.DaemonSet {
+	labels := map[string]string{"app": PrePullName}
+	toolsVolume := "prepull-tools"
+	toolsMount := corev1.VolumeMount{Name: toolsVolume, MountPath: prePullToolsPath}
+
+	initContainers := make([]corev1.Container, 0, len(images)+1)
+	initContainers = append(initContainers, corev1.Container{
+		Name:            "prepull-tools",
+		Image:           prePullToolsImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"cp", "/bin/true", prePullToolsPath + "/true"},
+		VolumeMounts:    []corev1.VolumeMount{toolsMount},
+	})
+	for i, image := range images {
+		initContainers = append(initContainers, corev1.Container{
+			Name:            fmt.Sprintf("prepull-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{prePullToolsPath + "/true"},
+			VolumeMounts:    []corev1.VolumeMount{toolsMount},
+		})
+	}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PrePullName,
+			Namespace: r.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					NodeSelector:   r.NodeSelector,
+					InitContainers: initContainers,
+					Volumes: []corev1.Volume{
+						{
+							Name:         toolsVolume,
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "pause",
+							Image: prePullPauseImage,
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("1m"),
+									corev1.ResourceMemory: resource.MustParse("8Mi"),
+								},
+								Limits: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("10m"),
+									corev1.ResourceMemory: resource.MustParse("16Mi"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (r *ImagePrePullReconciler) applyDaemonSet(ctx context.Context, images []string) error {
+	desired := r.constructDaemonSet(images)
+
+	ds := &appsv1.DaemonSet{}
+	err := r.Client.Get(ctx, client.ObjectKeyFromObject(desired), ds)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.Client.Create(ctx, desired)
+		}
+		return err
+	}
+
+	if equality.Semantic.DeepDerivative(desired.Spec.Template.Spec, ds.Spec.Template.Spec) {
+		return nil
+	}
+	ds.Spec.Template = desired.Spec.Template
+	return r.Client.Update(ctx, ds)
+}
+
+func (r *ImagePrePullReconciler) deleteDaemonSet(ctx context.Context) error {
+	ds := &appsv1.DaemonSet{}
+	ds.Name = PrePullName
+	ds.Namespace = r.Namespace
+	if err := r.Client.Delete(ctx, ds); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// 根据预拉取 Pod 中初始化容器的状态,计算每个节点上已经拉取好的镜像
+func (r *ImagePrePullReconciler) pulledImages(ctx context.Context) (map[string]map[string]bool, error) {
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods, client.InNamespace(r.Namespace), client.MatchingLabels{"app": PrePullName}); err != nil {
+		return nil, err
+	}
+
+	pulled := map[string]map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		images := map[string]bool{}
+		for i, status := range pod.Status.InitContainerStatuses {
+			if i >= len(pod.Spec.InitContainers) {
+				break
+			}
+			image := pod.Spec.InitContainers[i].Image
+			if status.State.Terminated != nil && status.State.Terminated.ExitCode == 0 {
+				images[image] = true
+			}
+		}
+		pulled[pod.Spec.NodeName] = images
+	}
+	return pulled, nil
+}
+
+// 给节点打上预拉取标签,调度工作空间时会优先选择已经拉取好镜像的节点
+func (r *ImagePrePullReconciler) labelNodes(ctx context.Context, images []string, pulled map[string]map[string]bool) error {
+	nodes := &corev1.NodeList{}
+	if err := r.Client.List(ctx, nodes); err != nil {
+		return err
+	}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		desired := map[string]string{}
+		for _, image := range images {
+			if pulled[node.Name][image] {
+				desired[PrePullLabel(image)] = "true"
+			}
+		}
+
+		// 只修改有变化的节点,不再预拉取的镜像对应的标签会被删除
+		labels := map[string]interface{}{}
+		for key := range node.Labels {
+			if _, ok := desired[key]; !ok && strings.HasPrefix(key, PrePullLabelPrefix) {
+				labels[key] = nil
+			}
+		}
+		for key, value := range desired {
+			if node.Labels[key] != value {
+				labels[key] = value
+			}
+		}
+		if len(labels) == 0 {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": labels},
+		})
+		if err != nil {
+			return err
+		}
+		if err := r.Client.Patch(ctx, node, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ImagePrePullReconciler) updateTemplateStatus(ctx context.Context, tpl *v1.WorkSpaceTemplate, pulled map[string]map[string]bool) {
+	status := v1.WorkSpaceTemplateStatus{}
+	if tpl.Spec.PrePull {
+		nodes := make([]string, 0, len(pulled))
+		for node := range pulled {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+
+		for _, node := range nodes {
+			ready := pulled[node][tpl.Spec.Image]
+			status.PrePullNodes = append(status.PrePullNodes, v1.NodePrePullStatus{NodeName: node, Ready: ready})
+			if ready {
+				status.PrePullReadyNodes++
+			}
+		}
+	}
+
+	if equality.Semantic.DeepEqual(status.PrePullNodes, tpl.Status.PrePullNodes) &&
+		status.PrePullReadyNodes == tpl.Status.PrePullReadyNodes {
+		return
+	}
+	tpl.Status.PrePullNodes = status.PrePullNodes
+	tpl.Status.PrePullReadyNodes = status.PrePullReadyNodes
+	if err := r.Client.Status().Update(ctx, tpl); err != nil {
+		klog.Errorf("[PrePull] update template status error:%v", err)
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ImagePrePullReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// DaemonSet 和 Pod 的事件都映射到同一个请求上
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: PrePullName, Namespace: r.Namespace}}}
+	})
+	prePullObject := predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return object.GetNamespace() == r.Namespace && object.GetLabels()["app"] == PrePullName
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("prepull").
+		For(&v1.WorkSpaceTemplate{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &appsv1.DaemonSet{}}, enqueue, builder.WithPredicates(prePullObject)).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, enqueue, builder.WithPredicates(prePullObject)).
+		Complete(r)
+}
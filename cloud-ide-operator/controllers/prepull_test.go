@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const prePullTestNamespace = "cloud-ide-operator-system"
+
+func newPrePullReconciler(t *testing.T, objects ...client.Object) *ImagePrePullReconciler {
+	t.Helper()
+	scheme := newTestScheme(t)
+	return &ImagePrePullReconciler{
+		Client:       fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Scheme:       scheme,
+		Namespace:    prePullTestNamespace,
+		Images:       []string{"code-server:base"},
+		NodeSelector: map[string]string{"node-role.kubernetes.io/workspace": ""},
+	}
+}
+
+func newPrePullTemplate(name, image string, prePull bool) *v1.WorkSpaceTemplate {
+	return &v1.WorkSpaceTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       v1.WorkSpaceTemplateSpec{Image: image, PrePull: prePull},
+	}
+}
+
+// newPrePullPod 返回运行在 node 上的预拉取 Pod,pulled 中的镜像对应的初始化容器已经成功退出
+func newPrePullPod(node string, images []string, pulled map[string]bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: PrePullName + "-" + node, Namespace: prePullTestNamespace, Labels: map[string]string{"app": PrePullName}},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+	containers := append([]string{prePullToolsImage}, images...)
+	for _, image := range containers {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Image: image})
+		status := corev1.ContainerStatus{Image: image}
+		if image == prePullToolsImage || pulled[image] {
+			status.State.Terminated = &corev1.ContainerStateTerminated{ExitCode: 0}
+		} else {
+			status.State.Waiting = &corev1.ContainerStateWaiting{Reason: "PodInitializing"}
+		}
+		pod.Status.InitContainerStatuses = append(pod.Status.InitContainerStatuses, status)
+	}
+	return pod
+}
+
+func TestCollectImages(t *testing.T) {
+	r := &ImagePrePullReconciler{Images: []string{"code-server:go", "", "code-server:base"}}
+	got := r.collectImages([]v1.WorkSpaceTemplate{
+		*newPrePullTemplate("go", "code-server:go", true),
+		*newPrePullTemplate("python", "code-server:python", true),
+		*newPrePullTemplate("rust", "code-server:rust", false),
+	})
+	want := []string{"code-server:base", "code-server:go", "code-server:python"}
+	if len(got) != len(want) {
+		t.Fatalf("collectImages() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("collectImages() = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestConstructPrePullDaemonSet(t *testing.T) {
+	r := newPrePullReconciler(t)
+	images := []string{"code-server:base", "gcr.io/distroless/static"}
+	ds := r.constructDaemonSet(images)
+
+	if ds.Name != PrePullName || ds.Namespace != prePullTestNamespace {
+		t.Errorf("daemonset %s/%s, want %s/%s", ds.Namespace, ds.Name, prePullTestNamespace, PrePullName)
+	}
+	spec := ds.Spec.Template.Spec
+	if spec.NodeSelector["node-role.kubernetes.io/workspace"] != "" || len(spec.NodeSelector) != 1 {
+		t.Errorf("nodeSelector = %v", spec.NodeSelector)
+	}
+	if ds.Spec.Template.Labels["app"] != PrePullName || ds.Spec.Selector.MatchLabels["app"] != PrePullName {
+		t.Errorf("labels %v, selector %v", ds.Spec.Template.Labels, ds.Spec.Selector)
+	}
+
+	// 第一个初始化容器提供 true 命令,其余每个镜像一个初始化容器,都不依赖镜像中的 shell
+	if len(spec.InitContainers) != len(images)+1 {
+		t.Fatalf("init containers = %d, want %d", len(spec.InitContainers), len(images)+1)
+	}
+	tools := spec.InitContainers[0]
+	if tools.Image != prePullToolsImage || tools.Command[0] != "cp" {
+		t.Errorf("tools container = %+v", tools)
+	}
+	for i, image := range images {
+		c := spec.InitContainers[i+1]
+		if c.Image != image || len(c.Command) != 1 || c.Command[0] != prePullToolsPath+"/true" {
+			t.Errorf("init container %d = %s %v, want %s running %s/true", i+1, c.Image, c.Command, image, prePullToolsPath)
+		}
+		if len(c.VolumeMounts) != 1 || c.VolumeMounts[0].MountPath != prePullToolsPath {
+			t.Errorf("init container %d mounts = %+v", i+1, c.VolumeMounts)
+		}
+	}
+	if len(spec.Containers) != 1 || spec.Containers[0].Image != prePullPauseImage {
+		t.Errorf("containers = %+v, want only pause", spec.Containers)
+	}
+}
+
+func TestPrePullReconcile(t *testing.T) {
+	images := []string{"code-server:base", "code-server:go"}
+	nodeA := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{
+		// 不再预拉取的镜像留下的标签
+		PrePullLabel("code-server:old"): "true",
+		"kubernetes.io/hostname":        "node-a",
+	}}}
+	nodeB := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}}
+	tpl := newPrePullTemplate("go", "code-server:go", true)
+	r := newPrePullReconciler(t, tpl, nodeA, nodeB,
+		newPrePullPod("node-a", images, map[string]bool{"code-server:base": true, "code-server:go": true}),
+		newPrePullPod("node-b", images, map[string]bool{"code-server:base": true}),
+	)
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, reconcile.Request{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := &appsv1.DaemonSet{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: PrePullName, Namespace: prePullTestNamespace}, ds); err != nil {
+		t.Fatalf("get daemonset: %v", err)
+	}
+	if n := len(ds.Spec.Template.Spec.InitContainers); n != len(images)+1 {
+		t.Errorf("init containers = %d, want %d", n, len(images)+1)
+	}
+
+	wantLabels := map[string]map[string]string{
+		"node-a": {PrePullLabel("code-server:base"): "true", PrePullLabel("code-server:go"): "true", "kubernetes.io/hostname": "node-a"},
+		"node-b": {PrePullLabel("code-server:base"): "true"},
+	}
+	for name, want := range wantLabels {
+		node := &corev1.Node{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: name}, node); err != nil {
+			t.Fatal(err)
+		}
+		if len(node.Labels) != len(want) {
+			t.Errorf("node %s labels = %v, want %v", name, node.Labels, want)
+		}
+		for key, value := range want {
+			if node.Labels[key] != value {
+				t.Errorf("node %s label %s = %q, want %q", name, key, node.Labels[key], value)
+			}
+		}
+	}
+
+	got := &v1.WorkSpaceTemplate{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(tpl), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.PrePullReadyNodes != 1 || len(got.Status.PrePullNodes) != 2 ||
+		!got.Status.PrePullNodes[0].Ready || got.Status.PrePullNodes[1].Ready {
+		t.Errorf("template status = %+v, want node-a ready and node-b not ready", got.Status)
+	}
+
+	// 没有需要预拉取的镜像时删除 DaemonSet
+	r.Images = nil
+	tpl = got
+	tpl.Spec.PrePull = false
+	if err := r.Client.Update(ctx, tpl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, reconcile.Request{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(ds), &appsv1.DaemonSet{}); !errors.IsNotFound(err) {
+		t.Errorf("daemonset not deleted: %v", err)
+	}
+}
+
+// 节点上的预拉取标签和工作空间 Pod 的节点亲和性使用相同的标签名
+func TestPrePullAffinity(t *testing.T) {
+	space := newPodWorkSpace()
+	space.Spec.Image = "code-server:go"
+	space.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{{Weight: 10}},
+	}}
+	pod := (&WorkSpaceReconciler{}).constructPod(space)
+
+	terms := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 2 || terms[0].Weight != 10 {
+		t.Fatalf("preferred terms = %+v, want the user term followed by the prepull term", terms)
+	}
+	if space.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].Preference.MatchExpressions != nil ||
+		len(space.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Errorf("workspace affinity modified: %+v", space.Spec.Affinity)
+	}
+
+	req := terms[1].Preference.MatchExpressions[0]
+	labelled := map[string]string{PrePullLabel(space.Spec.Image): "true"}
+	if req.Key != PrePullLabel(space.Spec.Image) || req.Operator != corev1.NodeSelectorOpIn ||
+		len(req.Values) != 1 || labelled[req.Key] != req.Values[0] {
+		t.Errorf("prepull preference %+v does not match node labels %v", req, labelled)
+	}
+	if terms[1].Weight != 100 {
+		t.Errorf("prepull weight = %d, want 100", terms[1].Weight)
+	}
+}
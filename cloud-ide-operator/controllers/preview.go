@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// PreviewConfig 工作空间暴露端口的预览地址配置
+type PreviewConfig struct {
+	// 预览地址的域名,端口的预览地址为 {port}-{name}.{domain},为空时只创建 Service,使用集群内的地址
+	Domain string
+	// Ingress 使用的 IngressClass
+	IngressClassName string
+	// 预览地址使用的 TLS 证书(通配符证书)所在的 Secret,为空时使用 http
+	TLSSecret string
+	// 私有端口的 Ingress 上添加的注解,用于接入 IDE 网关的认证,例如 nginx.ingress.kubernetes.io/auth-url
+	PrivateAnnotations map[string]string
+}
+
+func previewServiceName(space *v1.WorkSpace) string {
+	return space.Name + "-preview"
+}
+
+func previewIngressName(space *v1.WorkSpace, public bool) string {
+	if public {
+		return space.Name + "-preview-public"
+	}
+	return space.Name + "-preview-private"
+}
+
+// previewPortNames 返回每个暴露端口在 Service 中使用的名字。
+// 名字为空、不是合法的端口名或者和其它端口重名时使用 port-{port},Service 有多个端口时每个端口的名字必须唯一
+func previewPortNames(ports []v1.ExposedPort) []string {
+	count := map[string]int{}
+	for _, p := range ports {
+		count[p.Name]++
+	}
+	names := make([]string, len(ports))
+	used := map[string]bool{}
+	for i, p := range ports {
+		if p.Name != "" && count[p.Name] == 1 && len(validation.IsValidPortName(p.Name)) == 0 {
+			names[i] = p.Name
+			used[p.Name] = true
+		}
+	}
+	for i, p := range ports {
+		if names[i] != "" {
+			continue
+		}
+		name := fmt.Sprintf("port-%d", p.Port)
+		for n := 1; used[name]; n++ {
+			name = fmt.Sprintf("port-%d-%d", p.Port, n)
+		}
+		names[i] = name
+		used[name] = true
+	}
+	return names
+}
+
+func (r *WorkSpaceReconciler) previewHost(space *v1.WorkSpace, port int32) string {
+	return fmt.Sprintf("%d-%s.%s", port, space.Name, r.Preview.Domain)
+}
+
+// reconcilePreview 为暴露的端口创建 Service 和 Ingress,并把预览地址记录到状态中
+// 公开端口和私有端口使用不同的 Ingress,私有端口的 Ingress 需要经过 IDE 网关的认证
+func (r *WorkSpaceReconciler) reconcilePreview(ctx context.Context, space *v1.WorkSpace) error {
+	var public, private []v1.ExposedPort
+	for _, p := range space.Spec.Ports {
+		if p.Public {
+			public = append(public, p)
+		} else {
+			private = append(private, p)
+		}
+	}
+
+	if err := r.reconcilePreviewService(ctx, space); err != nil {
+		return err
+	}
+	if err := r.reconcilePreviewIngress(ctx, space, true, public); err != nil {
+		return err
+	}
+	if err := r.reconcilePreviewIngress(ctx, space, false, private); err != nil {
+		return err
+	}
+
+	space.Status.Previews = nil
+	names := previewPortNames(space.Spec.Ports)
+	for i, p := range space.Spec.Ports {
+		url := fmt.Sprintf("http://%s.%s.svc:%d", previewServiceName(space), space.Namespace, p.Port)
+		if r.Preview.Domain != "" {
+			scheme := "http"
+			if r.Preview.TLSSecret != "" {
+				scheme = "https"
+			}
+			url = fmt.Sprintf("%s://%s", scheme, r.previewHost(space, p.Port))
+		}
+		space.Status.Previews = append(space.Status.Previews, v1.PreviewURL{
+			Name:   names[i],
+			Port:   p.Port,
+			Public: p.Public,
+			URL:    url,
+		})
+	}
+	return nil
+}
+
+func (r *WorkSpaceReconciler) reconcilePreviewService(ctx context.Context, space *v1.WorkSpace) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: previewServiceName(space), Namespace: space.Namespace},
+	}
+	if len(space.Spec.Ports) == 0 {
+		return deleteIfExist(ctx, r.Client, svc)
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = map[string]string{"app": "cloud-ide", WorkSpaceLabel: space.Name}
+		svc.Spec.Selector = map[string]string{WorkSpaceLabel: space.Name}
+		svc.Spec.Ports = nil
+		names := previewPortNames(space.Spec.Ports)
+		for i, p := range space.Spec.Ports {
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+				Name:       names[i],
+				Protocol:   corev1.ProtocolTCP,
+				Port:       p.Port,
+				TargetPort: intstr.FromInt(int(p.Port)),
+			})
+		}
+		return controllerutil.SetControllerReference(space, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *WorkSpaceReconciler) reconcilePreviewIngress(ctx context.Context, space *v1.WorkSpace, public bool, ports []v1.ExposedPort) error {
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: previewIngressName(space, public), Namespace: space.Namespace},
+	}
+	if r.Preview.Domain == "" || len(ports) == 0 {
+		return deleteIfExist(ctx, r.Client, ing)
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, ing, func() error {
+		ing.Labels = map[string]string{"app": "cloud-ide", WorkSpaceLabel: space.Name}
+		ing.Annotations = nil
+		if !public {
+			ing.Annotations = r.Preview.PrivateAnnotations
+		}
+		if r.Preview.IngressClassName != "" {
+			ing.Spec.IngressClassName = &r.Preview.IngressClassName
+		}
+
+		pathType := networkingv1.PathTypePrefix
+		ing.Spec.Rules = nil
+		ing.Spec.TLS = nil
+		var hosts []string
+		for _, p := range ports {
+			host := r.previewHost(space, p.Port)
+			hosts = append(hosts, host)
+			ing.Spec.Rules = append(ing.Spec.Rules, networkingv1.IngressRule{
+				Host: host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{
+							{
+								Path:     "/",
+								PathType: &pathType,
+								Backend: networkingv1.IngressBackend{
+									Service: &networkingv1.IngressServiceBackend{
+										Name: previewServiceName(space),
+										Port: networkingv1.ServiceBackendPort{Number: p.Port},
+									},
+								},
+							},
+						},
+					},
+				},
+			})
+		}
+		if r.Preview.TLSSecret != "" {
+			ing.Spec.TLS = []networkingv1.IngressTLS{{Hosts: hosts, SecretName: r.Preview.TLSSecret}}
+		}
+		return controllerutil.SetControllerReference(space, ing, r.Scheme)
+	})
+	return err
+}
+
+func deleteIfExist(ctx context.Context, c client.Client, obj client.Object) error {
+	if err := c.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
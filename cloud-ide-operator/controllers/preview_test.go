@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPreviewPortNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []v1.ExposedPort
+		want  []string
+	}{
+		{
+			name:  "named",
+			ports: []v1.ExposedPort{{Name: "web", Port: 3000}, {Name: "api", Port: 8000}},
+			want:  []string{"web", "api"},
+		},
+		{
+			name:  "empty name",
+			ports: []v1.ExposedPort{{Name: "web", Port: 3000}, {Port: 8000}},
+			want:  []string{"web", "port-8000"},
+		},
+		{
+			name:  "duplicate names",
+			ports: []v1.ExposedPort{{Name: "web", Port: 3000}, {Name: "web", Port: 3001}, {Name: "api", Port: 8000}},
+			want:  []string{"port-3000", "port-3001", "api"},
+		},
+		{
+			name:  "invalid name",
+			ports: []v1.ExposedPort{{Name: "Web_UI", Port: 3000}, {Name: "1234", Port: 8000}},
+			want:  []string{"port-3000", "port-8000"},
+		},
+		{
+			name:  "default taken",
+			ports: []v1.ExposedPort{{Name: "port-3000", Port: 8000}, {Port: 3000}},
+			want:  []string{"port-3000", "port-3000-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := previewPortNames(tt.ports)
+			if len(got) != len(tt.want) {
+				t.Fatalf("previewPortNames() = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("previewPortNames() = %v, want %v", got, tt.want)
+					break
+				}
+				if errs := validation.IsValidPortName(got[i]); len(errs) != 0 {
+					t.Errorf("port name %q is invalid: %v", got[i], errs)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcilePreview(t *testing.T) {
+	space := newPodWorkSpace()
+	space.Spec.Ports = []v1.ExposedPort{
+		{Name: "web", Port: 3000, Public: true},
+		{Port: 8000},
+		{Name: "docs", Port: 8080},
+	}
+	r := newTestReconciler(t, space)
+	r.Preview = PreviewConfig{
+		Domain:             "preview.example.com",
+		TLSSecret:          "preview-tls",
+		PrivateAnnotations: map[string]string{"nginx.ingress.kubernetes.io/auth-url": "http://gateway/auth"},
+	}
+	ctx := context.Background()
+
+	if err := r.reconcilePreview(ctx, space); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &corev1.Service{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: previewServiceName(space), Namespace: space.Namespace}, svc); err != nil {
+		t.Fatalf("get service: %v", err)
+	}
+	wantNames := []string{"web", "port-8000", "docs"}
+	if len(svc.Spec.Ports) != len(wantNames) {
+		t.Fatalf("service ports = %+v", svc.Spec.Ports)
+	}
+	for i, p := range svc.Spec.Ports {
+		if p.Name != wantNames[i] || p.TargetPort.IntVal != space.Spec.Ports[i].Port {
+			t.Errorf("service port %d = %+v, want %s targeting %d", i, p, wantNames[i], space.Spec.Ports[i].Port)
+		}
+	}
+	if svc.Spec.Selector[WorkSpaceLabel] != space.Name || len(svc.OwnerReferences) != 1 {
+		t.Errorf("service selector %v, owners %v", svc.Spec.Selector, svc.OwnerReferences)
+	}
+
+	public := &networkingv1.Ingress{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: previewIngressName(space, true), Namespace: space.Namespace}, public); err != nil {
+		t.Fatalf("get public ingress: %v", err)
+	}
+	if len(public.Spec.Rules) != 1 || public.Spec.Rules[0].Host != "3000-ws.preview.example.com" || len(public.Annotations) != 0 {
+		t.Errorf("public ingress = %+v", public.Spec)
+	}
+	private := &networkingv1.Ingress{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: previewIngressName(space, false), Namespace: space.Namespace}, private); err != nil {
+		t.Fatalf("get private ingress: %v", err)
+	}
+	if len(private.Spec.Rules) != 2 || private.Annotations["nginx.ingress.kubernetes.io/auth-url"] == "" {
+		t.Errorf("private ingress = %+v, annotations %v", private.Spec, private.Annotations)
+	}
+	if len(private.Spec.TLS) != 1 || len(private.Spec.TLS[0].Hosts) != 2 || private.Spec.TLS[0].SecretName != "preview-tls" {
+		t.Errorf("private ingress tls = %+v", private.Spec.TLS)
+	}
+
+	if len(space.Status.Previews) != 3 || space.Status.Previews[1].Name != "port-8000" ||
+		space.Status.Previews[0].URL != "https://3000-ws.preview.example.com" {
+		t.Errorf("previews = %+v", space.Status.Previews)
+	}
+
+	// 不再暴露私有端口时删除对应的 Ingress,不再暴露任何端口时删除 Service
+	space.Spec.Ports = space.Spec.Ports[:1]
+	if err := r.reconcilePreview(ctx, space); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(private), &networkingv1.Ingress{}); !errors.IsNotFound(err) {
+		t.Errorf("private ingress not deleted: %v", err)
+	}
+	space.Spec.Ports = nil
+	if err := r.reconcilePreview(ctx, space); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), &corev1.Service{}); !errors.IsNotFound(err) {
+		t.Errorf("service not deleted: %v", err)
+	}
+	if len(space.Status.Previews) != 0 {
+		t.Errorf("previews = %+v, want none", space.Status.Previews)
+	}
+}
@@ -8,25 +8,28 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"time"
 )
 
-func (r *WorkSpaceReconciler) checkPVCExist(key client.ObjectKey) (bool, error) {
+// 从快照创建 PVC 时 DataSource 使用的 API 组
+const snapshotAPIGroup = "snapshot.storage.k8s.io"
+
+func (r *WorkSpaceReconciler) checkPVCExist(ctx context.Context, key client.ObjectKey) (bool, error) {
 	pvc := &corev1.PersistentVolumeClaim{}
 
-	if err := r.Client.Get(context.Background(), key, pvc); err != nil {
+	if err := r.Client.Get(ctx, key, pvc); err != nil {
 		if errors.IsNotFound(err) {
 			return false, nil
 		}
 		klog.Errorf("get pvc error:%v", err)
-		return false, nil
+		return false, err
 	}
 	return true, nil
 }
 
-func (r *WorkSpaceReconciler) deletePVC(key client.ObjectKey) error {
-	exist, err := r.checkPVCExist(key)
+func (r *WorkSpaceReconciler) deletePVC(ctx context.Context, key client.ObjectKey) error {
+	exist, err := r.checkPVCExist(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -39,9 +42,6 @@ func (r *WorkSpaceReconciler) deletePVC(key client.ObjectKey) error {
 	pvc.Name = key.Name           // 名字
 	pvc.Namespace = key.Namespace // 空间
 
-	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second)
-	defer cancelFunc()
-
 	// 删除
 	if err := r.Client.Delete(ctx, pvc); err != nil {
 		if errors.IsNotFound(err) {
@@ -53,9 +53,14 @@ func (r *WorkSpaceReconciler) deletePVC(key client.ObjectKey) error {
 	return nil
 }
 
-func (r *WorkSpaceReconciler) createPVC(space *v1.WorkSpace, key client.ObjectKey) error {
-	//  1 先检查
-	exist, err := r.checkPodExist(key)
+func (r *WorkSpaceReconciler) createPVC(ctx context.Context, space *v1.WorkSpace, key client.ObjectKey) error {
+	// 临时存储的工作空间不需要 PVC
+	if isEphemeral(space) {
+		return nil
+	}
+
+	//  1 先检查,PVC 已经存在时不再需要克隆的来源
+	exist, err := r.checkPVCExist(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -69,8 +74,10 @@ func (r *WorkSpaceReconciler) createPVC(space *v1.WorkSpace, key client.ObjectKe
 		klog.Errorf("construct pvc error:%v", err)
 		return err
 	}
-	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancelFunc()
+	if err := r.resolveCloneSource(ctx, space, pvc); err != nil {
+		klog.Errorf("resolve clone source error:%v", err)
+		return err
+	}
 	err = r.Client.Create(ctx, pvc)
 	if err != nil {
 		if errors.IsAlreadyExists(err) {
@@ -82,6 +89,21 @@ func (r *WorkSpaceReconciler) createPVC(space *v1.WorkSpace, key client.ObjectKe
 	return nil
 }
 
+// 来源工作空间可能是从预热池中领取的,PVC 的名字以来源工作空间的状态为准
+func (r *WorkSpaceReconciler) resolveCloneSource(ctx context.Context, space *v1.WorkSpace, pvc *corev1.PersistentVolumeClaim) error {
+	if pvc.Spec.DataSource == nil || pvc.Spec.DataSource.Kind != "PersistentVolumeClaim" {
+		return nil
+	}
+
+	source := &v1.WorkSpace{}
+	key := client.ObjectKey{Name: space.Spec.SourceWorkspace, Namespace: space.Namespace}
+	if err := r.Client.Get(ctx, key, source); err != nil {
+		return err
+	}
+	pvc.Spec.DataSource.Name = claimKey(source).Name
+	return nil
+}
+
 func (r *WorkSpaceReconciler) constructPVC(space *v1.WorkSpace) (*corev1.PersistentVolumeClaim, error) {
 	quantity, err := resource.ParseQuantity(space.Spec.Storage)
 	if err != nil {
@@ -95,16 +117,36 @@ func (r *WorkSpaceReconciler) constructPVC(space *v1.WorkSpace) (*corev1.Persist
 		},
 
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      space.Name,
+			Name:      claimKey(space).Name,
 			Namespace: space.Namespace,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			AccessModes:      space.Spec.AccessModes,
+			StorageClassName: space.Spec.StorageClassName,
+			VolumeMode:       space.Spec.VolumeMode,
 			Resources: corev1.ResourceRequirements{
-				Limits:   corev1.ResourceList{corev1.ResourceStorage: quantity},
 				Requests: corev1.ResourceList{corev1.ResourceStorage: quantity},
 			},
 		},
 	}
+	// 并不是所有的存储类都支持 ReadWriteMany,默认使用 ReadWriteOnce
+	if len(pvc.Spec.AccessModes) == 0 {
+		pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	// 克隆工作空间,来源 PVC 的名字在创建时根据来源工作空间的状态确定
+	switch {
+	case space.Spec.SourceSnapshot != "":
+		pvc.Spec.DataSource = &corev1.TypedLocalObjectReference{
+			APIGroup: pointer.String(snapshotAPIGroup),
+			Kind:     "VolumeSnapshot",
+			Name:     space.Spec.SourceSnapshot,
+		}
+	case space.Spec.SourceWorkspace != "":
+		pvc.Spec.DataSource = &corev1.TypedLocalObjectReference{
+			Kind: "PersistentVolumeClaim",
+			Name: space.Spec.SourceWorkspace,
+		}
+	}
 	return pvc, nil
 }
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCreatePVCClone(t *testing.T) {
+	newClone := func() *v1.WorkSpace {
+		return &v1.WorkSpace{
+			ObjectMeta: metav1.ObjectMeta{Name: "clone", Namespace: "default", UID: "clone-uid"},
+			Spec:       v1.WorkSpaceSpec{Storage: "10Gi", SourceWorkspace: "source"},
+		}
+	}
+	source := &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: "source", Namespace: "default"},
+		Status:     v1.WorkSpaceStatus{ClaimName: "go-pool-abcde"},
+	}
+
+	t.Run("resolve source claim", func(t *testing.T) {
+		r := newTestReconciler(t, source)
+		space := newClone()
+		ctx := context.Background()
+		if err := r.createPVC(ctx, space, claimKey(space)); err != nil {
+			t.Fatal(err)
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(space), pvc); err != nil {
+			t.Fatal(err)
+		}
+		if pvc.Spec.DataSource == nil || pvc.Spec.DataSource.Name != source.Status.ClaimName {
+			t.Errorf("dataSource = %+v, want claim %q", pvc.Spec.DataSource, source.Status.ClaimName)
+		}
+	})
+
+	t.Run("source deleted after clone", func(t *testing.T) {
+		existing := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "clone", Namespace: "default"}}
+		r := newTestReconciler(t, existing)
+		space := newClone()
+		if err := r.createPVC(context.Background(), space, claimKey(space)); err != nil {
+			t.Errorf("createPVC() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("source not exist", func(t *testing.T) {
+		r := newTestReconciler(t)
+		space := newClone()
+		if err := r.createPVC(context.Background(), space, claimKey(space)); err == nil {
+			t.Error("createPVC() succeeded without the source workspace")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ConditionRestored 工作空间的存储卷是否已经从备份中恢复
+const ConditionRestored = "Restored"
+
+// restoreBackup 在启动 Pod 之前把备份解压到新创建的 PVC 中,返回是否可以启动 Pod
+// 恢复失败后工作空间保持停止,直到工作空间的 spec 改变(例如重新启动)后才会再次恢复
+func (r *WorkSpaceReconciler) restoreBackup(ctx context.Context, space *v1.WorkSpace) (bool, error) {
+	restore := space.Spec.RestoreFrom
+	if restore == nil || isEphemeral(space) || isBlockVolume(space) {
+		return true, nil
+	}
+	if cond := meta.FindStatusCondition(space.Status.Conditions, ConditionRestored); cond != nil {
+		if cond.Status == metav1.ConditionTrue {
+			return true, nil
+		}
+		if restoreFailed(space) {
+			return false, nil
+		}
+	}
+
+	policy := &v1.WorkSpaceBackupPolicy{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: restore.Policy, Namespace: space.Namespace}, policy); err != nil {
+		return false, err
+	}
+
+	job := &batchv1.Job{}
+	name := backupJobName(space.Name, "restore")
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: space.Namespace}, job); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		job = constructBackupJob(policy, name, restore.Workspace, claimKey(space).Name, restore.Backup, restoreScript)
+		job.Labels[WorkSpaceLabel] = space.Name
+		if err := controllerutil.SetControllerReference(space, job, r.Scheme); err != nil {
+			return false, err
+		}
+		if err := r.Client.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+			return false, err
+		}
+		setRestoreCondition(space, metav1.ConditionUnknown, "Restoring", fmt.Sprintf("restoring backup %s of workspace %s", restore.Backup, restore.Workspace))
+		return false, nil
+	}
+
+	finished, succeeded := jobFinished(job)
+	if !finished {
+		return false, nil
+	}
+	if succeeded {
+		setRestoreCondition(space, metav1.ConditionTrue, "Restored", fmt.Sprintf("restored backup %s of workspace %s", restore.Backup, restore.Workspace))
+	} else {
+		setRestoreCondition(space, metav1.ConditionFalse, "RestoreFailed", fmt.Sprintf("restore job %s failed", job.Name))
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	if err := r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	return succeeded, nil
+}
+
+// restoreFailed 返回当前 generation 的恢复是否已经失败
+func restoreFailed(space *v1.WorkSpace) bool {
+	cond := meta.FindStatusCondition(space.Status.Conditions, ConditionRestored)
+	return cond != nil && cond.Status == metav1.ConditionFalse && cond.ObservedGeneration == space.Generation
+}
+
+func setRestoreCondition(space *v1.WorkSpace, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+		Type:               ConditionRestored,
+		Status:             status,
+		ObservedGeneration: space.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
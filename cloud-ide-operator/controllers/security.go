@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ConditionSecurityProfile 工作空间的安全配置档是否被命名空间允许
+	ConditionSecurityProfile = "SecurityProfile"
+
+	// SecurityProfileLabel 工作空间 Pod 上记录使用的安全配置档的标签
+	SecurityProfileLabel = "apps.costalong.com/security-profile"
+
+	// Pod Security Admission 在命名空间上强制执行的安全级别
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+	// 工作空间容器使用的用户和组,和 code-server 镜像中的 coder 用户一致
+	workspaceUser int64 = 1000
+)
+
+var podSecurityLevels = map[string]int{
+	"restricted": 0,
+	"baseline":   1,
+	"privileged": 2,
+}
+
+// baseline 级别允许容器添加的 capability
+var baselineCapabilities = map[corev1.Capability]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true, "FSETID": true, "KILL": true, "MKNOD": true,
+	"NET_BIND_SERVICE": true, "SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// securityProfile 返回工作空间使用的安全配置档,没有设置时返回空字符串,不修改 Pod 的安全上下文
+// Docker sidecar 自己设置了特权的安全上下文,不影响工作空间容器的安全配置档
+func securityProfile(space *v1.WorkSpace) v1.SecurityProfile {
+	return space.Spec.SecurityProfile
+}
+
+// constructSecurityContext 返回安全配置档对应的 Pod 和容器的安全上下文
+// 运行的用户设置在容器上而不是 Pod 上,伴随服务的容器不继承工作空间的用户
+func constructSecurityContext(profile v1.SecurityProfile) (*corev1.PodSecurityContext, *corev1.SecurityContext) {
+	switch profile {
+	case v1.SecurityProfilePrivilegedForDocker:
+		return &corev1.PodSecurityContext{
+			FSGroup:        pointer.Int64(workspaceUser),
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+		}, &corev1.SecurityContext{
+			Privileged:               pointer.Bool(true),
+			AllowPrivilegeEscalation: pointer.Bool(true),
+		}
+	case v1.SecurityProfileBaseline:
+		return &corev1.PodSecurityContext{
+			FSGroup:        pointer.Int64(workspaceUser),
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		}, &corev1.SecurityContext{
+			RunAsNonRoot: pointer.Bool(true),
+			RunAsUser:    pointer.Int64(workspaceUser),
+			RunAsGroup:   pointer.Int64(workspaceUser),
+			Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"NET_RAW"}},
+		}
+	default:
+		return &corev1.PodSecurityContext{
+			FSGroup:        pointer.Int64(workspaceUser),
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		}, &corev1.SecurityContext{
+			RunAsNonRoot:             pointer.Bool(true),
+			RunAsUser:                pointer.Int64(workspaceUser),
+			RunAsGroup:               pointer.Int64(workspaceUser),
+			AllowPrivilegeEscalation: pointer.Bool(false),
+			ReadOnlyRootFilesystem:   pointer.Bool(true),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		}
+	}
+}
+
+// companionSecurityContext 返回没有设置安全上下文的伴随服务使用的安全上下文,满足和工作空间容器相同的 Pod Security Standards 级别。
+// 伴随服务使用镜像中的用户运行,根文件系统可写;restricted 时镜像的用户必须是数字形式的非 root 用户
+func companionSecurityContext(profile v1.SecurityProfile) *corev1.SecurityContext {
+	switch profile {
+	case v1.SecurityProfileRestricted:
+		return &corev1.SecurityContext{
+			RunAsNonRoot:             pointer.Bool(true),
+			AllowPrivilegeEscalation: pointer.Bool(false),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		}
+	case v1.SecurityProfileBaseline:
+		return &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"NET_RAW"}},
+		}
+	default:
+		return nil
+	}
+}
+
+// applySecurityProfile 为 Pod 中的所有容器设置安全上下文,没有使用安全配置档时不做修改
+// 根文件系统只读时 /tmp 使用 emptyDir,HOME 指向可写的工作空间存储卷
+func applySecurityProfile(pod *corev1.Pod, space *v1.WorkSpace) {
+	if securityProfile(space) == "" {
+		return
+	}
+	podContext, containerContext := constructSecurityContext(securityProfile(space))
+	pod.Spec.SecurityContext = podContext
+	pod.Labels[SecurityProfileLabel] = string(securityProfile(space))
+
+	readOnly := containerContext.ReadOnlyRootFilesystem != nil && *containerContext.ReadOnlyRootFilesystem
+	if readOnly {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         "tmp",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		home := space.Spec.MountPath
+		if isBlockVolume(space) {
+			home = "/tmp"
+		}
+		if !hasEnv(pod.Spec.Containers[0].Env, "HOME") {
+			pod.Spec.Containers[0].Env = append([]corev1.EnvVar{{Name: "HOME", Value: home}}, pod.Spec.Containers[0].Env...)
+		}
+	}
+
+	// 伴随服务使用自己的安全上下文,没有设置时使用 companionSecurityContext,sidecar 自己设置了安全上下文时也不覆盖
+	companions := map[string]bool{}
+	for _, c := range space.Spec.Companions {
+		companions[c.Name] = true
+	}
+	apply := func(container *corev1.Container) {
+		if container.SecurityContext != nil {
+			return
+		}
+		if companions[container.Name] {
+			container.SecurityContext = companionSecurityContext(securityProfile(space))
+			return
+		}
+		container.SecurityContext = containerContext.DeepCopy()
+		if readOnly {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"})
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		apply(&pod.Spec.InitContainers[i])
+	}
+	for i := range pod.Spec.Containers {
+		apply(&pod.Spec.Containers[i])
+	}
+}
+
+func hasEnv(env []corev1.EnvVar, name string) bool {
+	for _, e := range env {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecurityProfile 检查命名空间的 Pod Security Admission 级别是否允许工作空间的 Pod
+// 不允许时记录状态条件并返回 false,Pod 即使创建也会被准入控制拒绝。
+// 这里只比较命名空间的 pod-security.kubernetes.io/enforce 标签,其它准入策略(例如 Kyverno、Gatekeeper)
+// 拒绝 Pod 时只能在创建 Pod 的错误中看到
+func (r *WorkSpaceReconciler) checkSecurityProfile(ctx context.Context, space *v1.WorkSpace) (bool, error) {
+	ns := &corev1.Namespace{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: space.Namespace}, ns); err != nil {
+		return false, err
+	}
+
+	// 按照实际创建的 Pod 计算级别,sidecar 和伴随服务都会影响整个 Pod 的级别
+	profile := securityProfile(space)
+	required, subject := podSecurityLevel(r.constructPod(space))
+
+	level, ok := ns.Labels[podSecurityEnforceLabel]
+	if max, known := podSecurityLevels[level]; ok && known && required > max {
+		meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+			Type:               ConditionSecurityProfile,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: space.Generation,
+			Reason:             "Forbidden",
+			Message:            fmt.Sprintf("%s is not allowed by pod security level %q of namespace %s", subject, level, space.Namespace),
+		})
+		return false, nil
+	}
+
+	message := fmt.Sprintf("security profile %q applied", profile)
+	if profile == "" {
+		message = "no security profile, the pod runs with the user of the image"
+	}
+	meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+		Type:               ConditionSecurityProfile,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: space.Generation,
+		Reason:             "Allowed",
+		Message:            message,
+	})
+	return true, nil
+}
+
+// podSecurityLevel 按照 Pod Security Standards 计算 Pod 满足的最严格的级别,同时返回决定这个级别的 Pod 或容器的描述。
+// 只检查工作空间的 Pod 会用到的字段,AppArmor、SELinux 和 sysctl 不在检查范围内
+func podSecurityLevel(pod *corev1.Pod) (int, string) {
+	spec := &pod.Spec
+	level, subject := podSecurityLevels["restricted"], "pod"
+	raise := func(l int, s string) {
+		if l > level {
+			level, subject = l, s
+		}
+	}
+
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		raise(podSecurityLevels["privileged"], "pod with host namespaces")
+	}
+	if sc := spec.SecurityContext; sc != nil && sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+		raise(podSecurityLevels["privileged"], "pod with unconfined seccomp profile")
+	}
+	for _, volume := range spec.Volumes {
+		switch {
+		case volume.HostPath != nil:
+			raise(podSecurityLevels["privileged"], fmt.Sprintf("hostPath volume %q", volume.Name))
+		case volume.EmptyDir == nil && volume.ConfigMap == nil && volume.Secret == nil && volume.PersistentVolumeClaim == nil &&
+			volume.Projected == nil && volume.DownwardAPI == nil && volume.CSI == nil && volume.Ephemeral == nil:
+			raise(podSecurityLevels["baseline"], fmt.Sprintf("volume %q", volume.Name))
+		}
+	}
+	for i := range spec.InitContainers {
+		raise(containerSecurityLevel(spec, &spec.InitContainers[i]), fmt.Sprintf("init container %q", spec.InitContainers[i].Name))
+	}
+	for i := range spec.Containers {
+		raise(containerSecurityLevel(spec, &spec.Containers[i]), fmt.Sprintf("container %q", spec.Containers[i].Name))
+	}
+	return level, subject
+}
+
+// containerSecurityLevel 计算容器满足的最严格的级别,容器没有设置的字段使用 Pod 的安全上下文
+func containerSecurityLevel(spec *corev1.PodSpec, container *corev1.Container) int {
+	podContext := spec.SecurityContext
+	if podContext == nil {
+		podContext = &corev1.PodSecurityContext{}
+	}
+	sc := container.SecurityContext
+	if sc == nil {
+		sc = &corev1.SecurityContext{}
+	}
+
+	seccomp := podContext.SeccompProfile
+	if sc.SeccompProfile != nil {
+		seccomp = sc.SeccompProfile
+	}
+	if (sc.Privileged != nil && *sc.Privileged) || (seccomp != nil && seccomp.Type == corev1.SeccompProfileTypeUnconfined) ||
+		(sc.ProcMount != nil && *sc.ProcMount == corev1.UnmaskedProcMount) {
+		return podSecurityLevels["privileged"]
+	}
+	for _, port := range container.Ports {
+		if port.HostPort != 0 {
+			return podSecurityLevels["privileged"]
+		}
+	}
+	var added, dropAll bool
+	if sc.Capabilities != nil {
+		for _, c := range sc.Capabilities.Add {
+			if !baselineCapabilities[c] {
+				return podSecurityLevels["privileged"]
+			}
+			added = added || c != "NET_BIND_SERVICE"
+		}
+		for _, c := range sc.Capabilities.Drop {
+			dropAll = dropAll || c == "ALL"
+		}
+	}
+
+	runAsNonRoot, runAsUser := podContext.RunAsNonRoot, podContext.RunAsUser
+	if sc.RunAsNonRoot != nil {
+		runAsNonRoot = sc.RunAsNonRoot
+	}
+	if sc.RunAsUser != nil {
+		runAsUser = sc.RunAsUser
+	}
+	restricted := sc.AllowPrivilegeEscalation != nil && !*sc.AllowPrivilegeEscalation &&
+		runAsNonRoot != nil && *runAsNonRoot && (runAsUser == nil || *runAsUser != 0) &&
+		seccomp != nil && (seccomp.Type == corev1.SeccompProfileTypeRuntimeDefault || seccomp.Type == corev1.SeccompProfileTypeLocalhost) &&
+		dropAll && !added
+	if restricted {
+		return podSecurityLevels["restricted"]
+	}
+	return podSecurityLevels["baseline"]
+}
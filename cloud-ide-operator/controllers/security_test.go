@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+func newSecurityWorkSpace(profile v1.SecurityProfile) *v1.WorkSpace {
+	return &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default"},
+		Spec: v1.WorkSpaceSpec{
+			Image:           "code-server",
+			MountPath:       "/root/workspace",
+			SecurityProfile: profile,
+		},
+	}
+}
+
+func TestApplySecurityProfile(t *testing.T) {
+	tests := []struct {
+		name         string
+		profile      v1.SecurityProfile
+		wantPod      bool
+		wantReadOnly bool
+		wantHome     bool
+	}{
+		{
+			name: "no profile",
+		},
+		{
+			name:         "restricted",
+			profile:      v1.SecurityProfileRestricted,
+			wantPod:      true,
+			wantReadOnly: true,
+			wantHome:     true,
+		},
+		{
+			name:    "baseline",
+			profile: v1.SecurityProfileBaseline,
+			wantPod: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := (&WorkSpaceReconciler{}).constructPod(newSecurityWorkSpace(tt.profile))
+			container := pod.Spec.Containers[0]
+
+			if got := pod.Spec.SecurityContext != nil; got != tt.wantPod {
+				t.Fatalf("pod security context set = %v, want %v", got, tt.wantPod)
+			}
+			if !tt.wantPod {
+				if container.SecurityContext != nil || pod.Labels[SecurityProfileLabel] != "" {
+					t.Errorf("pod without profile was modified: %+v", container.SecurityContext)
+				}
+				return
+			}
+			if pod.Labels[SecurityProfileLabel] != string(tt.profile) {
+				t.Errorf("profile label = %q, want %q", pod.Labels[SecurityProfileLabel], tt.profile)
+			}
+			readOnly := container.SecurityContext.ReadOnlyRootFilesystem != nil && *container.SecurityContext.ReadOnlyRootFilesystem
+			if readOnly != tt.wantReadOnly {
+				t.Errorf("read only root filesystem = %v, want %v", readOnly, tt.wantReadOnly)
+			}
+			if got := hasEnv(container.Env, "HOME"); got != tt.wantHome {
+				t.Errorf("HOME set = %v, want %v", got, tt.wantHome)
+			}
+		})
+	}
+}
+
+func TestApplySecurityProfileDocker(t *testing.T) {
+	space := newSecurityWorkSpace(v1.SecurityProfileRestricted)
+	space.Spec.Docker = &v1.WorkSpaceDocker{}
+	pod := (&WorkSpaceReconciler{}).constructPod(space)
+
+	if securityProfile(space) != v1.SecurityProfileRestricted {
+		t.Errorf("securityProfile() = %q, want %q", securityProfile(space), v1.SecurityProfileRestricted)
+	}
+	for _, container := range pod.Spec.Containers {
+		privileged := container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged
+		if privileged != (container.Name == dockerContainerName) {
+			t.Errorf("container %s privileged = %v", container.Name, privileged)
+		}
+	}
+	if sc := pod.Spec.Containers[0].SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("workspace container security context = %+v, want restricted", sc)
+	}
+}
+
+func TestCheckSecurityProfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		enforce   string
+		profile   v1.SecurityProfile
+		docker    bool
+		companion *v1.Companion
+		want      bool
+	}{
+		{
+			name:    "no label",
+			profile: v1.SecurityProfilePrivilegedForDocker,
+			want:    true,
+		},
+		{
+			name:    "restricted allows restricted",
+			enforce: "restricted",
+			profile: v1.SecurityProfileRestricted,
+			want:    true,
+		},
+		{
+			name:    "restricted forbids no profile",
+			enforce: "restricted",
+			want:    false,
+		},
+		{
+			name:    "baseline allows no profile",
+			enforce: "baseline",
+			want:    true,
+		},
+		{
+			name:    "baseline forbids privileged",
+			enforce: "baseline",
+			profile: v1.SecurityProfilePrivilegedForDocker,
+			want:    false,
+		},
+		{
+			name:    "privileged allows privileged",
+			enforce: "privileged",
+			profile: v1.SecurityProfilePrivilegedForDocker,
+			want:    true,
+		},
+		{
+			name:    "docker without profile",
+			enforce: "privileged",
+			docker:  true,
+			want:    true,
+		},
+		{
+			name:    "baseline forbids docker sidecar",
+			enforce: "baseline",
+			profile: v1.SecurityProfileRestricted,
+			docker:  true,
+			want:    false,
+		},
+		{
+			name:      "baseline forbids privileged companion",
+			enforce:   "baseline",
+			profile:   v1.SecurityProfileRestricted,
+			companion: &v1.Companion{Name: "postgres", Image: "postgres:16", SecurityContext: &corev1.SecurityContext{Privileged: pointer.Bool(true)}},
+			want:      false,
+		},
+		{
+			name:      "restricted forbids root companion",
+			enforce:   "restricted",
+			profile:   v1.SecurityProfileRestricted,
+			companion: &v1.Companion{Name: "postgres", Image: "postgres:16", SecurityContext: &corev1.SecurityContext{RunAsUser: pointer.Int64(0)}},
+			want:      false,
+		},
+		{
+			name:      "restricted allows companion without security context",
+			enforce:   "restricted",
+			profile:   v1.SecurityProfileRestricted,
+			companion: &v1.Companion{Name: "redis", Image: "redis:7"},
+			want:      true,
+		},
+		{
+			name:      "baseline allows companion with added capability",
+			enforce:   "baseline",
+			profile:   v1.SecurityProfileRestricted,
+			companion: &v1.Companion{Name: "postgres", Image: "postgres:16", SecurityContext: &corev1.SecurityContext{Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"CHOWN"}}}},
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			if tt.enforce != "" {
+				ns.Labels = map[string]string{podSecurityEnforceLabel: tt.enforce}
+			}
+			r := newTestReconciler(t, ns)
+			space := newSecurityWorkSpace(tt.profile)
+			if tt.docker {
+				space.Spec.Docker = &v1.WorkSpaceDocker{}
+			}
+			if tt.companion != nil {
+				space.Spec.Companions = []v1.Companion{*tt.companion}
+			}
+
+			allowed, err := r.checkSecurityProfile(context.Background(), space)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != tt.want {
+				t.Errorf("checkSecurityProfile() = %v, want %v", allowed, tt.want)
+			}
+			if !meta.IsStatusConditionPresentAndEqual(space.Status.Conditions, ConditionSecurityProfile, conditionStatus(tt.want)) {
+				t.Errorf("conditions = %+v", space.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestPodSecurityLevel(t *testing.T) {
+	restricted := func() *corev1.Pod {
+		return (&WorkSpaceReconciler{}).constructPod(newSecurityWorkSpace(v1.SecurityProfileRestricted))
+	}
+
+	tests := []struct {
+		name        string
+		mutate      func(pod *corev1.Pod)
+		want        string
+		wantSubject string
+	}{
+		{
+			name:        "restricted profile",
+			mutate:      func(pod *corev1.Pod) {},
+			want:        "restricted",
+			wantSubject: "pod",
+		},
+		{
+			name: "container without security context",
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "redis"})
+			},
+			want:        "baseline",
+			wantSubject: `container "redis"`,
+		},
+		{
+			name: "container runs as root",
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Name: "setup", SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: pointer.Bool(false),
+					RunAsUser:                pointer.Int64(0),
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				}})
+			},
+			want:        "baseline",
+			wantSubject: `init container "setup"`,
+		},
+		{
+			name: "privileged container",
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers = append(pod.Spec.Containers,
+					corev1.Container{Name: "redis"},
+					corev1.Container{Name: "docker", SecurityContext: &corev1.SecurityContext{Privileged: pointer.Bool(true)}})
+			},
+			want:        "privileged",
+			wantSubject: `container "docker"`,
+		},
+		{
+			name: "capability outside baseline",
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers[0].SecurityContext.Capabilities.Add = []corev1.Capability{"SYS_ADMIN"}
+			},
+			want:        "privileged",
+			wantSubject: `container "ws"`,
+		},
+		{
+			name: "hostPath volume",
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}})
+			},
+			want:        "privileged",
+			wantSubject: `hostPath volume "host"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := restricted()
+			tt.mutate(pod)
+			level, subject := podSecurityLevel(pod)
+			if level != podSecurityLevels[tt.want] || subject != tt.wantSubject {
+				t.Errorf("podSecurityLevel() = %d, %s, want %s, %s", level, subject, tt.want, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func conditionStatus(b bool) metav1.ConditionStatus {
+	if b {
+		return metav1.ConditionTrue
+	}
+	return metav1.ConditionFalse
+}
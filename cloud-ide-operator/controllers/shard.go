@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// shardLookupTimeout 事件过滤时读取命名空间的超时时间,缓存没有同步时不会一直阻塞事件处理
+const shardLookupTimeout = 10 * time.Second
+
+// shardPredicate 返回控制器使用的分片过滤器,命名空间从 manager 的缓存中读取。
+// 设置时注册命名空间的 informer,缓存随 manager 启动并在控制器启动前同步,事件过滤时不会请求 API server
+func shardPredicate(mgr ctrl.Manager, shard labels.Selector) (predicate.Predicate, error) {
+	if shard != nil && !shard.Empty() {
+		if _, err := mgr.GetCache().GetInformer(context.Background(), &corev1.Namespace{}); err != nil {
+			return nil, err
+		}
+	}
+	return predicateShard(mgr.GetCache(), shard), nil
+}
+
+// predicateShard 只处理命名空间的标签匹配分片选择器的对象,多个 operator 副本通过不同的选择器分担工作空间
+// 选择器为空时处理所有的命名空间
+func predicateShard(namespaces client.Reader, shard labels.Selector) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		// 命名空间本身的事件由 enqueueNamespaceWorkSpaces 过滤
+		if _, ok := obj.(*corev1.Namespace); ok || shard == nil || shard.Empty() {
+			return true
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shardLookupTimeout)
+		defer cancel()
+		ns := &corev1.Namespace{}
+		if err := namespaces.Get(ctx, client.ObjectKey{Name: obj.GetNamespace()}, ns); err != nil {
+			klog.Errorf("[Shard] get namespace %s error:%v", obj.GetNamespace(), err)
+			return false
+		}
+		return shard.Matches(labels.Set(ns.Labels))
+	})
+}
+
+// predicateNamespaceLabels 命名空间的标签变化时,命名空间可能被移入当前分片
+var predicateNamespaceLabels = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool {
+		return false
+	},
+	DeleteFunc: func(event.DeleteEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !labels.Equals(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+	},
+	GenericFunc: func(event.GenericEvent) bool {
+		return false
+	},
+}
+
+// enqueueNamespaceWorkSpaces 把命名空间中的所有工作空间加入队列
+func enqueueNamespaceWorkSpaces(c client.Client, shard labels.Selector) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(namespaceWorkSpaces(c, shard))
+}
+
+// namespaceWorkSpaces 返回属于当前分片的命名空间中的所有工作空间
+func namespaceWorkSpaces(c client.Client, shard labels.Selector) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		if shard == nil || shard.Empty() || !shard.Matches(labels.Set(obj.GetLabels())) {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shardLookupTimeout)
+		defer cancel()
+		spaces := &v1.WorkSpaceList{}
+		if err := c.List(ctx, spaces, client.InNamespace(obj.GetName())); err != nil {
+			klog.Errorf("[Shard] list workspaces in namespace %s error:%v", obj.GetName(), err)
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(spaces.Items))
+		for _, space := range spaces.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&space)})
+		}
+		return requests
+	}
+}
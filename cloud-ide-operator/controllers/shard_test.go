@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newShardNamespace(name string, lbls map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: lbls}}
+}
+
+func TestPredicateShard(t *testing.T) {
+	r := newTestReconciler(t,
+		newShardNamespace("team-a", map[string]string{"shard": "a"}),
+		newShardNamespace("team-b", map[string]string{"shard": "b"}),
+	)
+	shard := labels.SelectorFromSet(labels.Set{"shard": "a"})
+
+	tests := []struct {
+		name  string
+		shard labels.Selector
+		obj   client.Object
+		want  bool
+	}{
+		{
+			name: "no shard",
+			obj:  &v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "team-b"}},
+			want: true,
+		},
+		{
+			name:  "empty selector",
+			shard: labels.Everything(),
+			obj:   &v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "team-b"}},
+			want:  true,
+		},
+		{
+			name:  "namespace in shard",
+			shard: shard,
+			obj:   &v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "team-a"}},
+			want:  true,
+		},
+		{
+			name:  "namespace in other shard",
+			shard: shard,
+			obj:   &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "team-b"}},
+		},
+		{
+			name:  "namespace not found",
+			shard: shard,
+			obj:   &v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "missing"}},
+		},
+		{
+			// 命名空间的事件由 enqueueNamespaceWorkSpaces 过滤
+			name:  "namespace event",
+			shard: shard,
+			obj:   newShardNamespace("team-b", map[string]string{"shard": "b"}),
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := predicateShard(r.Client, tt.shard)
+			if got := p.Create(event.CreateEvent{Object: tt.obj}); got != tt.want {
+				t.Errorf("predicateShard() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceWorkSpaces(t *testing.T) {
+	teamA := newShardNamespace("team-a", map[string]string{"shard": "a"})
+	teamB := newShardNamespace("team-b", map[string]string{"shard": "b"})
+	r := newTestReconciler(t, teamA, teamB,
+		&v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws-1", Namespace: "team-a"}},
+		&v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws-2", Namespace: "team-a"}},
+		&v1.WorkSpace{ObjectMeta: metav1.ObjectMeta{Name: "ws-3", Namespace: "team-b"}},
+	)
+	shard := labels.SelectorFromSet(labels.Set{"shard": "a"})
+
+	if got := namespaceWorkSpaces(r.Client, shard)(teamA); len(got) != 2 || got[0].Namespace != "team-a" || got[1].Namespace != "team-a" {
+		t.Errorf("requests for team-a = %v, want ws-1 and ws-2", got)
+	}
+	if got := namespaceWorkSpaces(r.Client, shard)(teamB); len(got) != 0 {
+		t.Errorf("requests for team-b = %v, want none", got)
+	}
+	// 没有分片时所有的工作空间都已经在处理,不需要因为命名空间的变化重新入队
+	if got := namespaceWorkSpaces(r.Client, nil)(teamA); len(got) != 0 {
+		t.Errorf("requests without shard = %v, want none", got)
+	}
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 使用模板中的值填充工作空间中未设置的字段,只修改内存中的对象,不会写回 WorkSpace
+// 工作空间没有使用模板时返回的模板为 nil
+func (r *WorkSpaceReconciler) applyTemplate(ctx context.Context, space *v1.WorkSpace) (*v1.WorkSpaceTemplate, error) {
+	if space.Spec.Template == "" {
+		return nil, nil
+	}
+
+	tpl := &v1.WorkSpaceTemplate{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: space.Spec.Template, Namespace: space.Namespace}, tpl); err != nil {
+		klog.Errorf("get workspace template error:%v", err)
+		return nil, err
+	}
+	mergeTemplate(&space.Spec, &tpl.Spec)
+	return tpl, nil
+}
+
+func mergeTemplate(spec *v1.WorkSpaceSpec, tpl *v1.WorkSpaceTemplateSpec) {
+	if spec.Cpu == "" {
+		spec.Cpu = tpl.Cpu
+	}
+	if spec.Memory == "" {
+		spec.Memory = tpl.Memory
+	}
+	if spec.Storage == "" {
+		spec.Storage = tpl.Storage
+	}
+	if spec.Hardware == "" {
+		spec.Hardware = tpl.Hardware
+	}
+	if spec.Image == "" {
+		spec.Image = tpl.Image
+	}
+	if spec.Port == 0 {
+		spec.Port = tpl.Port
+	}
+	if spec.MountPath == "" {
+		spec.MountPath = tpl.MountPath
+	}
+	if spec.StorageClassName == nil {
+		spec.StorageClassName = tpl.StorageClassName
+	}
+	if len(spec.AccessModes) == 0 {
+		spec.AccessModes = tpl.AccessModes
+	}
+	if spec.VolumeMode == nil {
+		spec.VolumeMode = tpl.VolumeMode
+	}
+	if len(spec.SharedVolumes) == 0 {
+		spec.SharedVolumes = tpl.SharedVolumes
+	}
+	if spec.SecurityProfile == "" {
+		spec.SecurityProfile = tpl.SecurityProfile
+	}
+	if len(spec.Companions) == 0 {
+		spec.Companions = tpl.Companions
+	}
+	if spec.Ephemeral == nil {
+		spec.Ephemeral = tpl.Ephemeral
+	}
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/utils/pointer"
+)
+
+func TestMergeTemplate(t *testing.T) {
+	block := corev1.PersistentVolumeBlock
+	tpl := v1.WorkSpaceTemplateSpec{
+		Cpu:       "2",
+		Memory:    "4Gi",
+		Storage:   "10Gi",
+		Hardware:  "gpu",
+		Image:     "code-server:go",
+		Port:      8080,
+		MountPath: "/home/coder",
+		WorkSpaceVolumes: v1.WorkSpaceVolumes{
+			StorageClassName: pointer.String("fast"),
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			VolumeMode:       &block,
+			Ephemeral:        pointer.Bool(true),
+		},
+		SecurityProfile: v1.SecurityProfileBaseline,
+		Companions:      []v1.Companion{{Name: "postgres", Image: "postgres:15"}},
+	}
+
+	tests := []struct {
+		name string
+		spec v1.WorkSpaceSpec
+		want v1.WorkSpaceSpec
+	}{
+		{
+			name: "fill unset fields",
+			spec: v1.WorkSpaceSpec{},
+			want: v1.WorkSpaceSpec{
+				Cpu:              tpl.Cpu,
+				Memory:           tpl.Memory,
+				Storage:          tpl.Storage,
+				Hardware:         tpl.Hardware,
+				Image:            tpl.Image,
+				Port:             tpl.Port,
+				MountPath:        tpl.MountPath,
+				WorkSpaceVolumes: tpl.WorkSpaceVolumes,
+				SecurityProfile:  tpl.SecurityProfile,
+				Companions:       tpl.Companions,
+			},
+		},
+		{
+			name: "workspace wins",
+			spec: v1.WorkSpaceSpec{
+				Cpu:       "4",
+				Memory:    "8Gi",
+				Storage:   "20Gi",
+				Hardware:  "cpu",
+				Image:     "code-server:python",
+				Port:      9090,
+				MountPath: "/workspace",
+				WorkSpaceVolumes: v1.WorkSpaceVolumes{
+					StorageClassName: pointer.String("standard"),
+					AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Ephemeral:        pointer.Bool(false),
+				},
+				SecurityProfile: v1.SecurityProfileRestricted,
+				Companions:      []v1.Companion{{Name: "redis", Image: "redis:7"}},
+			},
+			want: v1.WorkSpaceSpec{
+				Cpu:       "4",
+				Memory:    "8Gi",
+				Storage:   "20Gi",
+				Hardware:  "cpu",
+				Image:     "code-server:python",
+				Port:      9090,
+				MountPath: "/workspace",
+				WorkSpaceVolumes: v1.WorkSpaceVolumes{
+					StorageClassName: pointer.String("standard"),
+					AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					VolumeMode:       &block,
+					Ephemeral:        pointer.Bool(false),
+				},
+				SecurityProfile: v1.SecurityProfileRestricted,
+				Companions:      []v1.Companion{{Name: "redis", Image: "redis:7"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec
+			mergeTemplate(&spec, &tpl)
+			if !equality.Semantic.DeepEqual(spec, tt.want) {
+				t.Errorf("mergeTemplate() = %+v, want %+v", spec, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 每种区间最多保留的数量,避免频繁启停的工作空间的 WorkSpaceUsage 无限增长
+const maxUsageIntervals = 1000
+
+// usageKey 使用工作空间的 UID 区分同名的工作空间,删除后重新创建的工作空间不会继承之前的使用记录
+func usageKey(space *v1.WorkSpace) client.ObjectKey {
+	return client.ObjectKey{Name: space.Name + "-" + string(space.UID), Namespace: space.Namespace}
+}
+
+// recordUsageStart 工作空间启动时开始一个运行区间,第一次启动时同时开始存储区间
+func (r *WorkSpaceReconciler) recordUsageStart(ctx context.Context, space *v1.WorkSpace) error {
+	usage, err := r.getOrCreateUsage(ctx, space)
+	if err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	changed := false
+	if n := len(usage.Status.Running); n == 0 || usage.Status.Running[n-1].End != nil {
+		usage.Status.Running = append(usage.Status.Running, v1.RunningInterval{
+			Start:    now,
+			Hardware: space.Spec.Hardware,
+			Cpu:      space.Spec.Cpu,
+			Memory:   space.Spec.Memory,
+		})
+		if n := len(usage.Status.Running); n > maxUsageIntervals {
+			usage.Status.Running = usage.Status.Running[n-maxUsageIntervals:]
+		}
+		changed = true
+	}
+	// 临时存储的工作空间不占用持久存储
+	if n := len(usage.Status.Storage); !isEphemeral(space) && (n == 0 || usage.Status.Storage[n-1].End != nil) {
+		usage.Status.Storage = append(usage.Status.Storage, v1.StorageInterval{
+			Start:   now,
+			Storage: space.Spec.Storage,
+		})
+		if n := len(usage.Status.Storage); n > maxUsageIntervals {
+			usage.Status.Storage = usage.Status.Storage[n-maxUsageIntervals:]
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return r.Client.Status().Update(ctx, usage)
+}
+
+// recordUsageStop 工作空间停止时结束运行区间,删除时同时结束存储区间
+// 工作空间删除后无法知道它的 UID,按工作空间名字的标签查找还没有结束的区间
+func (r *WorkSpaceReconciler) recordUsageStop(ctx context.Context, key client.ObjectKey, deleted bool) error {
+	usages := &v1.WorkSpaceUsageList{}
+	if err := r.Client.List(ctx, usages, client.InNamespace(key.Namespace), client.MatchingLabels{WorkSpaceLabel: key.Name}); err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	for i := range usages.Items {
+		usage := &usages.Items[i]
+		changed := false
+		if n := len(usage.Status.Running); n > 0 && usage.Status.Running[n-1].End == nil {
+			usage.Status.Running[n-1].End = &now
+			changed = true
+		}
+		if n := len(usage.Status.Storage); deleted && n > 0 && usage.Status.Storage[n-1].End == nil {
+			usage.Status.Storage[n-1].End = &now
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := r.Client.Status().Update(ctx, usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WorkSpaceUsage 不属于工作空间,工作空间删除后仍然保留,用于统计历史费用
+func (r *WorkSpaceReconciler) getOrCreateUsage(ctx context.Context, space *v1.WorkSpace) (*v1.WorkSpaceUsage, error) {
+	usage := &v1.WorkSpaceUsage{}
+	err := r.Client.Get(ctx, usageKey(space), usage)
+	if err == nil {
+		return usage, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	key := usageKey(space)
+	usage = &v1.WorkSpaceUsage{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels: map[string]string{
+				"app":          "cloud-ide",
+				WorkSpaceLabel: space.Name,
+			},
+		},
+		Spec: v1.WorkSpaceUsageSpec{
+			Workspace:    space.Name,
+			WorkspaceUID: space.UID,
+			User:         space.Spec.User,
+		},
+	}
+	if err := r.Client.Create(ctx, usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newUsageWorkSpace(uid types.UID, user string) *v1.WorkSpace {
+	return &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws", Namespace: "default", UID: uid},
+		Spec:       v1.WorkSpaceSpec{Cpu: "2", Memory: "4Gi", Storage: "10Gi", User: user},
+	}
+}
+
+func TestRecordUsageByUID(t *testing.T) {
+	r := newTestReconciler(t)
+	ctx := context.Background()
+
+	old := newUsageWorkSpace("uid-1", "alice")
+	if err := r.recordUsageStart(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	// 工作空间被删除后由另一个用户重新创建
+	if err := r.recordUsageStop(ctx, client.ObjectKeyFromObject(old), true); err != nil {
+		t.Fatal(err)
+	}
+	recreated := newUsageWorkSpace("uid-2", "bob")
+	if err := r.recordUsageStart(ctx, recreated); err != nil {
+		t.Fatal(err)
+	}
+
+	usages := &v1.WorkSpaceUsageList{}
+	if err := r.Client.List(ctx, usages); err != nil {
+		t.Fatal(err)
+	}
+	if len(usages.Items) != 2 {
+		t.Fatalf("got %d usages, want 2", len(usages.Items))
+	}
+	for _, usage := range usages.Items {
+		closed := usage.Status.Running[0].End != nil && usage.Status.Storage[0].End != nil
+		switch usage.Spec.WorkspaceUID {
+		case "uid-1":
+			if usage.Spec.User != "alice" || !closed {
+				t.Errorf("usage of the deleted workspace: %+v", usage)
+			}
+		case "uid-2":
+			if usage.Spec.User != "bob" || closed {
+				t.Errorf("usage of the recreated workspace: %+v", usage)
+			}
+		default:
+			t.Errorf("unexpected usage %s", usage.Name)
+		}
+	}
+}
+
+func TestRecordUsageCapsIntervals(t *testing.T) {
+	space := newUsageWorkSpace("uid-1", "alice")
+	usage := &v1.WorkSpaceUsage{ObjectMeta: metav1.ObjectMeta{
+		Name:      usageKey(space).Name,
+		Namespace: space.Namespace,
+		Labels:    map[string]string{WorkSpaceLabel: space.Name},
+	}}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < maxUsageIntervals; i++ {
+		end := metav1.NewTime(start.Add(time.Duration(i+1) * time.Second))
+		usage.Status.Running = append(usage.Status.Running, v1.RunningInterval{
+			Start: metav1.NewTime(start.Add(time.Duration(i) * time.Second)),
+			End:   &end,
+		})
+	}
+	first := usage.Status.Running[1].Start
+
+	r := newTestReconciler(t, usage)
+	ctx := context.Background()
+	if err := r.recordUsageStart(ctx, space); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, usageKey(space), usage); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(usage.Status.Running); n != maxUsageIntervals {
+		t.Fatalf("got %d running intervals, want %d", n, maxUsageIntervals)
+	}
+	if !usage.Status.Running[0].Start.Equal(&first) {
+		t.Errorf("oldest interval starts at %v, want %v", usage.Status.Running[0].Start, first)
+	}
+	if usage.Status.Running[maxUsageIntervals-1].End != nil {
+		t.Error("new running interval is closed")
+	}
+}
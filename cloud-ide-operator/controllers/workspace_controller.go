@@ -19,15 +19,19 @@ package controllers
 import (
 	"context"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsv1 "github.com/costa92/cloud-ide-operator/api/v1"
 )
@@ -39,15 +43,44 @@ const (
 	ModDev       = "dev"
 )
 
+// WorkSpaceLabel 工作空间 Pod 上记录所属工作空间名字的标签
+const WorkSpaceLabel = "apps.costalong.com/workspace"
+
 // WorkSpaceReconciler reconciles a WorkSpace object
 type WorkSpaceReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// 直接读取 API Server,用于查询不在缓存中的事件
+	APIReader client.Reader
+	// 工作空间网络隔离的配置
+	NetworkPolicy NetworkPolicyConfig
+	// 暴露端口的预览地址配置
+	Preview PreviewConfig
+
+	// 同时执行的 Reconcile 的数量
+	MaxConcurrentReconciles int
+	// 每次 Reconcile 访问 API Server 的超时时间
+	ReconcileTimeout time.Duration
+	// 分片选择器,只处理命名空间标签匹配的工作空间,为空时处理所有的命名空间
+	Shard labels.Selector
 }
 
 //+kubebuilder:rbac:groups=apps.costalong.com,resources=workspaces,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.costalong.com,resources=workspaces/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps.costalong.com,resources=workspaces/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacetemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=hardwareclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspaceusages,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspaceusages/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacebackuppolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -61,23 +94,38 @@ type WorkSpaceReconciler struct {
 // Reconcile的意思是协调
 func (r *WorkSpaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
+	if r.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.ReconcileTimeout)
+		defer cancel()
+	}
 
 	// 先查询 WorkSpace
 	wp := appsv1.WorkSpace{}
-	err := r.Client.Get(context.Background(), req.NamespacedName, &wp)
+	err := r.Client.Get(ctx, req.NamespacedName, &wp)
 
 	// case 1  没有查到 Workspace，说明 WorkSpace 被删除了，删除对应的Pod 和 PVC 即可
 	if err != nil {
 		if errors.IsNotFound(err) {
-			if e1 := r.deletePod(req.NamespacedName); e1 != nil {
+			if e1 := r.deletePod(ctx, req.NamespacedName); e1 != nil {
 				klog.Errorf("[Delete Workspace] delete pod error:%v", e1)
 				return ctrl.Result{Requeue: true}, e1
 			}
 
-			if e2 := r.deletePVC(req.NamespacedName); e2 != nil {
+			if e2 := r.deletePVC(ctx, req.NamespacedName); e2 != nil {
 				klog.Errorf("[Delete Workspace] delete pvc error:%v", e2)
 				return ctrl.Result{Requeue: true}, e2
 			}
+
+			if e3 := r.deletePVC(ctx, dockerCacheKey(req.NamespacedName)); e3 != nil {
+				klog.Errorf("[Delete Workspace] delete docker cache pvc error:%v", e3)
+				return ctrl.Result{Requeue: true}, e3
+			}
+
+			if e4 := r.recordUsageStop(ctx, req.NamespacedName, true); e4 != nil {
+				klog.Errorf("[Delete Workspace] record usage error:%v", e4)
+				return ctrl.Result{Requeue: true}, e4
+			}
 			return ctrl.Result{}, nil
 		}
 		klog.Errorf("get workspace error:%v", err)
@@ -88,45 +136,147 @@ func (r *WorkSpaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	switch wp.Spec.Operation {
 	// case 2: 启动 workspace 检查 pvc 是否存在
 	case appsv1.WorkSpaceStart:
-		err := r.createPVC(&wp, req.NamespacedName)
+		// 使用模板填充未设置的字段
+		tpl, err := r.applyTemplate(ctx, &wp)
+		if err != nil {
+			klog.Errorf("[Start Workspace] apply template error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+
+		// 合并硬件规格对应的调度约束
+		if err := applyHardwareClass(ctx, r.Client, &wp.Spec); err != nil {
+			klog.Errorf("[Start Workspace] apply hardware class error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+
+		// 优先从模板的预热池中领取已经启动的 Pod 和 PVC
+		if err := r.claimFromPool(ctx, &wp, tpl); err != nil {
+			klog.Errorf("[Start Workspace] claim from pool error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+
+		// 命名空间不允许工作空间的安全配置档时不创建 Pod
+		allowed, err := r.checkSecurityProfile(ctx, &wp)
+		if err != nil {
+			klog.Errorf("[Start Workspace] check security profile error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		if !allowed {
+			r.updateStatus(ctx, &wp, appsv1.WorkspacePhaseStopped)
+			return ctrl.Result{}, nil
+		}
+
+		// 在 Pod 启动之前隔离工作空间的网络
+		if err := r.reconcileNetworkPolicy(ctx, &wp, tpl); err != nil {
+			klog.Errorf("[Start Workspace] reconcile network policy error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+
+		err = r.createPVC(ctx, &wp, claimKey(&wp))
 		if err != nil {
 			klog.Errorf("[start Workspace] create pvc error:%v", err)
 			return ctrl.Result{Requeue: true}, err
 		}
 
+		err = r.createDockerCache(ctx, &wp)
+		if err != nil {
+			klog.Errorf("[Start Workspace] create docker cache pvc error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+
+		// 从备份恢复存储卷,恢复完成后再启动 Pod
+		restored, err := r.restoreBackup(ctx, &wp)
+		if err != nil {
+			klog.Errorf("[Start Workspace] restore backup error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		if !restored {
+			r.updateStatus(ctx, &wp, appsv1.WorkspacePhaseStopped)
+			// 恢复失败后不再重新检查,修改工作空间后再次恢复
+			if restoreFailed(&wp) {
+				return ctrl.Result{}, nil
+			}
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+
 		// 创建Pod
-		err = r.createPod(&wp, req.NamespacedName)
+		err = r.createPod(ctx, &wp, podKey(&wp))
 		if err != nil {
 			klog.Errorf("[Start Workspace] create pod error:%v", err)
 			return ctrl.Result{Requeue: true}, err
 		}
-		r.updateStatus(&wp, appsv1.WorkspacePhaseRunning)
+		// 为暴露的端口生成预览地址
+		if err := r.reconcilePreview(ctx, &wp); err != nil {
+			klog.Errorf("[Start Workspace] reconcile preview error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		if err := r.recordUsageStart(ctx, &wp); err != nil {
+			klog.Errorf("[Start Workspace] record usage error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		r.checkPostStartHook(ctx, &wp)
+		r.updateStatus(ctx, &wp, appsv1.WorkspacePhaseRunning)
 	case appsv1.WorkSpaceStop:
 		// 删除 pod
-		err = r.deletePod(req.NamespacedName)
+		err = r.deletePod(ctx, podKey(&wp))
 		if err != nil {
 			klog.Errorf("[Stop workspace] delete pod error:%v", err)
 			return ctrl.Result{Requeue: true}, err
 		}
-		r.updateStatus(&wp, appsv1.WorkspacePhaseStopped)
+		if err := r.recordUsageStop(ctx, req.NamespacedName, false); err != nil {
+			klog.Errorf("[Stop workspace] record usage error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		// Pod 停止的过程中执行 preStop 钩子,等 Pod 删除后再确认钩子的结果
+		stopping := r.checkPreStopHook(ctx, &wp)
+		r.updateStatus(ctx, &wp, appsv1.WorkspacePhaseStopped)
+		if stopping {
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
 	}
 	return ctrl.Result{}, nil
 }
 
-func (r WorkSpaceReconciler) updateStatus(wp *appsv1.WorkSpace, phase appsv1.WorkSpacePhase) {
+func (r WorkSpaceReconciler) updateStatus(ctx context.Context, wp *appsv1.WorkSpace, phase appsv1.WorkSpacePhase) {
 	wp.Status.Phase = phase
-	err := r.Client.Status().Update(context.Background(), wp)
+	err := r.Client.Status().Update(ctx, wp)
 	if err != nil {
 		klog.Errorf("update status error:%v", err)
 	}
 }
 
+// 工作空间使用的 Pod,从预热池中领取时使用预热池中 Pod 的名字
+func podKey(space *appsv1.WorkSpace) client.ObjectKey {
+	if space.Status.PodName != "" {
+		return client.ObjectKey{Name: space.Status.PodName, Namespace: space.Namespace}
+	}
+	return client.ObjectKey{Name: space.Name, Namespace: space.Namespace}
+}
+
+// 工作空间使用的 PVC,从预热池中领取时使用预热池中 PVC 的名字
+func claimKey(space *appsv1.WorkSpace) client.ObjectKey {
+	if space.Status.ClaimName != "" {
+		return client.ObjectKey{Name: space.Status.ClaimName, Namespace: space.Namespace}
+	}
+	return client.ObjectKey{Name: space.Name, Namespace: space.Namespace}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *WorkSpaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	shard, err := shardPredicate(mgr, r.Shard)
+	if err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 8}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		WithEventFilter(shard).
 		For(&appsv1.WorkSpace{}).
 		Owns(&corev1.Pod{}, builder.WithPredicates(predicatePod)).
 		Owns(&corev1.PersistentVolumeClaim{}, builder.WithPredicates(predicatePVC)).
+		Owns(&networkingv1.NetworkPolicy{}, builder.WithPredicates(predicateOwnedDeleted)).
+		Owns(&corev1.Service{}, builder.WithPredicates(predicateOwnedDeleted)).
+		Owns(&networkingv1.Ingress{}, builder.WithPredicates(predicateOwnedDeleted)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, enqueueNamespaceWorkSpaces(mgr.GetClient(), r.Shard),
+			builder.WithPredicates(predicateNamespaceLabels)).
 		Complete(r)
 }
@@ -0,0 +1,241 @@
+/*
+Copyright 2023 Costalong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// WorkSpaceBackupPolicyReconciler reconciles a WorkSpaceBackupPolicy object
+// 按照策略的间隔为每个工作空间创建备份 Job,Job 结束后记录结果并删除超出保留数量的旧备份
+type WorkSpaceBackupPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// 分片选择器,和 WorkSpaceReconciler 使用相同的选择器
+	Shard labels.Selector
+}
+
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacebackuppolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacebackuppolicies/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspacebackuppolicies/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps.costalong.com,resources=workspaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+
+func (r *WorkSpaceBackupPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policy := &v1.WorkSpaceBackupPolicy{}
+	if err := r.Client.Get(ctx, req.NamespacedName, policy); err != nil {
+		// 策略被删除后,备份 Job 会被垃圾回收,对象存储中的备份保留
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		klog.Errorf("[Backup] get backup policy error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	selector := labels.Everything()
+	if policy.Spec.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.Selector); err != nil {
+			klog.Errorf("[Backup] invalid selector of backup policy %s:%v", req.NamespacedName, err)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	spaces := &v1.WorkSpaceList{}
+	if err := r.Client.List(ctx, spaces, client.InNamespace(policy.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		klog.Errorf("[Backup] list workspaces error:%v", err)
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	previous := make(map[string]v1.WorkSpaceBackupStatus, len(policy.Status.Workspaces))
+	for _, st := range policy.Status.Workspaces {
+		previous[st.Workspace] = st
+	}
+
+	now := time.Now()
+	interval := backupInterval(policy)
+	requeueAfter := interval
+	var statuses []v1.WorkSpaceBackupStatus
+	for i := range spaces.Items {
+		space := &spaces.Items[i]
+		// 临时存储和块设备的工作空间没有可以打包的文件
+		if isEphemeral(space) || isBlockVolume(space) {
+			continue
+		}
+
+		st, ok := previous[space.Name]
+		if !ok {
+			st = v1.WorkSpaceBackupStatus{Workspace: space.Name}
+		}
+		running, err := r.observeJob(ctx, policy.Namespace, &st)
+		if err != nil {
+			klog.Errorf("[Backup] observe backup job error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+		if running {
+			statuses = append(statuses, st)
+			continue
+		}
+
+		next := now
+		if st.LastBackupTime != nil {
+			next = st.LastBackupTime.Add(interval)
+		}
+		if !now.Before(next) {
+			started, err := r.startBackup(ctx, policy, space, &st, now)
+			if err != nil {
+				klog.Errorf("[Backup] start backup of workspace %s error:%v", space.Name, err)
+				return ctrl.Result{Requeue: true}, err
+			}
+			if started {
+				next = now.Add(interval)
+			}
+		}
+		if wait := next.Sub(now); wait > 0 && wait < requeueAfter {
+			requeueAfter = wait
+		}
+		statuses = append(statuses, st)
+	}
+
+	if !equality.Semantic.DeepEqual(policy.Status.Workspaces, statuses) {
+		policy.Status.Workspaces = statuses
+		if err := r.Client.Status().Update(ctx, policy); err != nil {
+			klog.Errorf("[Backup] update backup policy status error:%v", err)
+			return ctrl.Result{Requeue: true}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// observeJob 检查正在执行的备份 Job,结束后记录结果并删除 Job,返回 Job 是否仍在执行
+func (r *WorkSpaceBackupPolicyReconciler) observeJob(ctx context.Context, namespace string, st *v1.WorkSpaceBackupStatus) (bool, error) {
+	if st.ActiveJob == "" {
+		return false, nil
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: st.ActiveJob, Namespace: namespace}, job); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		// Job 在结束之前被删除
+		st.Phase = v1.BackupPhaseFailed
+		st.ActiveJob = ""
+		return false, nil
+	}
+
+	finished, succeeded := jobFinished(job)
+	if !finished {
+		return true, nil
+	}
+	if succeeded {
+		pruned := strings.Fields(job.Annotations[backupPruneAnnotation])
+		var backups []string
+		for _, b := range st.Backups {
+			if !contains(pruned, b) {
+				backups = append(backups, b)
+			}
+		}
+		st.Backups = append(backups, job.Annotations[backupNameAnnotation])
+		st.LastSuccessfulTime = job.Status.CompletionTime
+		st.Phase = v1.BackupPhaseSucceeded
+	} else {
+		st.Phase = v1.BackupPhaseFailed
+	}
+	st.ActiveJob = ""
+
+	propagation := metav1.DeletePropagationBackground
+	if err := r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	return false, nil
+}
+
+// startBackup 创建备份 Job,同一个 Job 在上传新的备份后删除超出保留数量的旧备份
+func (r *WorkSpaceBackupPolicyReconciler) startBackup(ctx context.Context, policy *v1.WorkSpaceBackupPolicy,
+	space *v1.WorkSpace, st *v1.WorkSpaceBackupStatus, now time.Time) (bool, error) {
+	// 工作空间还没有启动过,没有需要备份的存储卷
+	if err := r.Client.Get(ctx, claimKey(space), &corev1.PersistentVolumeClaim{}); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	backup := now.UTC().Format(backupNameLayout)
+	job := constructBackupJob(policy, backupJobName(space.Name, "backup-"+backup), space.Name, claimKey(space).Name, backup, backupScript)
+	if pruned := pruneBackups(st.Backups, policy.Spec.Retention); len(pruned) > 0 {
+		prune := strings.Join(pruned, " ")
+		job.Annotations[backupPruneAnnotation] = prune
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env,
+			corev1.EnvVar{Name: "PRUNE", Value: prune})
+	}
+
+	// 存储卷只能被一个节点挂载,工作空间运行时调度到工作空间所在的节点上
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(ctx, podKey(space), pod); err == nil && pod.Spec.NodeName != "" {
+		job.Spec.Template.Spec.NodeSelector = map[string]string{corev1.LabelHostname: pod.Spec.NodeName}
+	}
+
+	if err := controllerutil.SetControllerReference(policy, job, r.Scheme); err != nil {
+		return false, err
+	}
+	if err := r.Client.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		return false, err
+	}
+
+	st.ActiveJob = job.Name
+	st.Phase = v1.BackupPhaseRunning
+	st.LastBackupTime = &metav1.Time{Time: now}
+	return true, nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WorkSpaceBackupPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	shard, err := shardPredicate(mgr, r.Shard)
+	if err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		WithEventFilter(shard).
+		For(&v1.WorkSpaceBackupPolicy{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -19,11 +19,14 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -52,11 +55,59 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var prePullNamespace string
+	var prePullImages string
+	var prePullNodeSelector string
+	var prePullShard string
+	var enableNetworkPolicy bool
+	var gatewayNamespaceSelector string
+	var gatewayPodSelector string
+	var previewDomain string
+	var previewIngressClass string
+	var previewTLSSecret string
+	var previewPrivateAnnotations string
+	var maxConcurrentReconciles int
+	var reconcileTimeout time.Duration
+	var shardSelector string
+	var shardName string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&prePullNamespace, "prepull-namespace", "cloud-ide-operator-system",
+		"The namespace in which the image pre-pull DaemonSet is created.")
+	flag.StringVar(&prePullImages, "prepull-images", "",
+		"Comma separated list of images to pre-pull on nodes, in addition to the images of workspace templates.")
+	flag.StringVar(&prePullNodeSelector, "prepull-node-selector", "",
+		"Comma separated key=value labels selecting the nodes on which images are pre-pulled.")
+	flag.StringVar(&prePullShard, "prepull-shard", "",
+		"Name of the shard that runs the image pre-pull controller. Nodes and templates are cluster wide, "+
+			"so only one shard may manage the pre-pull DaemonSet. Leave empty when the operator is not sharded.")
+	flag.BoolVar(&enableNetworkPolicy, "enable-network-policy", false,
+		"Create a NetworkPolicy for each workspace that only allows traffic from the IDE gateway. "+
+			"Egress is limited to DNS and the egressAllowList of the workspace template, "+
+			"which must also allow e.g. the git servers of dotfiles and the extension marketplace.")
+	flag.StringVar(&gatewayNamespaceSelector, "gateway-namespace-selector", "kubernetes.io/metadata.name=ingress-nginx",
+		"Comma separated key=value labels selecting the namespaces of the IDE gateway or ingress controller.")
+	flag.StringVar(&gatewayPodSelector, "gateway-pod-selector", "",
+		"Comma separated key=value labels selecting the pods of the IDE gateway or ingress controller.")
+	flag.StringVar(&previewDomain, "preview-domain", "",
+		"Domain of workspace preview URLs, e.g. ide.example.com gives {port}-{name}.ide.example.com. "+
+			"Leave empty to only create in-cluster Services.")
+	flag.StringVar(&previewIngressClass, "preview-ingress-class", "", "IngressClass of the preview Ingresses.")
+	flag.StringVar(&previewTLSSecret, "preview-tls-secret", "", "Secret holding a wildcard certificate for the preview domain.")
+	flag.StringVar(&previewPrivateAnnotations, "preview-private-annotations", "",
+		"Comma separated key=value annotations added to the Ingress of private ports, e.g. to require gateway authentication.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 8,
+		"The maximum number of workspaces reconciled concurrently.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", time.Minute,
+		"Timeout of the API server calls made by a single workspace reconcile.")
+	flag.StringVar(&shardSelector, "shard-namespace-selector", "",
+		"Label selector of the namespaces handled by this operator, used to shard workspaces across operator deployments. "+
+			"Leave empty to handle all namespaces.")
+	flag.StringVar(&shardName, "shard-name", "",
+		"Name of the shard, each shard elects its own leader. Required when --shard-namespace-selector is set.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,13 +116,32 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	shard, err := labels.Parse(shardSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid shard namespace selector")
+		os.Exit(1)
+	}
+	if !shard.Empty() && shardName == "" {
+		setupLog.Error(nil, "--shard-name is required when --shard-namespace-selector is set")
+		os.Exit(1)
+	}
+	if enableNetworkPolicy && gatewayNamespaceSelector == "" && gatewayPodSelector == "" {
+		setupLog.Error(nil, "--gateway-namespace-selector or --gateway-pod-selector is required when --enable-network-policy is set")
+		os.Exit(1)
+	}
+	// 每个分片选举自己的 leader,同一个分片的多个副本中只有一个在工作
+	leaderElectionID := "c3c3da89.costalong.com"
+	if shardName != "" {
+		leaderElectionID = shardName + "." + leaderElectionID
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "c3c3da89.costalong.com",
+		LeaderElectionID:       leaderElectionID,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -89,11 +159,59 @@ func main() {
 		os.Exit(1)
 	}
 
+	networkPolicy := controllers.NetworkPolicyConfig{
+		Enabled:                  enableNetworkPolicy,
+		GatewayNamespaceSelector: parseLabels(gatewayNamespaceSelector),
+		GatewayPodSelector:       parseLabels(gatewayPodSelector),
+	}
 	if err = (&controllers.WorkSpaceReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		APIReader:     mgr.GetAPIReader(),
+		NetworkPolicy: networkPolicy,
+		Preview: controllers.PreviewConfig{
+			Domain:             previewDomain,
+			IngressClassName:   previewIngressClass,
+			TLSSecret:          previewTLSSecret,
+			PrivateAnnotations: parseLabels(previewPrivateAnnotations),
+		},
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		ReconcileTimeout:        reconcileTimeout,
+		Shard:                   shard,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WorkSpace")
+		os.Exit(1)
+	}
+	// 预拉取不区分命名空间,只在指定的分片中运行,由该分片的 leader 维护 DaemonSet 和节点标签
+	if runsPrePull(shardName, prePullShard) {
+		if err = (&controllers.ImagePrePullReconciler{
+			Client:       mgr.GetClient(),
+			Scheme:       mgr.GetScheme(),
+			Namespace:    prePullNamespace,
+			Images:       splitList(prePullImages),
+			NodeSelector: parseLabels(prePullNodeSelector),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ImagePrePull")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("image pre-pull is handled by another shard", "shard", shardName, "prepullShard", prePullShard)
+	}
+	if err = (&controllers.WarmPoolReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Shard:         shard,
+		NetworkPolicy: networkPolicy,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WarmPool")
+		os.Exit(1)
+	}
+	if err = (&controllers.WorkSpaceBackupPolicyReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+		Shard:  shard,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "WorkSpace")
+		setupLog.Error(err, "unable to create controller", "controller", "WorkSpaceBackupPolicy")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
@@ -113,3 +231,33 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// runsPrePull 返回当前分片是否运行预拉取控制器,没有分片时两者都为空
+func runsPrePull(shardName, prePullShard string) bool {
+	return shardName == prePullShard
+}
+
+// splitList 把逗号分隔的字符串拆分成列表,忽略空白项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseLabels 解析 key=value 形式的标签列表
+func parseLabels(s string) map[string]string {
+	items := splitList(s)
+	if len(items) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(items))
+	for _, item := range items {
+		key, value, _ := strings.Cut(item, "=")
+		labels[key] = value
+	}
+	return labels
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestRunsPrePull(t *testing.T) {
+	tests := []struct {
+		name         string
+		shardName    string
+		prePullShard string
+		want         bool
+	}{
+		{name: "not sharded", want: true},
+		{name: "prepull shard", shardName: "shard-a", prePullShard: "shard-a", want: true},
+		{name: "other shard", shardName: "shard-b", prePullShard: "shard-a"},
+		// 分片部署时没有指定预拉取分片,任何分片都不运行预拉取
+		{name: "sharded without prepull shard", shardName: "shard-a"},
+		{name: "unsharded with prepull shard", prePullShard: "shard-a"},
+	}
+	for _, tt := range tests {
+		if got := runsPrePull(tt.shardName, tt.prePullShard); got != tt.want {
+			t.Errorf("%s: runsPrePull(%q, %q) = %v, want %v", tt.name, tt.shardName, tt.prePullShard, got, tt.want)
+		}
+	}
+}
@@ -20,6 +20,10 @@ type CloudIdeServiceClient interface {
 	GetPodSpaceStatus(ctx context.Context, in *QueryOption, opts ...grpc.CallOption) (*WorkspaceStatus, error)
 	// 获取云IDE空间的信息
 	GetPodSpaceInfo(ctx context.Context, in *QueryOption, opts ...grpc.CallOption) (*WorkspaceRunningInfo, error)
+	// 克隆云IDE空间,复制来源空间的配置和存储卷
+	CloneSpace(ctx context.Context, in *CloneOption, opts ...grpc.CallOption) (*WorkspaceRunningInfo, error)
+	// 统计工作空间的使用量
+	GetUsage(ctx context.Context, in *UsageOption, opts ...grpc.CallOption) (*UsageResponse, error)
 }
 
 type cloudIdeServiceClient struct {
@@ -80,6 +84,24 @@ func (c *cloudIdeServiceClient) GetPodSpaceInfo(ctx context.Context, in *QueryOp
 	return out, nil
 }
 
+func (c *cloudIdeServiceClient) CloneSpace(ctx context.Context, in *CloneOption, opts ...grpc.CallOption) (*WorkspaceRunningInfo, error) {
+	out := new(WorkspaceRunningInfo)
+	err := c.cc.Invoke(ctx, "/pb.CloudIdeService/cloneSpace", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cloudIdeServiceClient) GetUsage(ctx context.Context, in *UsageOption, opts ...grpc.CallOption) (*UsageResponse, error) {
+	out := new(UsageResponse)
+	err := c.cc.Invoke(ctx, "/pb.CloudIdeService/getUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func NewCloudIdeServiceClient(cc grpc.ClientConnInterface) CloudIdeServiceClient {
 	return &cloudIdeServiceClient{cc}
 }
@@ -98,6 +120,10 @@ type CloudIdeServiceServer interface {
 	GetPodSpaceStatus(context.Context, *QueryOption) (*WorkspaceStatus, error)
 	// 获取云IDE空间Pod的信息
 	GetPodSpaceInfo(context.Context, *QueryOption) (*WorkspaceRunningInfo, error)
+	// 克隆云IDE空间,新空间使用和来源空间相同的配置,并复制来源空间的存储卷
+	CloneSpace(context.Context, *CloneOption) (*WorkspaceRunningInfo, error)
+	// 统计时间范围内的 CPU 小时、内存 GiB 小时和存储 GiB 天
+	GetUsage(context.Context, *UsageOption) (*UsageResponse, error)
 }
 
 // UnimplementedCloudIdeServiceServer can be embedded to have forward compatible implementations.
@@ -123,6 +149,13 @@ func (*UnimplementedCloudIdeServiceServer) GetPodSpaceInfo(context.Context, *Que
 	return nil, status.Errorf(codes.Unimplemented, "method GetPodSpaceInfo not implemented")
 }
 
+func (*UnimplementedCloudIdeServiceServer) CloneSpace(context.Context, *CloneOption) (*WorkspaceRunningInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloneSpace not implemented")
+}
+func (*UnimplementedCloudIdeServiceServer) GetUsage(context.Context, *UsageOption) (*UsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
+
 func RegisterCloudIdeServiceServer(s *grpc.Server, srv CloudIdeServiceServer) {
 	s.RegisterService(&_CloudIdeService_serviceDesc, srv)
 }
@@ -235,6 +268,42 @@ func _CloudIdeService_GetPodSpaceInfo_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CloudIdeService_CloneSpace_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloneOption)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CloudIdeServiceServer).CloneSpace(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CloudIdeService/CloneSpace",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CloudIdeServiceServer).CloneSpace(ctx, req.(*CloneOption))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CloudIdeService_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UsageOption)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CloudIdeServiceServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CloudIdeService/GetUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CloudIdeServiceServer).GetUsage(ctx, req.(*UsageOption))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _CloudIdeService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.CloudIdeService",
 	HandlerType: (*CloudIdeServiceServer)(nil),
@@ -263,6 +332,14 @@ var _CloudIdeService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "getPodSpaceInfo",
 			Handler:    _CloudIdeService_GetPodSpaceInfo_Handler,
 		},
+		{
+			MethodName: "cloneSpace",
+			Handler:    _CloudIdeService_CloneSpace_Handler,
+		},
+		{
+			MethodName: "getUsage",
+			Handler:    _CloudIdeService_GetUsage_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "pb/proto/service.proto",
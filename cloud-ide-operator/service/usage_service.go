@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	"github.com/costa92/cloud-ide-operator/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var WorkspaceUsageFailed = "get workspace usage error"
+
+const gib = 1 << 30
+
+// GetUsage 统计时间范围内每个工作空间、用户和命名空间的 CPU 小时、内存 GiB 小时和存储 GiB 天
+func (s *WorkSpaceService) GetUsage(ctx context.Context, option *pb.UsageOption) (*pb.UsageResponse, error) {
+	to := time.Now()
+	if option.End != 0 {
+		to = time.Unix(option.End, 0)
+	}
+	from := time.Unix(option.Start, 0)
+	if !from.Before(to) {
+		return &pb.UsageResponse{}, status.Error(codes.InvalidArgument, "start must be before end")
+	}
+
+	var usages v1.WorkSpaceUsageList
+	if err := s.client.List(ctx, &usages, client.InNamespace(option.Namespace)); err != nil {
+		klog.Errorf("list workspace usage error:%v", err)
+		return &pb.UsageResponse{}, status.Error(codes.Internal, WorkspaceUsageFailed)
+	}
+
+	resp := &pb.UsageResponse{}
+	users := map[string]*pb.UsageReport{}
+	namespaces := map[string]*pb.UsageReport{}
+	for i := range usages.Items {
+		usage := &usages.Items[i]
+		if option.Name != "" && usage.Spec.Workspace != option.Name {
+			continue
+		}
+		if option.User != "" && usage.Spec.User != option.User {
+			continue
+		}
+
+		report := usageReport(usage, from, to)
+		resp.Workspaces = append(resp.Workspaces, report)
+
+		user, ok := users[report.User]
+		if !ok {
+			user = &pb.UsageReport{User: report.User}
+			users[report.User] = user
+		}
+		addUsage(user, report)
+
+		ns, ok := namespaces[report.Namespace]
+		if !ok {
+			ns = &pb.UsageReport{Namespace: report.Namespace}
+			namespaces[report.Namespace] = ns
+		}
+		addUsage(ns, report)
+	}
+
+	for _, r := range users {
+		resp.Users = append(resp.Users, r)
+	}
+	for _, r := range namespaces {
+		resp.Namespaces = append(resp.Namespaces, r)
+	}
+	sort.Slice(resp.Users, func(i, j int) bool { return resp.Users[i].User < resp.Users[j].User })
+	sort.Slice(resp.Namespaces, func(i, j int) bool { return resp.Namespaces[i].Namespace < resp.Namespaces[j].Namespace })
+	return resp, nil
+}
+
+// usageReport 统计一个工作空间在时间范围内的使用量,没有结束的区间统计到 to
+func usageReport(usage *v1.WorkSpaceUsage, from, to time.Time) *pb.UsageReport {
+	report := &pb.UsageReport{
+		Name:      usage.Spec.Workspace,
+		Namespace: usage.Namespace,
+		User:      usage.Spec.User,
+	}
+	for _, interval := range usage.Status.Running {
+		hours := overlap(interval.Start, interval.End, from, to).Hours()
+		if hours == 0 {
+			continue
+		}
+		if q, err := resource.ParseQuantity(interval.Cpu); err == nil {
+			report.CpuHours += float64(q.MilliValue()) / 1000 * hours
+		}
+		if q, err := resource.ParseQuantity(interval.Memory); err == nil {
+			report.MemoryHours += float64(q.Value()) / gib * hours
+		}
+	}
+	for _, interval := range usage.Status.Storage {
+		days := overlap(interval.Start, interval.End, from, to).Hours() / 24
+		if q, err := resource.ParseQuantity(interval.Storage); err == nil {
+			report.StorageDays += float64(q.Value()) / gib * days
+		}
+	}
+	return report
+}
+
+// overlap 返回区间 [start, end) 和 [from, to) 重叠的时长
+func overlap(start metav1.Time, end *metav1.Time, from, to time.Time) time.Duration {
+	s, e := start.Time, to
+	if end != nil && end.Time.Before(to) {
+		e = end.Time
+	}
+	if s.Before(from) {
+		s = from
+	}
+	if !s.Before(e) {
+		return 0
+	}
+	return e.Sub(s)
+}
+
+func addUsage(total, report *pb.UsageReport) {
+	total.CpuHours += report.CpuHours
+	total.MemoryHours += report.MemoryHours
+	total.StorageDays += report.StorageDays
+}
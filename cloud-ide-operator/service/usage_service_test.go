@@ -0,0 +1,83 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOverlap(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+	end := func(hours int) *metav1.Time { t := metav1.NewTime(at(hours)); return &t }
+
+	tests := []struct {
+		name  string
+		start int
+		end   *metav1.Time
+		from  int
+		to    int
+		want  time.Duration
+	}{
+		{name: "inside", start: 2, end: end(4), from: 0, to: 10, want: 2 * time.Hour},
+		{name: "starts before range", start: 0, end: end(4), from: 2, to: 10, want: 2 * time.Hour},
+		{name: "ends after range", start: 8, end: end(12), from: 0, to: 10, want: 2 * time.Hour},
+		{name: "covers range", start: 0, end: end(12), from: 2, to: 10, want: 8 * time.Hour},
+		{name: "still running", start: 8, end: nil, from: 0, to: 10, want: 2 * time.Hour},
+		{name: "before range", start: 0, end: end(1), from: 2, to: 10, want: 0},
+		{name: "after range", start: 11, end: nil, from: 2, to: 10, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := overlap(metav1.NewTime(at(tt.start)), tt.end, at(tt.from), at(tt.to))
+			if got != tt.want {
+				t.Errorf("overlap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) metav1.Time { return metav1.NewTime(base.Add(time.Duration(hours) * time.Hour)) }
+	end := func(hours int) *metav1.Time { t := at(hours); return &t }
+
+	usage := &v1.WorkSpaceUsage{
+		ObjectMeta: metav1.ObjectMeta{Name: "ws-uid", Namespace: "default"},
+		Spec:       v1.WorkSpaceUsageSpec{Workspace: "ws", User: "alice"},
+		Status: v1.WorkSpaceUsageStatus{
+			Running: []v1.RunningInterval{
+				{Start: at(0), End: end(2), Cpu: "2", Memory: "4Gi"},
+				{Start: at(10), End: end(13), Cpu: "500m", Memory: "512Mi"},
+				// 还在运行,统计到 to
+				{Start: at(46), Cpu: "1", Memory: "1Gi"},
+			},
+			Storage: []v1.StorageInterval{
+				{Start: at(0), Storage: "10Gi"},
+			},
+		},
+	}
+
+	report := usageReport(usage, base.Add(time.Hour), base.Add(48*time.Hour))
+	if report.Name != "ws" || report.Namespace != "default" || report.User != "alice" {
+		t.Errorf("report = %+v", report)
+	}
+
+	want := map[string][2]float64{
+		// 2 核 1 小时 + 0.5 核 3 小时 + 1 核 2 小时
+		"cpu": {report.CpuHours, 2*1 + 0.5*3 + 1*2},
+		// 4GiB 1 小时 + 0.5GiB 3 小时 + 1GiB 2 小时
+		"memory": {report.MemoryHours, 4*1 + 0.5*3 + 1*2},
+		// 10GiB 47 小时
+		"storage": {report.StorageDays, 10 * 47.0 / 24},
+	}
+	for name, v := range want {
+		if math.Abs(v[0]-v[1]) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, v[0], v[1])
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
 	"strings"
 	"time"
 )
@@ -28,6 +29,7 @@ var (
 	WorkspaceNotExist     = "workspace not exist"
 	WorkspaceStartFailed  = "start workspace error"
 	WorkspaceDeleteFailed = "delete workspace error"
+	WorkspaceCloneFailed  = "clone workspace error"
 )
 
 var (
@@ -38,6 +40,8 @@ var (
 )
 
 type WorkSpaceService struct {
+	// proto 中新增的方法在实现之前返回 Unimplemented
+	pb.UnimplementedCloudIdeServiceServer
 	client client.Client
 }
 
@@ -70,6 +74,138 @@ func (s *WorkSpaceService) CreateSpace(ctx context.Context, info *pb.WorkspaceIn
 	return EmptyWorkspaceRunningInfo, nil
 }
 
+// StartSpace 启动已经停止的工作空间,将Operation字段置为"Start",等待Pod运行后返回Pod的运行信息
+func (s *WorkSpaceService) StartSpace(ctx context.Context, info *pb.WorkspaceInfo) (*pb.WorkspaceRunningInfo, error) {
+	var wp v1.WorkSpace
+	exist := true
+	// 使用 Update时 可能由于版本冲突而导致失败，需要重试
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if !s.checkWorkspaceExist(ctx, client.ObjectKey{Name: info.Name, Namespace: info.Namespace}, &wp) {
+			exist = false
+			return nil
+		}
+		if wp.Spec.Operation == v1.WorkSpaceStart {
+			return nil
+		}
+
+		wp.Spec.Operation = v1.WorkSpaceStart
+		if err := s.client.Update(ctx, &wp); err != nil {
+			klog.Errorf("update workspace to start error:%v", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return EmptyWorkspaceRunningInfo, status.Error(codes.Unknown, WorkspaceStartFailed)
+	}
+	if !exist {
+		return EmptyWorkspaceRunningInfo, status.Error(codes.NotFound, WorkspaceNotExist)
+	}
+
+	return s.waiteForPodRunning(ctx, podKey(&wp), wp)
+}
+
+// DeleteSpace 删除工作空间,对应的Pod和PVC由控制器删除
+func (s *WorkSpaceService) DeleteSpace(ctx context.Context, option *pb.QueryOption) (*pb.Response, error) {
+	wp := &v1.WorkSpace{}
+	wp.Name = option.Name
+	wp.Namespace = option.Namespace
+	if err := s.client.Delete(ctx, wp); err != nil {
+		if errors.IsNotFound(err) {
+			return EmptyResponse, status.Error(codes.NotFound, WorkspaceNotExist)
+		}
+		klog.Errorf("delete workspace error:%v", err)
+		return EmptyResponse, status.Error(codes.Internal, WorkspaceDeleteFailed)
+	}
+	return EmptyResponse, nil
+}
+
+// GetPodSpaceStatus 获取工作空间 Pod 的状态,Pod 不存在时状态为 PodNotExist
+func (s *WorkSpaceService) GetPodSpaceStatus(ctx context.Context, option *pb.QueryOption) (*pb.WorkspaceStatus, error) {
+	var wp v1.WorkSpace
+	if !s.checkWorkspaceExist(ctx, client.ObjectKey{Name: option.Name, Namespace: option.Namespace}, &wp) {
+		return &pb.WorkspaceStatus{Status: PodNotExist, Message: "NotExist"}, nil
+	}
+
+	po := v12.Pod{}
+	if err := s.client.Get(ctx, podKey(&wp), &po); err != nil {
+		if errors.IsNotFound(err) {
+			return &pb.WorkspaceStatus{Status: PodNotExist, Message: "NotExist"}, nil
+		}
+		klog.Errorf("get pod error:%v", err)
+		return EmptyWorkspaceStatus, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.WorkspaceStatus{Status: PodExit, Message: string(po.Status.Phase)}, nil
+}
+
+// CloneSpace 克隆工作空间,新工作空间使用来源工作空间的配置,创建PVC时复制来源工作空间的存储卷
+func (s *WorkSpaceService) CloneSpace(ctx context.Context, option *pb.CloneOption) (*pb.WorkspaceRunningInfo, error) {
+	var source v1.WorkSpace
+	if !s.checkWorkspaceExist(ctx, client.ObjectKey{Name: option.SourceName, Namespace: option.Namespace}, &source) {
+		return EmptyWorkspaceRunningInfo, status.Error(codes.NotFound, WorkspaceNotExist)
+	}
+
+	var wp v1.WorkSpace
+	if s.checkWorkspaceExist(ctx, client.ObjectKey{Name: option.Name, Namespace: option.Namespace}, &wp) {
+		return EmptyWorkspaceRunningInfo, status.Error(codes.AlreadyExists, WorkspaceAlreadyExist)
+	}
+
+	w := &v1.WorkSpace{
+		TypeMeta: source.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      option.Name,
+			Namespace: option.Namespace,
+		},
+		Spec: *source.Spec.DeepCopy(),
+	}
+	w.Spec.Operation = v1.WorkSpaceStart
+	w.Spec.SourceWorkspace = source.Name
+	w.Spec.SourceSnapshot = option.SourceSnapshot
+	// 用户、备份恢复和暴露的端口属于来源工作空间本身,不复制到新工作空间
+	w.Spec.User = option.User
+	w.Spec.RestoreFrom = nil
+	w.Spec.Ports = nil
+
+	if err := s.client.Create(ctx, w); err != nil {
+		if errors.IsAlreadyExists(err) {
+			return EmptyWorkspaceRunningInfo, status.Error(codes.AlreadyExists, WorkspaceAlreadyExist)
+		}
+		klog.Errorf("clone workspace error:%v", err)
+		return EmptyWorkspaceRunningInfo, status.Error(codes.Internal, WorkspaceCloneFailed)
+	}
+	return EmptyWorkspaceRunningInfo, nil
+}
+
+// GetPodSpaceInfo 获取工作空间 Pod 运行的节点、IP 和端口,以及暴露端口的预览地址
+func (s *WorkSpaceService) GetPodSpaceInfo(ctx context.Context, option *pb.QueryOption) (*pb.WorkspaceRunningInfo, error) {
+	var wp v1.WorkSpace
+	if !s.checkWorkspaceExist(ctx, client.ObjectKey{Name: option.Name, Namespace: option.Namespace}, &wp) {
+		return EmptyWorkspaceRunningInfo, status.Error(codes.NotFound, WorkspaceNotExist)
+	}
+
+	info := &pb.WorkspaceRunningInfo{Port: wp.Spec.Port}
+	po := v12.Pod{}
+	if err := s.client.Get(ctx, podKey(&wp), &po); err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("get pod error:%v", err)
+			return EmptyWorkspaceRunningInfo, status.Error(codes.Internal, err.Error())
+		}
+	} else {
+		info.NodeName = po.Spec.NodeName
+		info.Ip = po.Status.PodIP
+	}
+
+	for _, p := range wp.Status.Previews {
+		info.Previews = append(info.Previews, &pb.PreviewURL{
+			Name:   p.Name,
+			Port:   p.Port,
+			Public: p.Public,
+			Url:    p.URL,
+		})
+	}
+	return info, nil
+}
+
 func (s *WorkSpaceService) waiteForPodRunning(ctx context.Context, key client.ObjectKey, space v1.WorkSpace) (*pb.WorkspaceRunningInfo, error) {
 	// 获取Pod运行的信息。可能会英文资源不足而导致Pod无法运行
 	// 最多重试四次，如果还不行，就停止工作空间
@@ -87,7 +223,7 @@ loop:
 				break loop
 			}
 			// 先休眠,等待Pod被创建并且运行起来
-			time.Sleep(sleepDuration[retry])
+			time.Sleep(sleepDuration[retry] * time.Second)
 
 			if err := s.client.Get(context.Background(), key, &po); err != nil {
 				if !errors.IsNotFound(err) {
@@ -106,24 +242,27 @@ loop:
 		}
 	}
 	// 5 处理错误情况 停止空间
-	if err := s.StopSpace(ctx, &space); err != nil {
-
+	if _, err := s.StopSpace(ctx, &pb.QueryOption{Name: space.Name, Namespace: space.Namespace}); err != nil {
+		klog.Errorf("stop workspace error:%v", err)
 	}
 	return EmptyWorkspaceRunningInfo, status.New(codes.Internal, WorkspaceStartFailed).Err()
 }
-func (s *WorkSpaceService) StopSpace(ctx context.Context, space *v1.WorkSpace) (*pb.Response, error) {
+
+// StopSpace 停止工作空间,将Operation字段置为"Stop",控制器会删除Pod,保留PVC
+func (s *WorkSpaceService) StopSpace(ctx context.Context, option *pb.QueryOption) (*pb.Response, error) {
 	// 使用 Update时 可能由于版本冲突而导致失败，需要重试
 	exist := true
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		var wp v1.WorkSpace
-		exits := s.checkWorkspaceExist(ctx, client.ObjectKey{Name: space.Name, Namespace: space.Namespace}, &wp)
+		exits := s.checkWorkspaceExist(ctx, client.ObjectKey{Name: option.Name, Namespace: option.Namespace}, &wp)
 		if !exits {
+			exist = false
 			return nil
 		}
 
 		// 更新Workspace 的Operation 字段
-		space.Spec.Operation = v1.WorkSpaceStop
-		if err := s.client.Update(ctx, space); err != nil {
+		wp.Spec.Operation = v1.WorkSpaceStop
+		if err := s.client.Update(ctx, &wp); err != nil {
 			klog.Errorf("update workspace to start error:%v", err)
 			return err
 		}
@@ -143,15 +282,31 @@ func (s *WorkSpaceService) checkWorkspaceExist(ctx context.Context, key client.O
 		if errors.IsNotFound(err) {
 			return false
 		}
-		klog.Error("get workspace error:%v", err)
+		klog.Errorf("get workspace error:%v", err)
 		return false
 	}
 	return true
 }
 
+// 工作空间使用的 Pod,从预热池中领取时使用预热池中 Pod 的名字
+func podKey(space *v1.WorkSpace) client.ObjectKey {
+	if space.Status.PodName != "" {
+		return client.ObjectKey{Name: space.Status.PodName, Namespace: space.Namespace}
+	}
+	return client.ObjectKey{Name: space.Name, Namespace: space.Namespace}
+}
+
 func (s *WorkSpaceService) constructWorkspace(space *pb.WorkspaceInfo) *v1.WorkSpace {
-	hardware := fmt.Sprintf("%sC%s%s", space.ResourceLimit.Cpu,
-		strings.Split(space.ResourceLimit.Memory, "i")[0], strings.Split(space.ResourceLimit.Storage, "i")[0])
+	// 使用模板时可以不设置资源限制,由模板提供
+	limit := space.ResourceLimit
+	if limit == nil {
+		limit = &pb.ResourceLimit{}
+	}
+	var hardware string
+	if limit.Cpu != "" {
+		hardware = fmt.Sprintf("%sC%s%s", limit.Cpu,
+			strings.Split(limit.Memory, "i")[0], strings.Split(limit.Storage, "i")[0])
+	}
 
 	return &v1.WorkSpace{
 		TypeMeta: metav1.TypeMeta{
@@ -163,14 +318,83 @@ func (s *WorkSpaceService) constructWorkspace(space *pb.WorkspaceInfo) *v1.WorkS
 			Namespace: space.Namespace,
 		},
 		Spec: v1.WorkSpaceSpec{
-			Cpu:       space.ResourceLimit.Cpu,
-			Memory:    space.ResourceLimit.Memory,
-			Storage:   space.ResourceLimit.Storage,
-			Hardware:  hardware,
-			Image:     space.Image,
-			Port:      space.Port,
-			MountPath: space.VolumeMountPath,
-			Operation: v1.WorkSpaceStart,
+			Cpu:          limit.Cpu,
+			Memory:       limit.Memory,
+			Storage:      limit.Storage,
+			Hardware:     hardware,
+			Image:        space.Image,
+			Port:         space.Port,
+			MountPath:    space.VolumeMountPath,
+			Operation:    v1.WorkSpaceStart,
+			Template:     space.Template,
+			User:         space.User,
+			Ports:        constructPorts(space.Ports),
+			Env:          constructEnv(space.Env),
+			EnvFrom:      constructEnvFrom(space.Secrets, space.ConfigMaps),
+			SecretMounts: constructSecretMounts(space.SecretMounts),
+			Dotfiles:     constructDotfiles(space.DotfilesRepository, space.DotfilesConfigMap),
 		},
 	}
 }
+
+func constructPorts(ports []*pb.ExposedPort) []v1.ExposedPort {
+	if len(ports) == 0 {
+		return nil
+	}
+	res := make([]v1.ExposedPort, 0, len(ports))
+	for _, p := range ports {
+		res = append(res, v1.ExposedPort{Name: p.Name, Port: p.Port, Public: p.Public})
+	}
+	return res
+}
+
+// 按名字排序,保证每次生成的环境变量顺序一致
+func constructEnv(env map[string]string) []v12.EnvVar {
+	if len(env) == 0 {
+		return nil
+	}
+	vars := make([]v12.EnvVar, 0, len(env))
+	for name, value := range env {
+		vars = append(vars, v12.EnvVar{Name: name, Value: value})
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+	return vars
+}
+
+func constructEnvFrom(secrets, configMaps []string) []v12.EnvFromSource {
+	var sources []v12.EnvFromSource
+	for _, name := range secrets {
+		sources = append(sources, v12.EnvFromSource{
+			SecretRef: &v12.SecretEnvSource{LocalObjectReference: v12.LocalObjectReference{Name: name}},
+		})
+	}
+	for _, name := range configMaps {
+		sources = append(sources, v12.EnvFromSource{
+			ConfigMapRef: &v12.ConfigMapEnvSource{LocalObjectReference: v12.LocalObjectReference{Name: name}},
+		})
+	}
+	return sources
+}
+
+func constructSecretMounts(mounts []*pb.SecretMount) []v1.SecretMount {
+	var secretMounts []v1.SecretMount
+	for _, m := range mounts {
+		secretMounts = append(secretMounts, v1.SecretMount{
+			SecretName: m.Name,
+			MountPath:  m.MountPath,
+		})
+	}
+	return secretMounts
+}
+
+func constructDotfiles(repository, configMap string) *v1.Dotfiles {
+	if repository == "" && configMap == "" {
+		return nil
+	}
+	return &v1.Dotfiles{
+		Repository: repository,
+		ConfigMap:  configMap,
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/costa92/cloud-ide-operator/api/v1"
+	"github.com/costa92/cloud-ide-operator/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestService(t *testing.T, objects ...client.Object) *WorkSpaceService {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return NewWorkSpaceService(fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build())
+}
+
+func newTestWorkSpace(name string) *v1.WorkSpace {
+	return &v1.WorkSpace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.WorkSpaceSpec{
+			Cpu:       "2",
+			Memory:    "4Gi",
+			Storage:   "10Gi",
+			Image:     "code-server:go",
+			Port:      8080,
+			MountPath: "/home/coder",
+			Operation: v1.WorkSpaceStop,
+			User:      "alice",
+			Template:  "go",
+			Env:       []corev1.EnvVar{{Name: "GOPROXY", Value: "direct"}},
+			Ports:     []v1.ExposedPort{{Name: "web", Port: 3000, Public: true}},
+			RestoreFrom: &v1.BackupRestore{
+				Policy:    "daily",
+				Workspace: "old",
+				Backup:    "20230101",
+			},
+		},
+		Status: v1.WorkSpaceStatus{
+			Phase:     v1.WorkspacePhaseStopped,
+			PodName:   "go-pool-abcde",
+			ClaimName: "go-pool-abcde",
+			Previews:  []v1.PreviewURL{{Name: "web", Port: 3000, URL: "https://web.example.com"}},
+		},
+	}
+}
+
+func TestCloneSpace(t *testing.T) {
+	source := newTestWorkSpace("source")
+	s := newTestService(t, source)
+	ctx := context.Background()
+
+	_, err := s.CloneSpace(ctx, &pb.CloneOption{
+		Name:           "clone",
+		Namespace:      "default",
+		SourceName:     "source",
+		SourceSnapshot: "snap",
+		User:           "bob",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone := &v1.WorkSpace{}
+	if err := s.client.Get(ctx, client.ObjectKey{Name: "clone", Namespace: "default"}, clone); err != nil {
+		t.Fatal(err)
+	}
+	if clone.Spec.User != "bob" {
+		t.Errorf("user = %q, want bob", clone.Spec.User)
+	}
+	if clone.Spec.RestoreFrom != nil {
+		t.Errorf("restoreFrom = %+v, want nil", clone.Spec.RestoreFrom)
+	}
+	if len(clone.Spec.Ports) != 0 {
+		t.Errorf("ports = %+v, want none", clone.Spec.Ports)
+	}
+	if clone.Status.PodName != "" || clone.Status.ClaimName != "" || len(clone.Status.Previews) != 0 {
+		t.Errorf("status copied from source: %+v", clone.Status)
+	}
+	if clone.Spec.Operation != v1.WorkSpaceStart || clone.Spec.SourceWorkspace != "source" || clone.Spec.SourceSnapshot != "snap" {
+		t.Errorf("operation %q, source %q, snapshot %q", clone.Spec.Operation, clone.Spec.SourceWorkspace, clone.Spec.SourceSnapshot)
+	}
+	if clone.Spec.Image != source.Spec.Image || clone.Spec.Template != source.Spec.Template || len(clone.Spec.Env) != 1 {
+		t.Errorf("configuration not copied from source: %+v", clone.Spec)
+	}
+}
+
+func TestCloneSpaceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		option *pb.CloneOption
+		want   codes.Code
+	}{
+		{
+			name:   "source not exist",
+			option: &pb.CloneOption{Name: "clone", Namespace: "default", SourceName: "missing"},
+			want:   codes.NotFound,
+		},
+		{
+			name:   "target exist",
+			option: &pb.CloneOption{Name: "other", Namespace: "default", SourceName: "source"},
+			want:   codes.AlreadyExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, newTestWorkSpace("source"), newTestWorkSpace("other"))
+			_, err := s.CloneSpace(context.Background(), tt.option)
+			if status.Code(err) != tt.want {
+				t.Errorf("CloneSpace() error = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopAndDeleteSpace(t *testing.T) {
+	space := newTestWorkSpace("ws")
+	space.Spec.Operation = v1.WorkSpaceStart
+	s := newTestService(t, space)
+	ctx := context.Background()
+	query := &pb.QueryOption{Name: "ws", Namespace: "default"}
+
+	if _, err := s.StopSpace(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	wp := &v1.WorkSpace{}
+	if err := s.client.Get(ctx, client.ObjectKeyFromObject(space), wp); err != nil {
+		t.Fatal(err)
+	}
+	if wp.Spec.Operation != v1.WorkSpaceStop {
+		t.Errorf("operation = %q, want %q", wp.Spec.Operation, v1.WorkSpaceStop)
+	}
+
+	if _, err := s.DeleteSpace(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StopSpace(ctx, query); status.Code(err) != codes.NotFound {
+		t.Errorf("StopSpace() after delete error = %v, want NotFound", err)
+	}
+	if _, err := s.DeleteSpace(ctx, query); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteSpace() after delete error = %v, want NotFound", err)
+	}
+}
+
+func TestGetPodSpaceStatus(t *testing.T) {
+	space := newTestWorkSpace("ws")
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: space.Status.PodName, Namespace: "default"}}
+	pod.Status.Phase = corev1.PodRunning
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    *pb.WorkspaceStatus
+	}{
+		{
+			name:    "running",
+			objects: []client.Object{space, pod},
+			want:    &pb.WorkspaceStatus{Status: PodExit, Message: string(corev1.PodRunning)},
+		},
+		{
+			name:    "pod not exist",
+			objects: []client.Object{space},
+			want:    &pb.WorkspaceStatus{Status: PodNotExist, Message: "NotExist"},
+		},
+		{
+			name: "workspace not exist",
+			want: &pb.WorkspaceStatus{Status: PodNotExist, Message: "NotExist"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, tt.objects...)
+			got, err := s.GetPodSpaceStatus(context.Background(), &pb.QueryOption{Name: "ws", Namespace: "default"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.want.Status || got.Message != tt.want.Message {
+				t.Errorf("GetPodSpaceStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstructEnv(t *testing.T) {
+	got := constructEnv(map[string]string{"TZ": "Asia/Shanghai", "GOPROXY": "direct", "EDITOR": "vim"})
+	want := []corev1.EnvVar{
+		{Name: "EDITOR", Value: "vim"},
+		{Name: "GOPROXY", Value: "direct"},
+		{Name: "TZ", Value: "Asia/Shanghai"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("constructEnv() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("env[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if env := constructEnv(nil); env != nil {
+		t.Errorf("constructEnv(nil) = %v, want nil", env)
+	}
+}